@@ -0,0 +1,23 @@
+package inboxer
+
+import "time"
+
+// Clock provides the current time and timers, so time-dependent behavior can be tested deterministically
+type Clock interface {
+	Now() time.Time                                           // Current time
+	NewTimer(d time.Duration) (<-chan time.Time, func() bool) // Channel that receives the time once d has elapsed, and a function that stops the timer
+}
+
+// SystemClock is the Clock backed by the time package
+type SystemClock struct{}
+
+// Now returns the current local time
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer starts a time.Timer that fires after d and returns its channel and Stop method
+func (SystemClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	timer := time.NewTimer(d)
+	return timer.C, timer.Stop
+}
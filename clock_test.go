@@ -0,0 +1,37 @@
+package inboxer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weedbox/inboxer/inboxertest"
+)
+
+// The fake clock can be used wherever a Clock is accepted
+var _ Clock = (*inboxertest.FakeClock)(nil)
+
+func TestSystemClock(t *testing.T) {
+	clock := SystemClock{}
+
+	before := time.Now()
+	now := clock.Now()
+	assert.False(t, now.Before(before))
+
+	timer, stop := clock.NewTimer(time.Millisecond)
+	select {
+	case <-timer:
+	case <-time.After(time.Second):
+		t.Fatal("timer did not fire")
+	}
+	assert.False(t, stop())
+
+	// A stopped timer never fires
+	timer, stop = clock.NewTimer(time.Millisecond)
+	assert.True(t, stop())
+	select {
+	case <-timer:
+		t.Fatal("stopped timer fired")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weedbox/inboxer"
+	"github.com/weedbox/inboxer/internal/dsn"
+)
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	from := "sqlite:" + filepath.Join(dir, "from.db")
+	to := "sqlite:" + filepath.Join(dir, "to.db")
+	checkpoint := filepath.Join(dir, "migrate.ckpt")
+
+	_, err := dsn.Migrate(ctx, from, false)
+	require.NoError(t, err)
+	src, err := dsn.OpenStore(from)
+	require.NoError(t, err)
+	ids, err := src.CreateBatchMails(ctx, []*inboxer.Mail{
+		{RecipientID: "user1", Title: "First"},
+		{RecipientID: "user1", Title: "Second"},
+		{RecipientID: "user2", Title: "Third"},
+	})
+	require.NoError(t, err)
+
+	// Mails are copied and verified, and the last ID is checkpointed
+	var stdout, stderr bytes.Buffer
+	err = run(ctx, []string{"-from", from, "-to", to, "-checkpoint", checkpoint, "-batch-size", "2", "-verify", "-migrate-dest"}, &stdout, &stderr)
+	require.NoError(t, err, stderr.String())
+	assert.Equal(t, "copied 3, overwritten 0, skipped 0\nverified 3, missing 0, mismatched 0\n", stdout.String())
+	assert.Contains(t, stderr.String(), "batch 2:")
+	assert.Contains(t, stderr.String(), fmt.Sprintf("applied %d migrations to the destination", len(inboxer.MailMigrations())))
+
+	data, err := os.ReadFile(checkpoint)
+	require.NoError(t, err)
+	assert.Equal(t, ids[2]+"\n", string(data))
+
+	// A second run resumes after the checkpoint
+	_, err = src.CreateMail(ctx, &inboxer.Mail{RecipientID: "user1", Title: "Fourth"})
+	require.NoError(t, err)
+	stdout.Reset()
+	stderr.Reset()
+	err = run(ctx, []string{"-from", from, "-to", to, "-checkpoint", checkpoint}, &stdout, &stderr)
+	require.NoError(t, err, stderr.String())
+	assert.Equal(t, "copied 1, overwritten 0, skipped 0\n", stdout.String())
+	assert.Contains(t, stderr.String(), "resuming after mail "+ids[2])
+
+	dst, err := dsn.OpenStore(to)
+	require.NoError(t, err)
+	_, total, err := dst.QueryMails(ctx, nil, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 4, total)
+}
+
+func TestRunErrors(t *testing.T) {
+	ctx := context.Background()
+	var stdout, stderr bytes.Buffer
+
+	err := run(ctx, []string{"-from", "sqlite:" + filepath.Join(t.TempDir(), "a.db")}, &stdout, &stderr)
+	assert.ErrorContains(t, err, "both -from and -to are required")
+
+	err = run(ctx, []string{"-from", "oracle:db", "-to", "sqlite::memory:"}, &stdout, &stderr)
+	assert.ErrorContains(t, err, `unsupported driver "oracle"`)
+
+	// Neither store is created or migrated implicitly
+	dir := t.TempDir()
+	from := filepath.Join(dir, "from.db")
+	to := filepath.Join(dir, "to.db")
+	err = run(ctx, []string{"-from", from, "-to", to, "-migrate-dest"}, &stdout, &stderr)
+	assert.ErrorContains(t, err, "failed to open source store")
+	_, err = os.Stat(from)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = dsn.Migrate(ctx, from, false)
+	require.NoError(t, err)
+	err = run(ctx, []string{"-from", from, "-to", to}, &stdout, &stderr)
+	assert.ErrorContains(t, err, "failed to open destination store")
+	_, err = os.Stat(to)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// A source with an outdated schema is left alone
+	old := filepath.Join(dir, "old.db")
+	db, err := dsn.Open(old, true)
+	require.NoError(t, err)
+	migrator, err := inboxer.NewMigrator(db)
+	require.NoError(t, err)
+	_, err = migrator.Migrate(ctx, inboxer.MigrateOptions{TargetVersion: 1})
+	require.NoError(t, err)
+	err = run(ctx, []string{"-from", old, "-to", to, "-migrate-dest"}, &stdout, &stderr)
+	assert.ErrorIs(t, err, dsn.ErrPendingMigrations)
+	version, err := migrator.CurrentVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, version)
+
+	err = run(ctx, []string{"-unknown"}, &stdout, &stderr)
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(stderr.String(), "Usage: inboxer-migrate"))
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/weedbox/inboxer"
+	"github.com/weedbox/inboxer/internal/dsn"
+)
+
+// runMigrate creates the database or applies its pending schema migrations
+func runMigrate(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet(a, "migrate", "[flags]")
+	dryRun := fs.Bool("dry-run", false, "only list the pending migrations")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	migrations, err := dsn.Migrate(ctx, a.db, *dryRun)
+	for _, migration := range migrations {
+		fmt.Fprintf(a.stdout, "%d %s\n", migration.Version, migration.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		fmt.Fprintf(a.stdout, "%d pending migrations\n", len(migrations))
+	} else {
+		fmt.Fprintf(a.stdout, "applied %d migrations\n", len(migrations))
+	}
+	return nil
+}
+
+// runSend sends a single mail
+func runSend(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet(a, "send", "-to recipient -title title [flags]")
+	to := fs.String("to", "", "recipient ID")
+	var mf mailFlags
+	mf.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *to == "" {
+		return errors.New("-to is required")
+	}
+
+	mail, err := mf.mail()
+	if err != nil {
+		return err
+	}
+	mail.RecipientID = *to
+
+	id, err := a.manager.SendMail(ctx, mail)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(a.stdout, id)
+	return nil
+}
+
+// runBatchSend sends the same mail to every recipient in the first column of a CSV file
+func runBatchSend(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet(a, "batch-send", "-recipients file.csv -title title [flags]")
+	recipients := fs.String("recipients", "", `CSV file with recipient IDs in the first column, "-" reads stdin`)
+	chunkSize := fs.Int("chunk-size", 1000, "recipients sent per batch")
+	var mf mailFlags
+	mf.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *recipients == "" {
+		return errors.New("-recipients is required")
+	}
+	if *chunkSize <= 0 {
+		return errors.New("-chunk-size must be positive")
+	}
+
+	mail, err := mf.mail()
+	if err != nil {
+		return err
+	}
+	recipientIDs, err := readRecipients(*recipients)
+	if err != nil {
+		return err
+	}
+
+	sent := 0
+	for start := 0; start < len(recipientIDs); start += *chunkSize {
+		end := min(start+*chunkSize, len(recipientIDs))
+		ids, err := a.manager.SendBatchMail(ctx, mail, recipientIDs[start:end])
+		if err != nil {
+			return fmt.Errorf("sent %d of %d mails: %w", sent, len(recipientIDs), err)
+		}
+		sent += len(ids)
+	}
+	fmt.Fprintf(a.stdout, "sent %d mails\n", sent)
+	return nil
+}
+
+// readRecipients reads recipient IDs from the first column of a CSV file, skipping a recipient_id header
+func readRecipients(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open recipients: %w", err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var ids []string
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read recipients: %w", err)
+		}
+
+		id := strings.TrimSpace(record[0])
+		if id == "" || (line == 1 && strings.EqualFold(id, "recipient_id")) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no recipients found")
+	}
+	return ids, nil
+}
+
+// runQuery lists mails matching the filter flags
+func runQuery(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet(a, "query", "[flags]")
+	var ff filterFlags
+	ff.register(fs)
+	page := fs.Int("page", 1, "page number")
+	size := fs.Int("size", 20, "mails per page")
+	asJSON := fs.Bool("json", false, "print mails as JSON")
+	sortOrder := fs.String("sort", "", "order: newest, oldest, unread_first, priority or expiring_soonest")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	filter, err := ff.filter()
+	if err != nil {
+		return err
+	}
+	filter.Sort = inboxer.SortOrder(*sortOrder)
+	mails, total, err := a.manager.QueryMails(ctx, filter, *page, *size)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(a.stdout, mails)
+	}
+	if err := printMails(a.stdout, mails); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stdout, "%d of %d mails, page %d\n", len(mails), total, *page)
+	return nil
+}
+
+// runExport exports mails matching the filter flags
+func runExport(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet(a, "export", "[flags]")
+	var ff filterFlags
+	ff.register(fs)
+	format := fs.String("format", string(inboxer.ExportFormatNDJSON), "output format: json, ndjson or csv")
+	columns := fs.String("columns", "", "comma separated CSV columns, empty exports all")
+	output := fs.String("o", "", "output file, empty writes to stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	filter, err := ff.filter()
+	if err != nil {
+		return err
+	}
+
+	w := a.stdout
+	var file *os.File
+	if *output != "" {
+		file, err = os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output: %w", err)
+		}
+		w = file
+	}
+
+	count, err := a.manager.ExportMails(ctx, w, filter, inboxer.ExportOptions{
+		Format:  inboxer.ExportFormat(*format),
+		Columns: splitList(*columns),
+	})
+	if file != nil {
+		// A failed close can leave the file truncated, so it fails the export
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to write output: %w", closeErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stderr, "exported %d mails\n", count)
+	return nil
+}
+
+// runDeleteExpired deletes expired mails
+func runDeleteExpired(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet(a, "delete-expired", "[flags]")
+	dryRun := fs.Bool("dry-run", false, "only count the expired mails")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *dryRun {
+		// Starred mails are never deleted
+		starred := false
+		_, total, err := a.manager.QueryMails(ctx, &inboxer.MailFilter{ExpiredOnly: true, Starred: &starred}, 1, 1)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(a.stdout, "%d expired mails\n", total)
+		return nil
+	}
+
+	count, err := a.manager.DeleteExpiredMails(ctx)
+	if err != nil {
+		return fmt.Errorf("deleted %d mails: %w", count, err)
+	}
+	fmt.Fprintf(a.stdout, "deleted %d expired mails\n", count)
+	return nil
+}
+
+// runInbox shows a page of a recipient's mailbox
+func runInbox(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet(a, "inbox", "[flags] recipient")
+	page := fs.Int("page", 1, "page number")
+	size := fs.Int("size", 20, "mails per page")
+	sortOrder := fs.String("sort", "", "order after pinned mails: newest, oldest, unread_first, priority or expiring_soonest")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("exactly one recipient is required")
+	}
+	recipientID := fs.Arg(0)
+
+	mails, total, err := a.manager.GetMailsByRecipient(ctx, recipientID, *page, *size, inboxer.SortOrder(*sortOrder))
+	if err != nil {
+		return err
+	}
+	unread, err := a.manager.CountUnreadMails(ctx, recipientID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(a.stdout, "%s: %d mails, %d unread\n", recipientID, total, unread)
+	return printMails(a.stdout, mails)
+}
+
+// runCounts shows a recipient's mail counts
+func runCounts(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet(a, "counts", "recipient")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("exactly one recipient is required")
+	}
+	recipientID := fs.Arg(0)
+
+	unread, err := a.manager.CountUnreadMails(ctx, recipientID)
+	if err != nil {
+		return err
+	}
+	attachments, err := a.manager.CountMailsWithAttachments(ctx, recipientID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(a.stdout, "unread: %d\nwith attachments: %d\n", unread, attachments)
+	return nil
+}
+
+// runGroup counts mails matching the filter flags in groups
+func runGroup(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet(a, "group", "-by grouping [flags]")
+	var ff filterFlags
+	ff.register(fs)
+	by := fs.String("by", "", "grouping: tag, sender, read_status, day or folder (required)")
+	asJSON := fs.Bool("json", false, "print groups as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *by == "" {
+		fs.Usage()
+		return errors.New("-by is required")
+	}
+
+	filter, err := ff.filter()
+	if err != nil {
+		return err
+	}
+	groups, err := a.manager.CountBy(ctx, filter, inboxer.GroupBy(*by))
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(a.stdout, groups)
+	}
+	tw := tabwriter.NewWriter(a.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tMAILS\n", strings.ToUpper(*by))
+	for _, group := range groups {
+		fmt.Fprintf(tw, "%s\t%d\n", group.Key, group.Count)
+	}
+	return tw.Flush()
+}
+
+// printMails prints mails as a table
+func printMails(w io.Writer, mails []*inboxer.Mail) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSENDER\tRECIPIENT\tTITLE\tREAD\tCREATED\tEXPIRES")
+	for _, mail := range mails {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%t\t%s\t%s\n",
+			mail.ID, mail.SenderID, mail.RecipientID, mail.Title, mail.ReadStatus,
+			formatTime(mail.CreateTime), formatTime(mail.ExpireTime))
+	}
+	return tw.Flush()
+}
+
+// printJSON prints a value as indented JSON
+func printJSON(w io.Writer, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// formatTime formats a time for tables, showing "-" for zero times
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/weedbox/inboxer"
+)
+
+// newFlagSet creates the flag set of a command
+func newFlagSet(a *app, name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: inboxer %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// filterFlags are the MailFilter flags shared by the query and export commands
+type filterFlags struct {
+	sender    string
+	recipient string
+	read      string
+	since     string
+	until     string
+	expired   bool
+	tags      string
+	folder    string
+	text      string
+	query     string
+}
+
+// register adds the filter flags to a flag set
+func (f *filterFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.sender, "sender", "", "only mails from this sender")
+	fs.StringVar(&f.recipient, "recipient", "", "only mails to this recipient")
+	fs.StringVar(&f.read, "read", "", "only read (true) or unread (false) mails")
+	fs.StringVar(&f.since, "since", "", "only mails created at or after this time (RFC 3339 or YYYY-MM-DD)")
+	fs.StringVar(&f.until, "until", "", "only mails created at or before this time (RFC 3339 or YYYY-MM-DD)")
+	fs.BoolVar(&f.expired, "expired", false, "only expired mails")
+	fs.StringVar(&f.tags, "tags", "", "only mails with any of these comma separated tags")
+	fs.StringVar(&f.folder, "folder", "", "only mails in this folder")
+	fs.StringVar(&f.text, "text", "", "only mails with all of these words in the title or content, ranked by relevance")
+	fs.StringVar(&f.query, "q", "", `query expression, e.g. "(tag:event OR sender:system) AND NOT read"`)
+}
+
+// filter builds the MailFilter from the flags
+func (f *filterFlags) filter() (*inboxer.MailFilter, error) {
+	filter := &inboxer.MailFilter{
+		SenderID:    f.sender,
+		RecipientID: f.recipient,
+		ExpiredOnly: f.expired,
+		Tags:        splitList(f.tags),
+		Folder:      f.folder,
+		Text:        f.text,
+	}
+
+	if f.read != "" {
+		read, err := strconv.ParseBool(f.read)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -read %q: %w", f.read, err)
+		}
+		filter.ReadStatus = &read
+	}
+	if f.since != "" {
+		since, err := parseTime(f.since)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -since: %w", err)
+		}
+		filter.StartTime = &since
+	}
+	if f.until != "" {
+		until, err := parseTime(f.until)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -until: %w", err)
+		}
+		filter.EndTime = &until
+	}
+	if f.query != "" {
+		expr, err := inboxer.ParseQuery(f.query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -q: %w", err)
+		}
+		filter.Expr = expr
+	}
+	return filter, nil
+}
+
+// mailFlags describe the mail sent by the send and batch-send commands
+type mailFlags struct {
+	sender      string
+	title       string
+	content     string
+	attachments string
+	tags        string
+	priority    int
+	folder      string
+	expire      time.Duration
+}
+
+// register adds the mail flags to a flag set
+func (f *mailFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.sender, "sender", inboxer.SystemSenderID, "sender ID")
+	fs.StringVar(&f.title, "title", "", "mail title")
+	fs.StringVar(&f.content, "content", "", "mail content")
+	fs.StringVar(&f.attachments, "attachments", "", `attachments as a JSON object, e.g. {"coins":100}`)
+	fs.StringVar(&f.tags, "tags", "", "comma separated tags")
+	fs.IntVar(&f.priority, "priority", 0, "priority, higher values are more important")
+	fs.StringVar(&f.folder, "folder", "", "folder, e.g. system, rewards, social or guild (default inbox)")
+	fs.DurationVar(&f.expire, "expire", 0, "time until the mail expires, 0 means it never expires")
+}
+
+// mail builds the mail from the flags
+func (f *mailFlags) mail() (*inboxer.Mail, error) {
+	if f.title == "" {
+		return nil, fmt.Errorf("-title is required")
+	}
+
+	mail := &inboxer.Mail{
+		SenderID: f.sender,
+		Title:    f.title,
+		Content:  f.content,
+		Tags:     splitList(f.tags),
+		Priority: f.priority,
+		Folder:   f.folder,
+	}
+	if f.attachments != "" {
+		if err := json.Unmarshal([]byte(f.attachments), &mail.Attachments); err != nil {
+			return nil, fmt.Errorf("invalid -attachments: %w", err)
+		}
+	}
+	if f.expire < 0 {
+		return nil, fmt.Errorf("-expire cannot be negative")
+	}
+	if f.expire > 0 {
+		mail.ExpireTime = time.Now().Add(f.expire)
+	}
+	return mail, nil
+}
+
+// parseTime parses an RFC 3339 time or a date in local time
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, value, time.Local)
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
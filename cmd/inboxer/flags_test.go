@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weedbox/inboxer"
+)
+
+func TestFilterFlags(t *testing.T) {
+	var ff filterFlags
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	ff.register(fs)
+	require.NoError(t, fs.Parse([]string{
+		"-sender", "system", "-recipient", "player1", "-read", "true",
+		"-since", "2026-01-02", "-until", "2026-01-03T10:00:00Z", "-expired", "-tags", "a,,b",
+		"-text", "gold reward", "-q", "tag:event OR NOT read",
+	}))
+
+	filter, err := ff.filter()
+	require.NoError(t, err)
+	assert.Equal(t, "system", filter.SenderID)
+	assert.Equal(t, "player1", filter.RecipientID)
+	require.NotNil(t, filter.ReadStatus)
+	assert.True(t, *filter.ReadStatus)
+	assert.Equal(t, time.Date(2026, 1, 2, 0, 0, 0, 0, time.Local), *filter.StartTime)
+	assert.Equal(t, time.Date(2026, 1, 3, 10, 0, 0, 0, time.UTC), filter.EndTime.UTC())
+	assert.True(t, filter.ExpiredOnly)
+	assert.Equal(t, []string{"a", "b"}, filter.Tags)
+	assert.Equal(t, "gold reward", filter.Text)
+	assert.Equal(t, "tag:event OR NOT is:read", filter.Expr.String())
+
+	ff.since = "yesterday"
+	_, err = ff.filter()
+	assert.ErrorContains(t, err, "invalid -since")
+
+	ff.since = ""
+	ff.query = "tag:event OR"
+	_, err = ff.filter()
+	assert.ErrorIs(t, err, inboxer.ErrInvalidQuery)
+}
+
+func TestMailFlags(t *testing.T) {
+	mf := mailFlags{title: "Hello", attachments: `{"coins":100}`, expire: time.Hour}
+	mail, err := mf.mail()
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", mail.Title)
+	assert.Equal(t, float64(100), mail.Attachments["coins"])
+	assert.WithinDuration(t, time.Now().Add(time.Hour), mail.ExpireTime, time.Minute)
+
+	mf.title = ""
+	_, err = mf.mail()
+	assert.ErrorContains(t, err, "-title is required")
+
+	mf = mailFlags{title: "Hello", expire: -time.Hour}
+	_, err = mf.mail()
+	assert.ErrorContains(t, err, "cannot be negative")
+}
@@ -0,0 +1,132 @@
+// Command inboxer is an admin tool for inboxer mail databases.
+//
+// Usage:
+//
+//	inboxer [-db driver:dsn] [-v] <command> [flags]
+//
+// The database is required, given with -db or $INBOXER_DB. Commands never change its schema:
+// run "inboxer migrate" to create a database or bring it to the current schema.
+// Run "inboxer <command> -h" for the flags of a command.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/weedbox/inboxer"
+	"github.com/weedbox/inboxer/internal/dsn"
+)
+
+// app holds what every command operates on
+type app struct {
+	db      string            // Store string of the database
+	store   inboxer.MailStore // Not opened for the migrate command
+	manager inboxer.MailManager
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+// command is a subcommand of the tool
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, a *app, args []string) error
+}
+
+var commands = []command{
+	{"migrate", "create the database or apply pending schema migrations", runMigrate},
+	{"send", "send a mail to a recipient", runSend},
+	{"batch-send", "send a mail to every recipient listed in a CSV file", runBatchSend},
+	{"query", "list mails matching a filter", runQuery},
+	{"export", "export mails matching a filter as JSON, NDJSON or CSV", runExport},
+	{"delete-expired", "delete expired mails", runDeleteExpired},
+	{"inbox", "show a recipient's mailbox", runInbox},
+	{"counts", "show a recipient's unread and attachment counts", runCounts},
+	{"group", "count mails matching a filter by tag, sender, read status, day or folder", runGroup},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, "inboxer:", err)
+		os.Exit(1)
+	}
+}
+
+// run parses the global flags and runs the selected command
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("inboxer", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	db := fs.String("db", os.Getenv("INBOXER_DB"), "database as driver:dsn or a SQLite file path, defaults to $INBOXER_DB")
+	verbose := fs.Bool("v", false, "log every operation")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: inboxer [-db driver:dsn] [-v] <command> [flags]")
+		fmt.Fprintln(fs.Output(), "\nCommands:")
+		for _, cmd := range commands {
+			fmt.Fprintf(fs.Output(), "  %-15s %s\n", cmd.name, cmd.summary)
+		}
+		fmt.Fprintln(fs.Output(), "\nFlags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no command given")
+	}
+
+	name := fs.Arg(0)
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", name)
+	}
+	if *db == "" {
+		return errors.New("no database given, set -db or $INBOXER_DB")
+	}
+
+	// Only warnings and errors are logged unless asked for
+	level := slog.LevelWarn
+	if *verbose {
+		level = slog.LevelInfo
+	}
+	logger := slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: level}))
+
+	a := &app{
+		db:     *db,
+		stdout: stdout,
+		stderr: stderr,
+	}
+
+	// The migrate command works on the database itself, every other command needs an up to date schema
+	if cmd.name != "migrate" {
+		store, err := dsn.OpenStore(*db, inboxer.WithStoreLogger(logger))
+		if errors.Is(err, dsn.ErrPendingMigrations) {
+			return fmt.Errorf("failed to open database: %w, run \"inboxer migrate\" first", err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open database: %w", err)
+		}
+		a.store = store
+		a.manager = inboxer.NewDefaultMailManager(store, inboxer.WithLogger(logger))
+	}
+	return cmd.run(ctx, a, fs.Args()[1:])
+}
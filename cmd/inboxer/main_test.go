@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weedbox/inboxer"
+)
+
+// runTool runs the tool against a database and returns its output
+func runTool(t *testing.T, db string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), append([]string{"-db", db}, args...), &stdout, &stderr)
+	if err != nil {
+		t.Logf("stderr: %s", stderr.String())
+	}
+	return stdout.String(), err
+}
+
+// newDB creates a database with the current schema in dir
+func newDB(t *testing.T, dir string) string {
+	db := filepath.Join(dir, "mails.db")
+	_, err := runTool(t, db, "migrate")
+	require.NoError(t, err)
+	return db
+}
+
+func TestSendAndInbox(t *testing.T) {
+	db := newDB(t, t.TempDir())
+
+	out, err := runTool(t, db, "send", "-to", "player1", "-title", "Welcome", "-content", "Hello", "-attachments", `{"coins":100}`, "-tags", "event, welcome", "-expire", "24h")
+	require.NoError(t, err)
+	id := strings.TrimSpace(out)
+	assert.NotEmpty(t, id)
+
+	out, err = runTool(t, db, "inbox", "player1")
+	require.NoError(t, err)
+	assert.Contains(t, out, "player1: 1 mails, 1 unread")
+	assert.Contains(t, out, id)
+	assert.Contains(t, out, "Welcome")
+
+	out, err = runTool(t, db, "counts", "player1")
+	require.NoError(t, err)
+	assert.Equal(t, "unread: 1\nwith attachments: 1\n", out)
+
+	// Invalid input is rejected
+	_, err = runTool(t, db, "send", "-title", "No recipient")
+	assert.ErrorContains(t, err, "-to is required")
+	_, err = runTool(t, db, "send", "-to", "player1", "-title", "Bad", "-attachments", "coins")
+	assert.ErrorContains(t, err, "invalid -attachments")
+	_, err = runTool(t, db, "inbox")
+	assert.ErrorContains(t, err, "exactly one recipient")
+	_, err = runTool(t, db, "inbox", "-sort", "random", "player1")
+	assert.ErrorContains(t, err, `unknown sort order "random"`)
+}
+
+func TestBatchSendAndQuery(t *testing.T) {
+	dir := t.TempDir()
+	db := newDB(t, dir)
+	recipients := filepath.Join(dir, "recipients.csv")
+	require.NoError(t, os.WriteFile(recipients, []byte("recipient_id,name\nplayer1,Alice\nplayer2,Bob\n\nplayer3\n"), 0o644))
+
+	out, err := runTool(t, db, "batch-send", "-recipients", recipients, "-chunk-size", "2", "-title", "Compensation", "-tags", "compensation")
+	require.NoError(t, err)
+	assert.Equal(t, "sent 3 mails\n", out)
+
+	_, err = runTool(t, db, "send", "-to", "player1", "-sender", "player2", "-title", "Gift", "-priority", "5")
+	require.NoError(t, err)
+
+	// Filters narrow the results
+	out, err = runTool(t, db, "query", "-tags", "compensation")
+	require.NoError(t, err)
+	assert.Contains(t, out, "3 of 3 mails, page 1")
+
+	out, err = runTool(t, db, "query", "-recipient", "player1", "-sender", "player2", "-read", "false", "-json")
+	require.NoError(t, err)
+	var mails []*inboxer.Mail
+	require.NoError(t, json.Unmarshal([]byte(out), &mails))
+	require.Len(t, mails, 1)
+	assert.Equal(t, "Gift", mails[0].Title)
+
+	// Priority order puts the gift first
+	out, err = runTool(t, db, "query", "-recipient", "player1", "-sort", "priority", "-json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal([]byte(out), &mails))
+	require.Len(t, mails, 2)
+	assert.Equal(t, "Gift", mails[0].Title)
+	assert.Equal(t, 5, mails[0].Priority)
+
+	_, err = runTool(t, db, "query", "-read", "maybe")
+	assert.ErrorContains(t, err, "invalid -read")
+	_, err = runTool(t, db, "query", "-sort", "random")
+	assert.ErrorIs(t, err, inboxer.ErrInvalidQuery)
+
+	// Grouped counts
+	out, err = runTool(t, db, "group", "-by", "sender", "-recipient", "player1")
+	require.NoError(t, err)
+	assert.Regexp(t, `SENDER\s+MAILS\nplayer2\s+1\nsystem\s+1\n`, out)
+
+	out, err = runTool(t, db, "group", "-by", "tag", "-json")
+	require.NoError(t, err)
+	var groups []inboxer.GroupCount
+	require.NoError(t, json.Unmarshal([]byte(out), &groups))
+	assert.Equal(t, []inboxer.GroupCount{{Key: "compensation", Count: 3}}, groups)
+
+	// Folders
+	_, err = runTool(t, db, "send", "-to", "player1", "-title", "Guild news", "-folder", "guild")
+	require.NoError(t, err)
+	out, err = runTool(t, db, "group", "-by", "folder", "-recipient", "player1", "-read", "false")
+	require.NoError(t, err)
+	assert.Regexp(t, `FOLDER\s+MAILS\nguild\s+1\ninbox\s+2\n`, out)
+	out, err = runTool(t, db, "query", "-folder", "guild")
+	require.NoError(t, err)
+	assert.Contains(t, out, "1 of 1 mails, page 1")
+
+	_, err = runTool(t, db, "group")
+	assert.ErrorContains(t, err, "-by is required")
+	_, err = runTool(t, db, "group", "-by", "recipient")
+	assert.ErrorIs(t, err, inboxer.ErrInvalidQuery)
+}
+
+func TestExportAndDeleteExpired(t *testing.T) {
+	dir := t.TempDir()
+	db := newDB(t, dir)
+
+	_, err := runTool(t, db, "send", "-to", "player1", "-title", "Kept")
+	require.NoError(t, err)
+	_, err = runTool(t, db, "send", "-to", "player1", "-title", "Expired", "-expire", "1ns")
+	require.NoError(t, err)
+
+	output := filepath.Join(dir, "mails.csv")
+	_, err = runTool(t, db, "export", "-recipient", "player1", "-format", "csv", "-columns", "title", "-o", output)
+	require.NoError(t, err)
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"title", "Kept", "Expired"}, strings.Fields(string(data)))
+
+	out, err := runTool(t, db, "delete-expired", "-dry-run")
+	require.NoError(t, err)
+	assert.Equal(t, "1 expired mails\n", out)
+
+	out, err = runTool(t, db, "delete-expired")
+	require.NoError(t, err)
+	assert.Equal(t, "deleted 1 expired mails\n", out)
+
+	out, err = runTool(t, db, "export")
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(out, "\n"))
+	assert.Contains(t, out, `"Title":"Kept"`)
+}
+
+func TestRunErrors(t *testing.T) {
+	db := newDB(t, t.TempDir())
+
+	_, err := runTool(t, db)
+	assert.ErrorContains(t, err, "no command given")
+
+	_, err = runTool(t, db, "resend")
+	assert.ErrorContains(t, err, `unknown command "resend"`)
+
+	_, err = runTool(t, "oracle:db", "counts", "player1")
+	assert.ErrorContains(t, err, "failed to open database")
+
+	// The database must be given explicitly and exist
+	t.Setenv("INBOXER_DB", "")
+	err = run(context.Background(), []string{"counts", "player1"}, io.Discard, io.Discard)
+	assert.ErrorContains(t, err, "no database given")
+
+	missing := filepath.Join(t.TempDir(), "missing.db")
+	_, err = runTool(t, missing, "counts", "player1")
+	assert.ErrorContains(t, err, "does not exist")
+	_, err = os.Stat(missing)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestMigrate(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "mails.db")
+	migrations := len(inboxer.MailMigrations())
+
+	// The migrate command creates the database
+	out, err := runTool(t, db, "migrate")
+	require.NoError(t, err)
+	assert.Contains(t, out, "1 create_mails_table\n")
+	assert.Contains(t, out, fmt.Sprintf("applied %d migrations\n", migrations))
+
+	out, err = runTool(t, db, "migrate", "-dry-run")
+	require.NoError(t, err)
+	assert.Equal(t, "0 pending migrations\n", out)
+
+	// $INBOXER_DB is used without -db
+	t.Setenv("INBOXER_DB", db)
+	var stdout bytes.Buffer
+	require.NoError(t, run(context.Background(), []string{"counts", "player1"}, &stdout, io.Discard))
+	assert.Equal(t, "unread: 0\nwith attachments: 0\n", stdout.String())
+}
@@ -0,0 +1,63 @@
+package inboxer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// GroupBy is the mail field CountBy groups counts by
+type GroupBy string
+
+const (
+	GroupByTag        GroupBy = "tag"         // One group per tag, a mail counts in every group of its tags and untagged mails are not counted
+	GroupBySender     GroupBy = "sender"      // One group per sender ID
+	GroupByReadStatus GroupBy = "read_status" // Groups "true" and "false"
+	GroupByDay        GroupBy = "day"         // One group per creation day in UTC, as YYYY-MM-DD
+	GroupByFolder     GroupBy = "folder"      // One group per folder
+)
+
+// GroupCount is the number of mails in a group
+type GroupCount struct {
+	Key   string // Tag, sender ID, read status, day or folder of the group
+	Count int    // Number of mails in the group
+}
+
+// validate checks that the grouping is known
+func (g GroupBy) validate() error {
+	switch g {
+	case GroupByTag, GroupBySender, GroupByReadStatus, GroupByDay, GroupByFolder:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown group by %q", ErrInvalidQuery, g)
+}
+
+// groupKeys returns the groups a mail counts in
+func groupKeys(mail *Mail, groupBy GroupBy) []string {
+	switch groupBy {
+	case GroupByTag:
+		return uniqueStrings(mail.Tags)
+	case GroupBySender:
+		return []string{mail.SenderID}
+	case GroupByReadStatus:
+		return []string{strconv.FormatBool(mail.ReadStatus)}
+	case GroupByDay:
+		return []string{mail.CreateTime.UTC().Format(time.DateOnly)}
+	case GroupByFolder:
+		return []string{folderOrDefault(mail.Folder)}
+	}
+	return nil
+}
+
+// sortedGroupCounts converts counts by key into groups ordered by key
+func sortedGroupCounts(counts map[string]int) []GroupCount {
+	groups := make([]GroupCount, 0, len(counts))
+	for key, count := range counts {
+		groups = append(groups, GroupCount{Key: key, Count: count})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Key < groups[j].Key
+	})
+	return groups
+}
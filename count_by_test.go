@@ -0,0 +1,38 @@
+package inboxer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupByValidate(t *testing.T) {
+	for _, groupBy := range []GroupBy{GroupByTag, GroupBySender, GroupByReadStatus, GroupByDay, GroupByFolder} {
+		assert.NoError(t, groupBy.validate())
+	}
+	assert.ErrorIs(t, GroupBy("").validate(), ErrInvalidQuery)
+	assert.ErrorIs(t, GroupBy("recipient").validate(), ErrInvalidQuery)
+}
+
+func TestGroupKeys(t *testing.T) {
+	mail := &Mail{
+		SenderID:   "system",
+		Tags:       []string{"event", "reward", "event"},
+		ReadStatus: true,
+		CreateTime: time.Date(2026, 3, 1, 1, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60)),
+	}
+
+	assert.Equal(t, []string{"event", "reward"}, groupKeys(mail, GroupByTag))
+	assert.Equal(t, []string{"system"}, groupKeys(mail, GroupBySender))
+	assert.Equal(t, []string{"true"}, groupKeys(mail, GroupByReadStatus))
+	assert.Equal(t, []string{"2026-02-28"}, groupKeys(mail, GroupByDay))
+	assert.Equal(t, []string{FolderInbox}, groupKeys(mail, GroupByFolder))
+	assert.Empty(t, groupKeys(&Mail{}, GroupByTag))
+}
+
+func TestSortedGroupCounts(t *testing.T) {
+	groups := sortedGroupCounts(map[string]int{"b": 2, "a": 1, "c": 3})
+	assert.Equal(t, []GroupCount{{Key: "a", Count: 1}, {Key: "b", Count: 2}, {Key: "c", Count: 3}}, groups)
+	assert.Empty(t, sortedGroupCounts(nil))
+}
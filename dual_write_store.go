@@ -0,0 +1,360 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DualWriteMailStore sends writes to a primary and a secondary store during a cutover between stores.
+// The primary store is authoritative: its results are returned, while failures of the secondary store are only logged.
+// Reads are served by the primary store and compared with the secondary store, logging any mismatches.
+type DualWriteMailStore struct {
+	primary   MailStore
+	secondary MailStore
+	logger    *slog.Logger
+}
+
+// NewDualWriteMailStore creates a store writing to both stores and reading from the primary.
+// Only the logger option applies.
+func NewDualWriteMailStore(primary, secondary MailStore, opts ...StoreOption) *DualWriteMailStore {
+	options := newStoreOptions(storeOptions{logger: slog.Default()}, opts...)
+	return &DualWriteMailStore{
+		primary:   primary,
+		secondary: secondary,
+		logger:    options.logger,
+	}
+}
+
+// CreateMail creates a mail in both stores with the ID assigned by the primary store
+func (s *DualWriteMailStore) CreateMail(ctx context.Context, mail *Mail) (string, error) {
+	id, err := s.primary.CreateMail(ctx, mail)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := s.secondary.CreateMail(ctx, copyMail(mail)); err != nil {
+		s.secondaryFailed(ctx, "CreateMail", err, "mail_id", id)
+	}
+	return id, nil
+}
+
+// GetMail reads a mail from the primary store and compares it with the secondary store
+func (s *DualWriteMailStore) GetMail(ctx context.Context, mailID string) (*Mail, error) {
+	mail, err := s.primary.GetMail(ctx, mailID)
+	if err != nil {
+		return nil, err
+	}
+
+	other, err := s.secondary.GetMail(ctx, mailID)
+	if errors.Is(err, ErrMailNotFound) {
+		s.mismatch(ctx, "GetMail", "mail_id", mailID, "missing", true)
+	} else if err != nil {
+		s.secondaryFailed(ctx, "GetMail", err, "mail_id", mailID)
+	} else if fields := diffMails(mail, other); len(fields) > 0 {
+		s.mismatch(ctx, "GetMail", "mail_id", mailID, "fields", fields)
+	}
+	return mail, nil
+}
+
+// UpdateMail updates a mail in the primary store and writes the result to the secondary store.
+// The secondary store has its own versions, so the mail is written there at its current version, or created if missing.
+func (s *DualWriteMailStore) UpdateMail(ctx context.Context, mail *Mail) error {
+	if err := s.primary.UpdateMail(ctx, mail); err != nil {
+		return err
+	}
+
+	s.upsertSecondary(ctx, "UpdateMail", copyMail(mail))
+	return nil
+}
+
+// DeleteMail deletes a mail from both stores
+func (s *DualWriteMailStore) DeleteMail(ctx context.Context, mailID string) error {
+	if err := s.primary.DeleteMail(ctx, mailID); err != nil {
+		return err
+	}
+
+	if err := s.secondary.DeleteMail(ctx, mailID); err != nil {
+		if errors.Is(err, ErrMailNotFound) {
+			s.mismatch(ctx, "DeleteMail", "mail_id", mailID, "missing", true)
+		} else {
+			s.secondaryFailed(ctx, "DeleteMail", err, "mail_id", mailID)
+		}
+	}
+	return nil
+}
+
+// CreateBatchMails creates mails in both stores with the IDs assigned by the primary store
+func (s *DualWriteMailStore) CreateBatchMails(ctx context.Context, mails []*Mail) ([]string, error) {
+	ids, err := s.primary.CreateBatchMails(ctx, mails)
+	if err != nil {
+		return nil, err
+	}
+
+	copies := make([]*Mail, 0, len(mails))
+	for _, mail := range mails {
+		if mail != nil {
+			copies = append(copies, copyMail(mail))
+		}
+	}
+	if _, err := s.secondary.CreateBatchMails(ctx, copies); err != nil {
+		s.secondaryFailed(ctx, "CreateBatchMails", err, "count", len(copies))
+	}
+	return ids, nil
+}
+
+// DeleteMailsByRecipient deletes all mails of a recipient from both stores
+func (s *DualWriteMailStore) DeleteMailsByRecipient(ctx context.Context, recipientID string) error {
+	if err := s.primary.DeleteMailsByRecipient(ctx, recipientID); err != nil {
+		return err
+	}
+
+	if err := s.secondary.DeleteMailsByRecipient(ctx, recipientID); err != nil {
+		s.secondaryFailed(ctx, "DeleteMailsByRecipient", err, "recipient_id", recipientID)
+	}
+	return nil
+}
+
+// DeleteExpiredMails deletes expired mails from both stores
+func (s *DualWriteMailStore) DeleteExpiredMails(ctx context.Context, beforeTime time.Time) (int, error) {
+	return s.DeleteExpiredMailsBatched(ctx, beforeTime, ExpiredDeleteOptions{})
+}
+
+// DeleteExpiredMailsBatched deletes expired mails from both stores.
+// Hooks only run for the primary store, and the secondary store deletes exactly the batches the hooks accepted.
+func (s *DualWriteMailStore) DeleteExpiredMailsBatched(ctx context.Context, beforeTime time.Time, opts ExpiredDeleteOptions) (int, error) {
+	if opts.BeforeDelete == nil {
+		count, err := s.primary.DeleteExpiredMailsBatched(ctx, beforeTime, opts)
+		if err != nil {
+			return count, err
+		}
+
+		other, err := s.secondary.DeleteExpiredMailsBatched(ctx, beforeTime, ExpiredDeleteOptions{BatchSize: opts.BatchSize, Pause: opts.Pause})
+		if err != nil {
+			s.secondaryFailed(ctx, "DeleteExpiredMailsBatched", err)
+		} else if other != count {
+			s.mismatch(ctx, "DeleteExpiredMailsBatched", "count", count, "secondary_count", other)
+		}
+		return count, nil
+	}
+
+	// Record the batches accepted by the hooks
+	var mu sync.Mutex
+	var accepted []string
+	hook := opts.BeforeDelete
+	opts.BeforeDelete = func(ctx context.Context, mails []*Mail) error {
+		if err := hook(ctx, mails); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, mail := range mails {
+			accepted = append(accepted, mail.ID)
+		}
+		return nil
+	}
+
+	count, err := s.primary.DeleteExpiredMailsBatched(ctx, beforeTime, opts)
+	for _, id := range accepted {
+		if err := s.secondary.DeleteMail(ctx, id); err != nil && !errors.Is(err, ErrMailNotFound) {
+			s.secondaryFailed(ctx, "DeleteExpiredMailsBatched", err, "mail_id", id)
+		}
+	}
+	return count, err
+}
+
+// UpdateByFilter applies a patch to matching mails in both stores
+func (s *DualWriteMailStore) UpdateByFilter(ctx context.Context, filter *MailFilter, patch *MailPatch) (int, error) {
+	count, err := s.primary.UpdateByFilter(ctx, filter, patch)
+	if err != nil {
+		return count, err
+	}
+
+	other, err := s.secondary.UpdateByFilter(ctx, filter, patch)
+	if err != nil {
+		s.secondaryFailed(ctx, "UpdateByFilter", err)
+	} else if other != count {
+		s.mismatch(ctx, "UpdateByFilter", "count", count, "secondary_count", other)
+	}
+	return count, nil
+}
+
+// DeleteByFilter deletes matching mails from both stores
+func (s *DualWriteMailStore) DeleteByFilter(ctx context.Context, filter *MailFilter) (int, error) {
+	count, err := s.primary.DeleteByFilter(ctx, filter)
+	if err != nil {
+		return count, err
+	}
+
+	other, err := s.secondary.DeleteByFilter(ctx, filter)
+	if err != nil {
+		s.secondaryFailed(ctx, "DeleteByFilter", err)
+	} else if other != count {
+		s.mismatch(ctx, "DeleteByFilter", "count", count, "secondary_count", other)
+	}
+	return count, nil
+}
+
+// GetMailsByRecipient reads a recipient's mails from the primary store and compares them with the secondary store
+func (s *DualWriteMailStore) GetMailsByRecipient(ctx context.Context, recipientID string, page, size int, order ...SortOrder) ([]*Mail, int, error) {
+	mails, total, err := s.primary.GetMailsByRecipient(ctx, recipientID, page, size, order...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	other, otherTotal, err := s.secondary.GetMailsByRecipient(ctx, recipientID, page, size, order...)
+	if err != nil {
+		s.secondaryFailed(ctx, "GetMailsByRecipient", err, "recipient_id", recipientID)
+	} else {
+		s.compareMails(ctx, "GetMailsByRecipient", mails, total, other, otherTotal)
+	}
+	return mails, total, nil
+}
+
+// QueryMails queries the primary store and compares the result with the secondary store
+func (s *DualWriteMailStore) QueryMails(ctx context.Context, filter *MailFilter, page, size int) ([]*Mail, int, error) {
+	mails, total, err := s.primary.QueryMails(ctx, filter, page, size)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	other, otherTotal, err := s.secondary.QueryMails(ctx, filter, page, size)
+	if err != nil {
+		s.secondaryFailed(ctx, "QueryMails", err)
+	} else {
+		s.compareMails(ctx, "QueryMails", mails, total, other, otherTotal)
+	}
+	return mails, total, nil
+}
+
+// ListExpiringBetween lists expiring mails from the primary store and compares them with the secondary store
+func (s *DualWriteMailStore) ListExpiringBetween(ctx context.Context, recipientID string, from, to time.Time) ([]*Mail, error) {
+	mails, err := s.primary.ListExpiringBetween(ctx, recipientID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	other, err := s.secondary.ListExpiringBetween(ctx, recipientID, from, to)
+	if err != nil {
+		s.secondaryFailed(ctx, "ListExpiringBetween", err, "recipient_id", recipientID)
+	} else {
+		s.compareMails(ctx, "ListExpiringBetween", mails, len(mails), other, len(other))
+	}
+	return mails, nil
+}
+
+// ScanMails scans the primary store
+func (s *DualWriteMailStore) ScanMails(ctx context.Context, filter *MailFilter, opts ScanOptions, fn func(mails []*Mail) error) error {
+	return s.primary.ScanMails(ctx, filter, opts, fn)
+}
+
+// CountUnreadMails counts unread mails in the primary store and compares the count with the secondary store
+func (s *DualWriteMailStore) CountUnreadMails(ctx context.Context, recipientID string) (int, error) {
+	count, err := s.primary.CountUnreadMails(ctx, recipientID)
+	if err != nil {
+		return 0, err
+	}
+
+	other, err := s.secondary.CountUnreadMails(ctx, recipientID)
+	s.compareCount(ctx, "CountUnreadMails", recipientID, count, other, err)
+	return count, nil
+}
+
+// CountMailsWithAttachments counts mails with attachments in the primary store and compares the count with the secondary store
+func (s *DualWriteMailStore) CountMailsWithAttachments(ctx context.Context, recipientID string) (int, error) {
+	count, err := s.primary.CountMailsWithAttachments(ctx, recipientID)
+	if err != nil {
+		return 0, err
+	}
+
+	other, err := s.secondary.CountMailsWithAttachments(ctx, recipientID)
+	s.compareCount(ctx, "CountMailsWithAttachments", recipientID, count, other, err)
+	return count, nil
+}
+
+// CountBy counts mails per group in the primary store and compares the counts with the secondary store
+func (s *DualWriteMailStore) CountBy(ctx context.Context, filter *MailFilter, groupBy GroupBy) ([]GroupCount, error) {
+	groups, err := s.primary.CountBy(ctx, filter, groupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	other, err := s.secondary.CountBy(ctx, filter, groupBy)
+	if err != nil {
+		s.secondaryFailed(ctx, "CountBy", err, "group_by", groupBy)
+	} else if !slices.Equal(groups, other) {
+		s.mismatch(ctx, "CountBy", "group_by", groupBy, "groups", len(groups), "secondary_groups", len(other))
+	}
+	return groups, nil
+}
+
+// ExportMailLogs exports mail logs from the primary store
+func (s *DualWriteMailStore) ExportMailLogs(ctx context.Context, filter *MailFilter) (string, error) {
+	return s.primary.ExportMailLogs(ctx, filter)
+}
+
+// upsertSecondary writes a mail to the secondary store at its current version, creating it if missing
+func (s *DualWriteMailStore) upsertSecondary(ctx context.Context, op string, mail *Mail) {
+	existing, err := s.secondary.GetMail(ctx, mail.ID)
+	if errors.Is(err, ErrMailNotFound) {
+		s.mismatch(ctx, op, "mail_id", mail.ID, "missing", true)
+		if _, err := s.secondary.CreateMail(ctx, mail); err != nil {
+			s.secondaryFailed(ctx, op, err, "mail_id", mail.ID)
+		}
+		return
+	}
+	if err != nil {
+		s.secondaryFailed(ctx, op, err, "mail_id", mail.ID)
+		return
+	}
+
+	mail.Version = existing.Version
+	if err := s.secondary.UpdateMail(ctx, mail); err != nil {
+		s.secondaryFailed(ctx, op, err, "mail_id", mail.ID)
+	}
+}
+
+// compareMails logs a mismatch if the totals differ or a mail in both results has different fields
+func (s *DualWriteMailStore) compareMails(ctx context.Context, op string, mails []*Mail, total int, other []*Mail, otherTotal int) {
+	if total != otherTotal {
+		s.mismatch(ctx, op, "count", total, "secondary_count", otherTotal)
+		return
+	}
+
+	// Pages may be cut differently when mails share a sort key, so only mails found in both are compared
+	byID := make(map[string]*Mail, len(other))
+	for _, mail := range other {
+		byID[mail.ID] = mail
+	}
+	for _, mail := range mails {
+		if otherMail, ok := byID[mail.ID]; ok {
+			if fields := diffMails(mail, otherMail); len(fields) > 0 {
+				s.mismatch(ctx, op, "mail_id", mail.ID, "fields", fields)
+			}
+		}
+	}
+}
+
+// compareCount logs a mismatch if the counts of both stores differ
+func (s *DualWriteMailStore) compareCount(ctx context.Context, op, recipientID string, count, other int, err error) {
+	if err != nil {
+		s.secondaryFailed(ctx, op, err, "recipient_id", recipientID)
+	} else if other != count {
+		s.mismatch(ctx, op, "recipient_id", recipientID, "count", count, "secondary_count", other)
+	}
+}
+
+// mismatch logs a difference between the stores
+func (s *DualWriteMailStore) mismatch(ctx context.Context, op string, attrs ...any) {
+	attrs = append([]any{"op", op}, attrs...)
+	s.logger.WarnContext(ctx, "dual write mismatch", attrs...)
+}
+
+// secondaryFailed logs a failed operation on the secondary store
+func (s *DualWriteMailStore) secondaryFailed(ctx context.Context, op string, err error, attrs ...any) {
+	attrs = append([]any{"op", op, "error", err}, attrs...)
+	s.logger.ErrorContext(ctx, "secondary mail store operation failed", attrs...)
+}
@@ -0,0 +1,187 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingStore fails every write to simulate an unavailable secondary store
+type failingStore struct {
+	MailStore
+}
+
+func (s *failingStore) CreateMail(ctx context.Context, mail *Mail) (string, error) {
+	return "", errors.New("secondary unavailable")
+}
+
+func TestDualWriteMailStoreWrites(t *testing.T) {
+	ctx := context.Background()
+	primary := NewMemoryMailStore()
+	secondary := setupGormMailStore(t)
+	logger, buf := newTestLogger()
+	store := NewDualWriteMailStore(primary, secondary, WithStoreLogger(logger))
+
+	// Mails are created in both stores with the primary ID
+	id, err := store.CreateMail(ctx, &Mail{RecipientID: "user1", Title: "Hello", Attachments: map[string]interface{}{"coins": 100}})
+	require.NoError(t, err)
+	ids, err := store.CreateBatchMails(ctx, []*Mail{
+		{RecipientID: "user1", Title: "First"},
+		{RecipientID: "user2", Title: "Second", ExpireTime: time.Now().Add(-time.Hour)},
+	})
+	require.NoError(t, err)
+
+	report, err := VerifyMails(ctx, primary, secondary, VerifyOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Checked)
+	assert.True(t, report.OK())
+
+	// Updates are applied at the secondary store's own version
+	mail, err := store.GetMail(ctx, id)
+	require.NoError(t, err)
+	mail.Title = "Updated"
+	require.NoError(t, store.UpdateMail(ctx, mail))
+	mail.Title = "Updated again"
+	require.NoError(t, store.UpdateMail(ctx, mail))
+
+	copied, err := secondary.GetMail(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "Updated again", copied.Title)
+
+	// Bulk writes and deletions reach both stores
+	read := true
+	count, err := store.UpdateByFilter(ctx, &MailFilter{RecipientID: "user1"}, &MailPatch{ReadStatus: &read})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	count, err = store.DeleteExpiredMails(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	require.NoError(t, store.DeleteMail(ctx, ids[0]))
+
+	report, err = VerifyMails(ctx, primary, secondary, VerifyOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Checked)
+	assert.True(t, report.OK())
+	_, total, err := secondary.QueryMails(ctx, nil, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+
+	assert.Nil(t, findLogEntry(logEntries(t, buf), "dual write mismatch"))
+}
+
+func TestDualWriteMailStoreMismatches(t *testing.T) {
+	ctx := context.Background()
+	primary := NewMemoryMailStore()
+	secondary := NewMemoryMailStore()
+	logger, buf := newTestLogger()
+	store := NewDualWriteMailStore(primary, secondary, WithStoreLogger(logger))
+
+	id, err := store.CreateMail(ctx, &Mail{RecipientID: "user1", Title: "Hello"})
+	require.NoError(t, err)
+
+	// Reads are served by the primary store and differences are logged
+	mail, err := secondary.GetMail(ctx, id)
+	require.NoError(t, err)
+	mail.Title = "Diverged"
+	require.NoError(t, secondary.UpdateMail(ctx, mail))
+
+	mail, err = store.GetMail(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", mail.Title)
+
+	entry := findLogEntry(logEntries(t, buf), "dual write mismatch")
+	require.NotNil(t, entry)
+	assert.Equal(t, "GetMail", entry["op"])
+	assert.Equal(t, []interface{}{"Title"}, entry["fields"])
+
+	// Count differences are logged
+	buf.Reset()
+	_, err = primary.CreateMail(ctx, &Mail{RecipientID: "user1"})
+	require.NoError(t, err)
+	count, err := store.CountUnreadMails(ctx, "user1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	entry = findLogEntry(logEntries(t, buf), "dual write mismatch")
+	require.NotNil(t, entry)
+	assert.Equal(t, "CountUnreadMails", entry["op"])
+	assert.Equal(t, float64(1), entry["secondary_count"])
+
+	buf.Reset()
+	groups, err := store.CountBy(ctx, nil, GroupByReadStatus)
+	require.NoError(t, err)
+	assert.Equal(t, []GroupCount{{Key: "false", Count: 2}}, groups)
+
+	entry = findLogEntry(logEntries(t, buf), "dual write mismatch")
+	require.NotNil(t, entry)
+	assert.Equal(t, "CountBy", entry["op"])
+}
+
+func TestDualWriteMailStoreSecondaryFailure(t *testing.T) {
+	ctx := context.Background()
+	primary := NewMemoryMailStore()
+	logger, buf := newTestLogger()
+	store := NewDualWriteMailStore(primary, &failingStore{MailStore: NewMemoryMailStore()}, WithStoreLogger(logger))
+
+	// Secondary failures don't fail the write
+	id, err := store.CreateMail(ctx, &Mail{RecipientID: "user1"})
+	require.NoError(t, err)
+	_, err = primary.GetMail(ctx, id)
+	assert.NoError(t, err)
+
+	entry := findLogEntry(logEntries(t, buf), "secondary mail store operation failed")
+	require.NotNil(t, entry)
+	assert.Equal(t, "CreateMail", entry["op"])
+
+	// Updates create mails missing from the secondary store
+	buf.Reset()
+	store = NewDualWriteMailStore(primary, NewMemoryMailStore(), WithStoreLogger(logger))
+	mail, err := primary.GetMail(ctx, id)
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateMail(ctx, mail))
+	_, err = store.secondary.GetMail(ctx, id)
+	assert.NoError(t, err)
+	assert.NotNil(t, findLogEntry(logEntries(t, buf), "dual write mismatch"))
+}
+
+func TestDualWriteMailStoreExpiryHooks(t *testing.T) {
+	ctx := context.Background()
+	primary := NewMemoryMailStore()
+	secondary := NewMemoryMailStore()
+	store := NewDualWriteMailStore(primary, secondary)
+
+	past := time.Now().Add(-time.Hour)
+	_, err := store.CreateBatchMails(ctx, []*Mail{
+		{RecipientID: "user1", ExpireTime: past},
+		{RecipientID: "user2", ExpireTime: past},
+	})
+	require.NoError(t, err)
+
+	// Hooks run once, and batches they keep stay in both stores
+	calls := 0
+	count, err := store.DeleteExpiredMailsBatched(ctx, time.Now(), ExpiredDeleteOptions{
+		BatchSize: 1,
+		BeforeDelete: func(ctx context.Context, mails []*Mail) error {
+			calls++
+			if mails[0].RecipientID == "user2" {
+				return errors.New("keep")
+			}
+			return nil
+		},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 2, calls)
+
+	report, err := VerifyMails(ctx, primary, secondary, VerifyOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Checked)
+	assert.True(t, report.OK())
+	_, total, err := secondary.QueryMails(ctx, nil, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+}
@@ -0,0 +1,160 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// expiryNotificationsJobName is the name of the expiring soon notification job
+const expiryNotificationsJobName = "expiry_notifications"
+
+// ExpiringSoonEvent is emitted for every mail that is about to expire
+type ExpiringSoonEvent struct {
+	Mail      *Mail
+	ExpiresIn time.Duration // Time left until the mail expires when the event was emitted
+}
+
+// ExpiringSoonHandler handles expiring soon events.
+// Returning an error makes the event be emitted again on the next run.
+type ExpiringSoonHandler func(ctx context.Context, event ExpiringSoonEvent) error
+
+// expiryNotifier remembers which mails have been notified so every mail is notified once per process.
+// A mail whose expire time changes is notified again. Nothing is persisted, so delivery is at least once:
+// a restarted manager, or another instance taking over the job, notifies the mails in the window again.
+// Entries are dropped once their mail has expired, so the map holds at most the mails in the window.
+type expiryNotifier struct {
+	mu       sync.Mutex
+	notified map[string]time.Time // Expire time of notified mails by mail ID
+}
+
+// ListExpiringSoon gets a user's mails expiring within the given duration, soonest first
+func (m *DefaultMailManager) ListExpiringSoon(ctx context.Context, recipientID string, within time.Duration) (mails []*Mail, err error) {
+	ctx, span := m.startSpan(ctx, "ListExpiringSoon", AttrRecipientID.String(recipientID))
+	defer func() { endSpan(span, err) }()
+
+	if recipientID == "" {
+		return nil, errors.New("recipient ID cannot be empty")
+	}
+	if within <= 0 {
+		return nil, errors.New("expiry window must be positive")
+	}
+
+	now := m.clock.Now()
+	return m.store.ListExpiringBetween(ctx, recipientID, now, now.Add(within))
+}
+
+// ScheduleExpiryNotifications checks every interval for mails expiring within the given window
+// and emits one expiring soon event per mail to the handler registered with WithExpiringSoonHandler.
+// Delivery is at least once: notified mails are only remembered by this manager instance,
+// so handlers should tolerate an event being emitted again after a restart.
+func (m *DefaultMailManager) ScheduleExpiryNotifications(ctx context.Context, interval, within time.Duration) (err error) {
+	ctx, span := m.startSpan(ctx, "ScheduleExpiryNotifications")
+	defer func() { endSpan(span, err) }()
+
+	if interval <= 0 {
+		return errors.New("notification interval must be positive")
+	}
+	if within <= 0 {
+		return errors.New("expiry window must be positive")
+	}
+	if m.expiringSoonHandler == nil {
+		return errors.New("no expiring soon handler registered")
+	}
+
+	return m.schedule(expiryNotificationsJobName, interval, func(ctx context.Context) error {
+		return m.runExpiryNotifications(ctx, within)
+	})
+}
+
+// runExpiryNotifications performs a single notification run in its own root span and logs its outcome
+func (m *DefaultMailManager) runExpiryNotifications(ctx context.Context, within time.Duration) (err error) {
+	ctx, span := m.tracer.Start(ctx, "MailManager.expiryNotifications", trace.WithNewRoot())
+	defer func() { endSpan(span, err) }()
+
+	start := time.Now()
+	count, err := m.notifyExpiringSoon(ctx, m.clock.Now(), within)
+	span.SetAttributes(AttrMailCount.Int(count))
+	if err != nil {
+		m.logger.ErrorContext(ctx, "expiring soon notifications failed",
+			"count", count,
+			"duration", time.Since(start),
+			"error", err,
+		)
+		return err
+	}
+
+	m.logger.InfoContext(ctx, "expiring soon notifications sent",
+		"count", count,
+		"duration", time.Since(start),
+	)
+
+	return nil
+}
+
+// notifyExpiringSoon emits events for mails expiring in [now, now+within) that have not been notified yet.
+// The mails are scanned in batches, so a run never loads every expiring mail at once.
+func (m *DefaultMailManager) notifyExpiringSoon(ctx context.Context, now time.Time, within time.Duration) (int, error) {
+	n := &m.expiryNotifier
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.notified == nil {
+		n.notified = make(map[string]time.Time)
+	}
+
+	// Forget mails that have expired by now
+	for id, expireTime := range n.notified {
+		if expireTime.Before(now) {
+			delete(n.notified, id)
+		}
+	}
+
+	end := now.Add(within)
+	starred := false
+	filter := &MailFilter{
+		ExpireStart: &now,
+		ExpireEnd:   &end,
+		Starred:     &starred,
+	}
+
+	count := 0
+	var errs []error
+	err := m.store.ScanMails(ctx, filter, ScanOptions{}, func(mails []*Mail) error {
+		if err := m.renewJobLease(ctx); err != nil {
+			return err
+		}
+		for _, mail := range mails {
+			// ExpireEnd is inclusive, the window is not
+			if !mail.ExpireTime.Before(end) {
+				continue
+			}
+			if expireTime, ok := n.notified[mail.ID]; ok && expireTime.Equal(mail.ExpireTime) {
+				continue
+			}
+
+			event := ExpiringSoonEvent{
+				Mail:      mail,
+				ExpiresIn: mail.ExpireTime.Sub(now),
+			}
+			if err := m.expiringSoonHandler(ctx, event); err != nil {
+				errs = append(errs, fmt.Errorf("mail %s: %w", mail.ID, err))
+				continue
+			}
+
+			n.notified[mail.ID] = mail.ExpireTime
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		m.logStoreError(ctx, "scan expiring mails", err)
+		errs = append(errs, err)
+	}
+
+	return count, errors.Join(errs...)
+}
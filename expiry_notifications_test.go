@@ -0,0 +1,176 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weedbox/inboxer/inboxertest"
+)
+
+func TestListExpiringSoon(t *testing.T) {
+	manager := NewDefaultMailManager(NewMemoryMailStore())
+	ctx := context.Background()
+
+	_, err := manager.SendBatchMail(ctx, &Mail{ExpireTime: time.Now().Add(time.Hour)}, []string{"user1", "user2"})
+	require.NoError(t, err)
+	_, err = manager.SendMail(ctx, &Mail{RecipientID: "user1", ExpireTime: time.Now().Add(72 * time.Hour)})
+	require.NoError(t, err)
+
+	mails, err := manager.ListExpiringSoon(ctx, "user1", 24*time.Hour)
+	assert.NoError(t, err)
+	require.Len(t, mails, 1)
+	assert.Equal(t, "user1", mails[0].RecipientID)
+
+	// Test invalid arguments
+	_, err = manager.ListExpiringSoon(ctx, "", time.Hour)
+	assert.Error(t, err)
+	_, err = manager.ListExpiringSoon(ctx, "user1", 0)
+	assert.Error(t, err)
+}
+
+func TestNotifyExpiringSoon(t *testing.T) {
+	ctx := context.Background()
+	events := []ExpiringSoonEvent{}
+	failFor := ""
+	manager := NewDefaultMailManager(NewMemoryMailStore(),
+		WithExpiringSoonHandler(func(ctx context.Context, event ExpiringSoonEvent) error {
+			if event.Mail.RecipientID == failFor {
+				return errors.New("push service unavailable")
+			}
+			events = append(events, event)
+			return nil
+		}),
+	)
+
+	now := time.Now()
+	id1, err := manager.SendMail(ctx, &Mail{RecipientID: "user1", ExpireTime: now.Add(time.Hour)})
+	require.NoError(t, err)
+	_, err = manager.SendMail(ctx, &Mail{RecipientID: "user2", ExpireTime: now.Add(2 * time.Hour)})
+	require.NoError(t, err)
+	_, err = manager.SendMail(ctx, &Mail{RecipientID: "user3", ExpireTime: now.Add(72 * time.Hour)})
+	require.NoError(t, err)
+
+	// A failed event is retried on the next run
+	failFor = "user2"
+	count, err := manager.notifyExpiringSoon(ctx, now, 24*time.Hour)
+	assert.ErrorContains(t, err, "push service unavailable")
+	assert.Equal(t, 1, count)
+	require.Len(t, events, 1)
+	assert.Equal(t, id1, events[0].Mail.ID)
+	assert.Equal(t, time.Hour, events[0].ExpiresIn)
+
+	// Every mail is notified once
+	failFor = ""
+	count, err = manager.notifyExpiringSoon(ctx, now, 24*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	count, err = manager.notifyExpiringSoon(ctx, now.Add(time.Minute), 24*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.Len(t, events, 2)
+
+	// Extending the expire time produces a new event
+	_, err = manager.UpdateMailWithRetry(ctx, id1, func(mail *Mail) (bool, error) {
+		mail.ExpireTime = now.Add(3 * time.Hour)
+		return true, nil
+	})
+	require.NoError(t, err)
+	count, err = manager.notifyExpiringSoon(ctx, now.Add(time.Minute), 24*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// Mails past their expire time are forgotten
+	_, err = manager.notifyExpiringSoon(ctx, now.Add(5*time.Hour), time.Hour)
+	assert.NoError(t, err)
+	assert.Empty(t, manager.expiryNotifier.notified)
+}
+
+// scanCountingStore counts the batches passed to ScanMails
+type scanCountingStore struct {
+	MailStore
+	batches int
+}
+
+func (s *scanCountingStore) ScanMails(ctx context.Context, filter *MailFilter, opts ScanOptions, fn func(mails []*Mail) error) error {
+	return s.MailStore.ScanMails(ctx, filter, opts, func(mails []*Mail) error {
+		s.batches++
+		return fn(mails)
+	})
+}
+
+func (s *scanCountingStore) ListExpiringBetween(ctx context.Context, recipientID string, from, to time.Time) ([]*Mail, error) {
+	return nil, errors.New("expiring mails must be scanned in batches")
+}
+
+func TestNotifyExpiringSoonScansInBatches(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := &scanCountingStore{MailStore: NewMemoryMailStore()}
+	notified := map[string]bool{}
+	manager := NewDefaultMailManager(store,
+		WithExpiringSoonHandler(func(ctx context.Context, event ExpiringSoonEvent) error {
+			notified[event.Mail.ID] = true
+			return nil
+		}),
+	)
+
+	mails := make([]*Mail, DefaultScanBatchSize+10)
+	for i := range mails {
+		mails[i] = &Mail{RecipientID: "user1", ExpireTime: now.Add(time.Hour)}
+	}
+	_, err := store.CreateBatchMails(ctx, mails)
+	require.NoError(t, err)
+
+	// Mails expiring at the end of the window or starred mails are left out
+	_, err = store.CreateMail(ctx, &Mail{RecipientID: "user1", ExpireTime: now.Add(24 * time.Hour)})
+	require.NoError(t, err)
+	_, err = store.CreateMail(ctx, &Mail{RecipientID: "user1", ExpireTime: now.Add(time.Hour), Starred: true})
+	require.NoError(t, err)
+
+	count, err := manager.notifyExpiringSoon(ctx, now, 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, len(mails), count)
+	assert.Len(t, notified, len(mails))
+	assert.Equal(t, 2, store.batches)
+}
+
+func TestScheduleExpiryNotifications(t *testing.T) {
+	ctx := context.Background()
+
+	// A handler is required
+	manager := NewDefaultMailManager(NewMemoryMailStore())
+	assert.Error(t, manager.ScheduleExpiryNotifications(ctx, time.Second, time.Hour))
+
+	clock := inboxertest.NewFakeClock(time.Now())
+	notified := []string{}
+	manager = NewDefaultMailManager(NewMemoryMailStore(WithStoreClock(clock)),
+		WithClock(clock),
+		WithExpiringSoonHandler(func(ctx context.Context, event ExpiringSoonEvent) error {
+			notified = append(notified, event.Mail.ID)
+			return nil
+		}),
+	)
+
+	// Test invalid arguments
+	assert.Error(t, manager.ScheduleExpiryNotifications(ctx, 0, time.Hour))
+	assert.Error(t, manager.ScheduleExpiryNotifications(ctx, time.Second, 0))
+
+	id, err := manager.SendMail(ctx, &Mail{RecipientID: "user1", ExpireTime: clock.Now().Add(30 * time.Hour)})
+	require.NoError(t, err)
+
+	// The mail is notified once it enters the window
+	require.NoError(t, manager.ScheduleExpiryNotifications(ctx, 6*time.Hour, 24*time.Hour))
+	advanceJobs(clock, 1, 6*time.Hour)
+	assert.Empty(t, notified)
+	advanceJobs(clock, 1, 6*time.Hour)
+	assert.Equal(t, []string{id}, notified)
+
+	// Later runs do not notify the same mail again
+	advanceJobs(clock, 1, 6*time.Hour)
+	require.NoError(t, manager.Close(ctx))
+	assert.Equal(t, []string{id}, notified)
+}
@@ -0,0 +1,256 @@
+package inboxer
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ExportFormat is the output format of an export
+type ExportFormat string
+
+const (
+	ExportFormatJSON   ExportFormat = "json"   // A single JSON array, the format of ExportMailLogs
+	ExportFormatNDJSON ExportFormat = "ndjson" // One JSON object per line
+	ExportFormatCSV    ExportFormat = "csv"    // CSV with a header row of column names
+)
+
+// Export column names
+const (
+	ColumnID          = "id"
+	ColumnSenderID    = "sender_id"
+	ColumnRecipientID = "recipient_id"
+	ColumnTitle       = "title"
+	ColumnContent     = "content"
+	ColumnAttachments = "attachments"
+	ColumnReadStatus  = "read_status"
+	ColumnReadTime    = "read_time"
+	ColumnCreateTime  = "create_time"
+	ColumnExpireTime  = "expire_time"
+	ColumnTags        = "tags"
+	ColumnPriority    = "priority"
+	ColumnFolder      = "folder"
+	ColumnStarred     = "starred"
+	ColumnPinned      = "pinned"
+	ColumnArchived    = "archived"
+	ColumnVersion     = "version"
+)
+
+// DefaultExportColumns are the CSV columns written when ExportOptions.Columns is empty
+var DefaultExportColumns = []string{
+	ColumnID, ColumnSenderID, ColumnRecipientID, ColumnTitle, ColumnContent, ColumnAttachments,
+	ColumnReadStatus, ColumnReadTime, ColumnCreateTime, ColumnExpireTime, ColumnTags, ColumnPriority, ColumnFolder,
+	ColumnStarred, ColumnPinned, ColumnArchived, ColumnVersion,
+}
+
+// ExportOptions controls a streaming export
+type ExportOptions struct {
+	Format    ExportFormat // Output format, empty means JSON
+	Columns   []string     // CSV columns in order, empty uses DefaultExportColumns
+	BatchSize int          // Mails read from the store per page, 0 uses DefaultScanBatchSize
+}
+
+// exportWriter writes mails in one export format
+type exportWriter interface {
+	begin() error
+	write(mail *Mail) error
+	end() error
+}
+
+// ExportMails streams every mail matching the filter to w in ID order and returns the number of exported mails.
+// Mails are read from the store page by page, so exports are not limited by memory or a row cap.
+func ExportMails(ctx context.Context, store MailStore, w io.Writer, filter *MailFilter, opts ExportOptions) (int, error) {
+	buf := bufio.NewWriter(w)
+	out, err := newExportWriter(buf, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := out.begin(); err != nil {
+		return 0, fmt.Errorf("failed to write export header: %w", err)
+	}
+
+	count := 0
+	err = store.ScanMails(ctx, filter, ScanOptions{BatchSize: opts.BatchSize}, func(mails []*Mail) error {
+		for _, mail := range mails {
+			if err := out.write(mail); err != nil {
+				return fmt.Errorf("failed to write mail %s: %w", mail.ID, err)
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+
+	if err := out.end(); err != nil {
+		return count, fmt.Errorf("failed to write export footer: %w", err)
+	}
+	if err := buf.Flush(); err != nil {
+		return count, fmt.Errorf("failed to flush export: %w", err)
+	}
+	return count, nil
+}
+
+// newExportWriter creates the writer for the selected format
+func newExportWriter(w *bufio.Writer, opts ExportOptions) (exportWriter, error) {
+	switch opts.Format {
+	case "", ExportFormatJSON:
+		return &jsonExportWriter{w: w}, nil
+	case ExportFormatNDJSON:
+		return &ndjsonExportWriter{enc: json.NewEncoder(w)}, nil
+	case ExportFormatCSV:
+		columns := opts.Columns
+		if len(columns) == 0 {
+			columns = DefaultExportColumns
+		}
+		for _, column := range columns {
+			if _, err := mailColumn(&Mail{}, column); err != nil {
+				return nil, err
+			}
+		}
+		return &csvExportWriter{w: csv.NewWriter(w), columns: columns}, nil
+	default:
+		return nil, fmt.Errorf("unsupported export format %q", opts.Format)
+	}
+}
+
+// jsonExportWriter writes a JSON array indented like ExportMailLogs
+type jsonExportWriter struct {
+	w     *bufio.Writer
+	count int
+}
+
+func (e *jsonExportWriter) begin() error {
+	_, err := e.w.WriteString("[")
+	return err
+}
+
+func (e *jsonExportWriter) write(mail *Mail) error {
+	data, err := json.MarshalIndent(mail, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	sep := "\n  "
+	if e.count > 0 {
+		sep = ",\n  "
+	}
+	e.count++
+	if _, err := e.w.WriteString(sep); err != nil {
+		return err
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonExportWriter) end() error {
+	if e.count > 0 {
+		_, err := e.w.WriteString("\n]\n")
+		return err
+	}
+	_, err := e.w.WriteString("]\n")
+	return err
+}
+
+// ndjsonExportWriter writes one JSON object per line
+type ndjsonExportWriter struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonExportWriter) begin() error { return nil }
+
+func (e *ndjsonExportWriter) write(mail *Mail) error { return e.enc.Encode(mail) }
+
+func (e *ndjsonExportWriter) end() error { return nil }
+
+// csvExportWriter writes the selected columns with a header row
+type csvExportWriter struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func (e *csvExportWriter) begin() error {
+	return e.w.Write(e.columns)
+}
+
+func (e *csvExportWriter) write(mail *Mail) error {
+	record := make([]string, len(e.columns))
+	for i, column := range e.columns {
+		value, err := mailColumn(mail, column)
+		if err != nil {
+			return err
+		}
+		record[i] = value
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExportWriter) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// mailColumn formats a single mail field for CSV output.
+// Times use RFC 3339 and are empty when zero, attachments and tags are JSON encoded.
+func mailColumn(mail *Mail, column string) (string, error) {
+	switch column {
+	case ColumnID:
+		return mail.ID, nil
+	case ColumnSenderID:
+		return mail.SenderID, nil
+	case ColumnRecipientID:
+		return mail.RecipientID, nil
+	case ColumnTitle:
+		return mail.Title, nil
+	case ColumnContent:
+		return mail.Content, nil
+	case ColumnAttachments:
+		if len(mail.Attachments) == 0 {
+			return "", nil
+		}
+		data, err := json.Marshal(mail.Attachments)
+		return string(data), err
+	case ColumnReadStatus:
+		return strconv.FormatBool(mail.ReadStatus), nil
+	case ColumnReadTime:
+		return formatExportTime(mail.ReadTime), nil
+	case ColumnCreateTime:
+		return formatExportTime(mail.CreateTime), nil
+	case ColumnExpireTime:
+		return formatExportTime(mail.ExpireTime), nil
+	case ColumnTags:
+		if len(mail.Tags) == 0 {
+			return "", nil
+		}
+		data, err := json.Marshal(mail.Tags)
+		return string(data), err
+	case ColumnPriority:
+		return strconv.Itoa(mail.Priority), nil
+	case ColumnFolder:
+		return mail.Folder, nil
+	case ColumnStarred:
+		return strconv.FormatBool(mail.Starred), nil
+	case ColumnPinned:
+		return strconv.FormatBool(mail.Pinned), nil
+	case ColumnArchived:
+		return strconv.FormatBool(mail.Archived), nil
+	case ColumnVersion:
+		return strconv.FormatInt(mail.Version, 10), nil
+	default:
+		return "", fmt.Errorf("unknown export column %q", column)
+	}
+}
+
+// formatExportTime formats a time for CSV output, leaving zero times empty
+func formatExportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
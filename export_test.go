@@ -0,0 +1,169 @@
+package inboxer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createExportMails stores mails for export tests and returns their IDs
+func createExportMails(t *testing.T, store MailStore, n int) []string {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	mails := make([]*Mail, n)
+	for i := range mails {
+		mails[i] = &Mail{
+			SenderID:    "system",
+			RecipientID: "user1",
+			Title:       "Title, with \"quotes\"",
+			Content:     "Line one\nLine two",
+			Attachments: map[string]interface{}{"coins": 100},
+			CreateTime:  now,
+			ExpireTime:  now.Add(24 * time.Hour),
+			Tags:        []string{"event"},
+		}
+	}
+	ids, err := store.CreateBatchMails(context.Background(), mails)
+	require.NoError(t, err)
+	return ids
+}
+
+func TestExportMailsJSON(t *testing.T) {
+	for name, store := range map[string]MailStore{"memory": NewMemoryMailStore(), "gorm": setupGormMailStore(t)} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			// Empty exports are still valid JSON
+			var buf bytes.Buffer
+			count, err := ExportMails(ctx, store, &buf, nil, ExportOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, 0, count)
+			assert.Equal(t, "[]\n", buf.String())
+
+			ids := createExportMails(t, store, 5)
+
+			// Every page is streamed into a single array
+			buf.Reset()
+			count, err = ExportMails(ctx, store, &buf, &MailFilter{RecipientID: "user1"}, ExportOptions{Format: ExportFormatJSON, BatchSize: 2})
+			assert.NoError(t, err)
+			assert.Equal(t, 5, count)
+
+			var mails []*Mail
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &mails))
+			require.Len(t, mails, 5)
+			for i, mail := range mails {
+				assert.Equal(t, ids[i], mail.ID)
+				assert.Equal(t, "Line one\nLine two", mail.Content)
+			}
+		})
+	}
+}
+
+func TestExportMailsNDJSON(t *testing.T) {
+	store := NewMemoryMailStore()
+	ctx := context.Background()
+	ids := createExportMails(t, store, 3)
+
+	var buf bytes.Buffer
+	count, err := ExportMails(ctx, store, &buf, nil, ExportOptions{Format: ExportFormatNDJSON, BatchSize: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// One mail per line
+	scanner := bufio.NewScanner(&buf)
+	var lines int
+	for scanner.Scan() {
+		var mail Mail
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &mail))
+		assert.Equal(t, ids[lines], mail.ID)
+		lines++
+	}
+	assert.Equal(t, 3, lines)
+}
+
+func TestExportMailsCSV(t *testing.T) {
+	store := NewMemoryMailStore()
+	ctx := context.Background()
+	ids := createExportMails(t, store, 2)
+
+	// Default columns
+	var buf bytes.Buffer
+	count, err := ExportMails(ctx, store, &buf, nil, ExportOptions{Format: ExportFormatCSV})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, DefaultExportColumns, records[0])
+	assert.Equal(t, []string{
+		ids[0], "system", "user1", "Title, with \"quotes\"", "Line one\nLine two", `{"coins":100}`,
+		"false", "", "2026-01-02T03:04:05Z", "2026-01-03T03:04:05Z", `["event"]`, "0", "inbox", "false", "false", "false", "1",
+	}, records[1])
+
+	// Selected columns in the given order
+	buf.Reset()
+	_, err = ExportMails(ctx, store, &buf, nil, ExportOptions{Format: ExportFormatCSV, Columns: []string{ColumnTitle, ColumnID}})
+	assert.NoError(t, err)
+	records, err = csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"title", "id"}, records[0])
+	assert.Equal(t, []string{"Title, with \"quotes\"", ids[1]}, records[2])
+
+	// Unknown columns are rejected before anything is written
+	buf.Reset()
+	_, err = ExportMails(ctx, store, &buf, nil, ExportOptions{Format: ExportFormatCSV, Columns: []string{"password"}})
+	assert.ErrorContains(t, err, "unknown export column")
+	assert.Zero(t, buf.Len())
+}
+
+func TestExportMailsErrors(t *testing.T) {
+	store := NewMemoryMailStore()
+	ctx := context.Background()
+	createExportMails(t, store, 3)
+
+	var buf bytes.Buffer
+	_, err := ExportMails(ctx, store, &buf, nil, ExportOptions{Format: "xml"})
+	assert.ErrorContains(t, err, "unsupported export format")
+
+	// Cancellation stops the export
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = ExportMails(cancelCtx, store, &buf, nil, ExportOptions{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Writer errors are reported
+	_, err = ExportMails(ctx, store, failingWriter{}, nil, ExportOptions{Format: ExportFormatNDJSON, BatchSize: 1})
+	assert.ErrorContains(t, err, "disk full")
+}
+
+func TestManagerExportMails(t *testing.T) {
+	store := NewMemoryMailStore()
+	manager := NewDefaultMailManager(store)
+	ctx := context.Background()
+	createExportMails(t, store, 2)
+
+	var buf strings.Builder
+	count, err := manager.ExportMails(ctx, &buf, &MailFilter{RecipientID: "user1"}, ExportOptions{Format: ExportFormatNDJSON})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, 2, strings.Count(buf.String(), "\n"))
+
+	_, err = manager.ExportMails(ctx, nil, nil, ExportOptions{})
+	assert.Error(t, err)
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
@@ -0,0 +1,273 @@
+package inboxer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExprOp is the operator of a query expression
+type ExprOp string
+
+const (
+	ExprMatch ExprOp = "match" // Mails matching the filter
+	ExprAnd   ExprOp = "and"   // Mails matching every argument, an empty AND matches every mail
+	ExprOr    ExprOp = "or"    // Mails matching any argument, an empty OR matches no mail
+	ExprNot   ExprOp = "not"   // Mails not matching the single argument
+)
+
+// Expr is a boolean query expression over mails, such as (tag:event OR sender:system) AND NOT read.
+// A nil expression matches every mail, an invalid one matches none.
+type Expr struct {
+	Op     ExprOp      `json:"op"`
+	Filter *MailFilter `json:"filter,omitempty"` // Conditions of a match, all of which must hold
+	Args   []*Expr     `json:"args,omitempty"`   // Arguments of AND, OR and NOT
+}
+
+// Match creates an expression matching mails that match the filter
+func Match(filter MailFilter) *Expr {
+	return &Expr{Op: ExprMatch, Filter: &filter}
+}
+
+// And creates an expression matching mails that match every argument
+func And(args ...*Expr) *Expr {
+	return &Expr{Op: ExprAnd, Args: args}
+}
+
+// Or creates an expression matching mails that match any argument
+func Or(args ...*Expr) *Expr {
+	return &Expr{Op: ExprOr, Args: args}
+}
+
+// Not creates an expression matching mails that don't match the argument
+func Not(arg *Expr) *Expr {
+	return &Expr{Op: ExprNot, Args: []*Expr{arg}}
+}
+
+// Validate checks that every node of the expression has a known operator and the right arguments
+func (e *Expr) Validate() error {
+	if e == nil {
+		return nil
+	}
+
+	switch e.Op {
+	case ExprMatch:
+		if len(e.Args) > 0 {
+			return fmt.Errorf("%w: match cannot have arguments", ErrInvalidQuery)
+		}
+		if e.Filter != nil {
+			return e.Filter.Expr.Validate()
+		}
+		return nil
+	case ExprAnd, ExprOr:
+	case ExprNot:
+		if len(e.Args) != 1 {
+			return fmt.Errorf("%w: not takes one argument, got %d", ErrInvalidQuery, len(e.Args))
+		}
+	default:
+		return fmt.Errorf("%w: unknown operator %q", ErrInvalidQuery, e.Op)
+	}
+
+	for _, arg := range e.Args {
+		if err := arg.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// match reports whether a mail matches the expression
+func (e *Expr) match(mail *Mail, now time.Time) bool {
+	if e == nil {
+		return true
+	}
+
+	switch e.Op {
+	case ExprMatch:
+		return len(e.Args) == 0 && matchMail(mail, e.Filter, now) && matchWords(mail, filterWords(e.Filter))
+	case ExprAnd:
+		for _, arg := range e.Args {
+			if !arg.match(mail, now) {
+				return false
+			}
+		}
+		return true
+	case ExprOr:
+		for _, arg := range e.Args {
+			if arg.match(mail, now) {
+				return true
+			}
+		}
+		return false
+	case ExprNot:
+		return len(e.Args) == 1 && !e.Args[0].match(mail, now)
+	}
+	return false
+}
+
+// matchWords reports whether the title or content of a mail contains every word
+func matchWords(mail *Mail, words []string) bool {
+	if len(words) == 0 {
+		return true
+	}
+	terms, _ := mailTerms(mail)
+	for _, word := range words {
+		if terms[word] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the expression in the syntax read by ParseQuery
+func (e *Expr) String() string {
+	if e == nil {
+		return "*"
+	}
+
+	switch e.Op {
+	case ExprMatch:
+		terms := filterTerms(e.Filter)
+		if len(terms) == 0 {
+			return "*"
+		}
+		return strings.Join(terms, " ")
+	case ExprAnd, ExprOr:
+		if len(e.Args) == 0 {
+			if e.Op == ExprAnd {
+				return "*"
+			}
+			return "NOT *"
+		}
+		parts := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			parts[i] = arg.operand()
+		}
+		return strings.Join(parts, " "+strings.ToUpper(string(e.Op))+" ")
+	case ExprNot:
+		if len(e.Args) == 1 {
+			return "NOT " + e.Args[0].operand()
+		}
+	}
+	return fmt.Sprintf("<invalid %s>", e.Op)
+}
+
+// operand formats the expression as an argument of an operator, in parentheses unless it is a single term
+func (e *Expr) operand() string {
+	if e == nil {
+		return "*"
+	}
+	if e.Op == ExprNot || (e.Op == ExprMatch && len(filterTerms(e.Filter)) <= 1) {
+		return e.String()
+	}
+	return "(" + e.String() + ")"
+}
+
+// filterTerms formats the conditions of a filter as query terms
+func filterTerms(filter *MailFilter) []string {
+	if filter == nil {
+		return nil
+	}
+
+	var terms []string
+	add := func(field, value string) {
+		terms = append(terms, field+":"+quoteQueryValue(value))
+	}
+	anyOf := func(field string, values []string) {
+		if len(values) == 1 {
+			add(field, values[0])
+			return
+		}
+		parts := make([]string, len(values))
+		for i, value := range values {
+			parts[i] = field + ":" + quoteQueryValue(value)
+		}
+		terms = append(terms, "("+strings.Join(parts, " OR ")+")")
+	}
+
+	if filter.SenderID != "" {
+		add("sender", filter.SenderID)
+	}
+	if filter.RecipientID != "" {
+		add("recipient", filter.RecipientID)
+	}
+	if filter.ReadStatus != nil {
+		if *filter.ReadStatus {
+			terms = append(terms, "is:read")
+		} else {
+			terms = append(terms, "is:unread")
+		}
+	}
+	if filter.StartTime != nil {
+		add("since", filter.StartTime.Format(time.RFC3339Nano))
+	}
+	if filter.EndTime != nil {
+		add("until", filter.EndTime.Format(time.RFC3339Nano))
+	}
+	if filter.ExpiredOnly {
+		terms = append(terms, "is:expired")
+	}
+	if len(filter.Tags) > 0 {
+		anyOf("tag", filter.Tags)
+	}
+	if words := searchWords(filter.Text); len(words) > 0 {
+		terms = append(terms, strconv.Quote(strings.Join(words, " ")))
+	}
+	if filter.ExpireStart != nil {
+		add("expires-after", filter.ExpireStart.Format(time.RFC3339Nano))
+	}
+	if filter.ExpireEnd != nil {
+		add("expires-before", filter.ExpireEnd.Format(time.RFC3339Nano))
+	}
+	if filter.HasAttachments != nil {
+		if *filter.HasAttachments {
+			terms = append(terms, "has:attachments")
+		} else {
+			terms = append(terms, "NOT has:attachments")
+		}
+	}
+	for _, key := range filter.AttachmentKeys {
+		add("attachment", key)
+	}
+	if len(filter.SenderIDs) > 0 {
+		anyOf("sender", filter.SenderIDs)
+	}
+	if len(filter.RecipientIDs) > 0 {
+		anyOf("recipient", filter.RecipientIDs)
+	}
+	if len(filter.IDs) > 0 {
+		anyOf("id", filter.IDs)
+	}
+	if filter.Folder != "" {
+		add("folder", filter.Folder)
+	}
+	flag := func(name string, value *bool) {
+		if value == nil {
+			return
+		}
+		if *value {
+			terms = append(terms, "is:"+name)
+		} else {
+			terms = append(terms, "NOT is:"+name)
+		}
+	}
+	flag("starred", filter.Starred)
+	flag("pinned", filter.Pinned)
+	flag("archived", filter.Archived)
+	if filter.TitlePrefix != "" {
+		add("title", filter.TitlePrefix)
+	}
+	if filter.Expr != nil {
+		terms = append(terms, filter.Expr.operand())
+	}
+	return terms
+}
+
+// quoteQueryValue quotes a term value unless it can be written as is
+func quoteQueryValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\r\n()\"\\") {
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,34 @@
+package inboxer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExprValidate(t *testing.T) {
+	assert.NoError(t, (*Expr)(nil).Validate())
+	assert.NoError(t, And(Match(MailFilter{}), Or(), Not(nil)).Validate())
+	assert.NoError(t, Match(MailFilter{Expr: Not(Match(MailFilter{}))}).Validate())
+
+	assert.ErrorIs(t, (&Expr{Op: "xor"}).Validate(), ErrInvalidQuery)
+	assert.ErrorIs(t, (&Expr{Op: ExprNot}).Validate(), ErrInvalidQuery)
+	assert.ErrorIs(t, (&Expr{Op: ExprMatch, Args: []*Expr{nil}}).Validate(), ErrInvalidQuery)
+	assert.ErrorIs(t, And(Match(MailFilter{}), &Expr{Op: "xor"}).Validate(), ErrInvalidQuery)
+	assert.ErrorIs(t, Match(MailFilter{Expr: &Expr{Op: "xor"}}).Validate(), ErrInvalidQuery)
+}
+
+func TestExprMatch(t *testing.T) {
+	now := time.Now()
+	mail := &Mail{SenderID: "system", Title: "Gold rewards", Content: "Claim them", Tags: []string{"event"}}
+
+	assert.True(t, (*Expr)(nil).match(mail, now))
+	assert.True(t, And().match(mail, now))
+	assert.False(t, Or().match(mail, now))
+	assert.True(t, Match(MailFilter{Text: "CLAIM gold"}).match(mail, now))
+	assert.False(t, Match(MailFilter{Text: "claim silver"}).match(mail, now))
+	assert.True(t, Or(Match(MailFilter{SenderID: "other"}), Match(MailFilter{Tags: []string{"event"}})).match(mail, now))
+	assert.False(t, Not(Match(MailFilter{SenderID: "system"})).match(mail, now))
+	assert.False(t, (&Expr{Op: "xor"}).match(mail, now))
+}
@@ -0,0 +1,19 @@
+package inboxer
+
+// Folders for sorting mails into inbox tabs. Any other non-empty name can be used as well.
+const (
+	FolderInbox   = "inbox"   // Default folder of mails sent without one
+	FolderSystem  = "system"  // System notices
+	FolderRewards = "rewards" // Rewards and compensation
+	FolderSocial  = "social"  // Mails from other players
+	FolderGuild   = "guild"   // Guild mails
+	FolderArchive = "archive" // Mails kept out of the other folders
+)
+
+// folderOrDefault returns the folder of a mail, mails without one are in the inbox
+func folderOrDefault(folder string) string {
+	if folder == "" {
+		return FolderInbox
+	}
+	return folder
+}
@@ -0,0 +1,55 @@
+package inboxer
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Conditions matching every mail and no mail
+var (
+	matchAllSQL  = clause.Expr{SQL: "1 = 1"}
+	matchNoneSQL = clause.Expr{SQL: "1 = 0"}
+)
+
+// exprCondition compiles a query expression into a SQL condition
+func (s *GormMailStore) exprCondition(e *Expr, now time.Time) clause.Expression {
+	if e == nil {
+		return matchAllSQL
+	}
+
+	switch e.Op {
+	case ExprMatch:
+		if len(e.Args) > 0 {
+			return matchNoneSQL
+		}
+		// Build the filter conditions on an empty statement and take its WHERE clause
+		stmt := s.applyFilter(s.db.Session(&gorm.Session{NewDB: true}), e.Filter, now).Statement
+		where, ok := stmt.Clauses["WHERE"].Expression.(clause.Where)
+		if !ok || len(where.Exprs) == 0 {
+			return matchAllSQL
+		}
+		return clause.Expr{SQL: "(?)", Vars: []any{clause.And(where.Exprs...)}}
+	case ExprAnd, ExprOr:
+		if len(e.Args) == 0 {
+			if e.Op == ExprAnd {
+				return matchAllSQL
+			}
+			return matchNoneSQL
+		}
+		placeholders := make([]string, len(e.Args))
+		vars := make([]any, len(e.Args))
+		for i, arg := range e.Args {
+			placeholders[i] = "?"
+			vars[i] = s.exprCondition(arg, now)
+		}
+		return clause.Expr{SQL: "(" + strings.Join(placeholders, " "+strings.ToUpper(string(e.Op))+" ") + ")", Vars: vars}
+	case ExprNot:
+		if len(e.Args) == 1 {
+			return clause.Expr{SQL: "NOT (?)", Vars: []any{s.exprCondition(e.Args[0], now)}}
+		}
+	}
+	return matchNoneSQL
+}
@@ -0,0 +1,117 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LeaseEntity is the database model for leases
+type LeaseEntity struct {
+	Key       string    `gorm:"column:lease_key;primaryKey"`
+	Holder    string    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// TableName specifies the table name for the LeaseEntity
+func (LeaseEntity) TableName() string {
+	return "inboxer_leases"
+}
+
+// GormLeaseManager implements LeaseManager using a database table shared by all instances
+type GormLeaseManager struct {
+	db    *gorm.DB
+	clock Clock
+}
+
+// NewGormLeaseManager creates a new GORM-based lease manager.
+// The leases table is created by the schema migrations, which are applied unless WithAutoMigrate(false) is given.
+// Only WithStoreClock and WithAutoMigrate apply to it.
+func NewGormLeaseManager(db *gorm.DB, opts ...StoreOption) (*GormLeaseManager, error) {
+	if db == nil {
+		return nil, errors.New("database connection cannot be nil")
+	}
+
+	options := newStoreOptions(storeOptions{
+		autoMigrate: true,
+		clock:       SystemClock{},
+	}, opts...)
+
+	// Apply pending schema migrations
+	if options.autoMigrate {
+		migrator, err := NewMigrator(db)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create migrator: %w", err)
+		}
+		if _, err := migrator.Migrate(context.Background(), MigrateOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to migrate database schema: %w", err)
+		}
+	}
+
+	return &GormLeaseManager{
+		db:    db,
+		clock: options.clock,
+	}, nil
+}
+
+// TryAcquire acquires or renews the lease on key for holder
+func (l *GormLeaseManager) TryAcquire(ctx context.Context, key, holder string, ttl time.Duration) (bool, error) {
+	if err := validateLease(key, holder); err != nil {
+		return false, err
+	}
+	if ttl <= 0 {
+		return false, errors.New("lease ttl must be positive")
+	}
+
+	now := l.clock.Now().UTC()
+	expiresAt := now.Add(ttl)
+
+	// Take over the lease if we already hold it or it has expired
+	result := l.db.WithContext(ctx).
+		Model(&LeaseEntity{}).
+		Where("lease_key = ? AND (holder = ? OR expires_at < ?)", key, holder, now).
+		Updates(map[string]interface{}{
+			"holder":     holder,
+			"expires_at": expiresAt,
+		})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to renew lease: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	// Otherwise create it, unless another holder got there first
+	result = l.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&LeaseEntity{
+			Key:       key,
+			Holder:    holder,
+			ExpiresAt: expiresAt,
+		})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to acquire lease: %w", result.Error)
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// Release gives up the lease on key if it is owned by holder
+func (l *GormLeaseManager) Release(ctx context.Context, key, holder string) error {
+	if err := validateLease(key, holder); err != nil {
+		return err
+	}
+
+	err := l.db.WithContext(ctx).
+		Where("lease_key = ? AND holder = ?", key, holder).
+		Delete(&LeaseEntity{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+
+	return nil
+}
@@ -5,14 +5,23 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // GormMailStore implements the MailStore interface using GORM as the storage medium
 type GormMailStore struct {
-	db *gorm.DB
+	db       *gorm.DB
+	idGen    IDGenerator
+	logger   *slog.Logger
+	options  storeOptions
+	fullText bool // Whether text queries use the SQLite FTS5 index
 }
 
 // MailEntity is the database model for Mail objects
@@ -27,6 +36,13 @@ type MailEntity struct {
 	CreateTime  time.Time `gorm:"index"`
 	ExpireTime  time.Time `gorm:"index"`
 	Tags        string    `gorm:"type:text"` // JSON serialized tags
+	Priority    int       `gorm:"not null;default:0;index"`
+	Folder      string    `gorm:"size:64;not null;default:'inbox';index"`
+	Starred     bool      `gorm:"not null;default:false;index"`
+	Pinned      bool      `gorm:"not null;default:false"`
+	Archived    bool      `gorm:"not null;default:false;index"`
+	Version     int64     `gorm:"not null;default:1"`
+	ReadTime    time.Time
 	CreatedAt   time.Time // GORM's default timestamp
 	UpdatedAt   time.Time // GORM's default timestamp
 }
@@ -37,19 +53,41 @@ func (MailEntity) TableName() string {
 }
 
 // NewGormMailStore creates a new GORM-based mail storage
-func NewGormMailStore(db *gorm.DB) (*GormMailStore, error) {
+func NewGormMailStore(db *gorm.DB, opts ...StoreOption) (*GormMailStore, error) {
 	if db == nil {
 		return nil, errors.New("database connection cannot be nil")
 	}
 
-	// Auto migrate the schema
-	err := db.AutoMigrate(&MailEntity{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to migrate database schema: %w", err)
+	options := newStoreOptions(storeOptions{
+		idGen:       NewULIDGenerator(),
+		autoMigrate: true,
+		logger:      slog.Default(),
+		clock:       SystemClock{},
+	}, opts...)
+
+	// Apply pending schema migrations
+	fullText := false
+	if options.autoMigrate {
+		migrator, err := NewMigrator(db)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create migrator: %w", err)
+		}
+		if _, err := migrator.Migrate(context.Background(), MigrateOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to migrate database schema: %w", err)
+		}
+		if fullText, err = setupFullTextSearch(db); err != nil {
+			return nil, err
+		}
+	} else {
+		fullText = db.Dialector.Name() == "sqlite" && db.Migrator().HasTable(searchTable)
 	}
 
 	return &GormMailStore{
-		db: db,
+		db:       db,
+		idGen:    options.idGen,
+		logger:   options.logger,
+		options:  options,
+		fullText: fullText,
 	}, nil
 }
 
@@ -61,21 +99,21 @@ func (s *GormMailStore) CreateMail(ctx context.Context, mail *Mail) (string, err
 
 	// If mail has no ID, generate one
 	if mail.ID == "" {
-		// Use GORM's callback to generate a unique ID, or implement your own ID generation
-		mail.ID = fmt.Sprintf("mail_%d", time.Now().UnixNano())
+		mail.ID = s.idGen.GenerateID()
 	}
+	mail.Version = 1
 
 	// Convert mail to entity
 	entity, err := mailToEntity(mail)
 	if err != nil {
-		return "", fmt.Errorf("failed to convert mail to entity: %w", err)
+		return "", s.dbError(ctx, "convert mail to entity", err)
 	}
 
 	// Start transaction with context
 	tx := s.db.WithContext(ctx)
 	result := tx.Create(entity)
 	if result.Error != nil {
-		return "", fmt.Errorf("failed to create mail: %w", result.Error)
+		return "", s.dbError(ctx, "create mail", result.Error)
 	}
 
 	return mail.ID, nil
@@ -91,15 +129,15 @@ func (s *GormMailStore) GetMail(ctx context.Context, mailID string) (*Mail, erro
 	result := s.db.WithContext(ctx).First(&entity, "id = ?", mailID)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, fmt.Errorf("mail with ID %s not found", mailID)
+			return nil, fmt.Errorf("%w: %s", ErrMailNotFound, mailID)
 		}
-		return nil, fmt.Errorf("failed to get mail: %w", result.Error)
+		return nil, s.dbError(ctx, "get mail", result.Error)
 	}
 
 	// Convert entity to mail
 	mail, err := entityToMail(&entity)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert entity to mail: %w", err)
+		return nil, s.dbError(ctx, "convert entity to mail", err)
 	}
 
 	return mail, nil
@@ -111,28 +149,37 @@ func (s *GormMailStore) UpdateMail(ctx context.Context, mail *Mail) error {
 		return errors.New("mail cannot be nil and must have an ID")
 	}
 
-	// Check if mail exists
-	var count int64
-	result := s.db.WithContext(ctx).Model(&MailEntity{}).Where("id = ?", mail.ID).Count(&count)
-	if result.Error != nil {
-		return fmt.Errorf("failed to check mail existence: %w", result.Error)
-	}
-	if count == 0 {
-		return fmt.Errorf("mail with ID %s not found", mail.ID)
-	}
-
-	// Convert mail to entity
+	// Convert mail to entity with the next version
 	entity, err := mailToEntity(mail)
 	if err != nil {
-		return fmt.Errorf("failed to convert mail to entity: %w", err)
+		return s.dbError(ctx, "convert mail to entity", err)
 	}
+	entity.Version = mail.Version + 1
 
-	// Update mail
-	result = s.db.WithContext(ctx).Save(entity)
+	// Update mail only if nobody modified it since it was read
+	result := s.db.WithContext(ctx).Model(&MailEntity{}).
+		Where("id = ? AND version = ?", mail.ID, mail.Version).
+		Select("*").
+		Omit("id", "created_at").
+		Updates(entity)
 	if result.Error != nil {
-		return fmt.Errorf("failed to update mail: %w", result.Error)
+		return s.dbError(ctx, "update mail", result.Error)
 	}
 
+	if result.RowsAffected == 0 {
+		// Distinguish a missing mail from a stale version
+		var count int64
+		result = s.db.WithContext(ctx).Model(&MailEntity{}).Where("id = ?", mail.ID).Count(&count)
+		if result.Error != nil {
+			return s.dbError(ctx, "check mail existence", result.Error)
+		}
+		if count == 0 {
+			return fmt.Errorf("%w: %s", ErrMailNotFound, mail.ID)
+		}
+		return fmt.Errorf("%w: mail %s is no longer at version %d", ErrVersionConflict, mail.ID, mail.Version)
+	}
+
+	mail.Version = entity.Version
 	return nil
 }
 
@@ -144,11 +191,11 @@ func (s *GormMailStore) DeleteMail(ctx context.Context, mailID string) error {
 
 	result := s.db.WithContext(ctx).Delete(&MailEntity{}, "id = ?", mailID)
 	if result.Error != nil {
-		return fmt.Errorf("failed to delete mail: %w", result.Error)
+		return s.dbError(ctx, "delete mail", result.Error)
 	}
 
 	if result.RowsAffected == 0 {
-		return fmt.Errorf("mail with ID %s not found", mailID)
+		return fmt.Errorf("%w: %s", ErrMailNotFound, mailID)
 	}
 
 	return nil
@@ -162,7 +209,7 @@ func (s *GormMailStore) CreateBatchMails(ctx context.Context, mails []*Mail) ([]
 
 	tx := s.db.WithContext(ctx).Begin()
 	if tx.Error != nil {
-		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+		return nil, s.dbError(ctx, "begin transaction", tx.Error)
 	}
 
 	defer func() {
@@ -181,13 +228,14 @@ func (s *GormMailStore) CreateBatchMails(ctx context.Context, mails []*Mail) ([]
 
 		// If mail has no ID, generate one
 		if mail.ID == "" {
-			mail.ID = fmt.Sprintf("mail_%d_%d", time.Now().UnixNano(), len(ids))
+			mail.ID = s.idGen.GenerateID()
 		}
+		mail.Version = 1
 
 		entity, err := mailToEntity(mail)
 		if err != nil {
 			tx.Rollback()
-			return nil, fmt.Errorf("failed to convert mail to entity: %w", err)
+			return nil, s.dbError(ctx, "convert mail to entity", err)
 		}
 
 		entities = append(entities, *entity)
@@ -199,13 +247,13 @@ func (s *GormMailStore) CreateBatchMails(ctx context.Context, mails []*Mail) ([]
 		result := tx.Create(&entities)
 		if result.Error != nil {
 			tx.Rollback()
-			return nil, fmt.Errorf("failed to create batch mails: %w", result.Error)
+			return nil, s.dbError(ctx, "create batch mails", result.Error)
 		}
 	}
 
 	// Commit the transaction
 	if err := tx.Commit().Error; err != nil {
-		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		return nil, s.dbError(ctx, "commit transaction", err)
 	}
 
 	return ids, nil
@@ -219,7 +267,7 @@ func (s *GormMailStore) DeleteMailsByRecipient(ctx context.Context, recipientID
 
 	result := s.db.WithContext(ctx).Delete(&MailEntity{}, "recipient_id = ?", recipientID)
 	if result.Error != nil {
-		return fmt.Errorf("failed to delete mails by recipient: %w", result.Error)
+		return s.dbError(ctx, "delete mails by recipient", result.Error)
 	}
 
 	return nil
@@ -227,19 +275,163 @@ func (s *GormMailStore) DeleteMailsByRecipient(ctx context.Context, recipientID
 
 // DeleteExpiredMails deletes all expired mails
 func (s *GormMailStore) DeleteExpiredMails(ctx context.Context, beforeTime time.Time) (int, error) {
-	result := s.db.WithContext(ctx).Delete(&MailEntity{}, "expire_time != ? AND expire_time < ?", time.Time{}, beforeTime)
+	return s.DeleteExpiredMailsBatched(ctx, beforeTime, ExpiredDeleteOptions{})
+}
+
+// DeleteExpiredMailsBatched deletes expired mails in batches ordered by ID, pausing between batches.
+// Each batch is a short DELETE by primary key, so the mails table is never locked for long.
+func (s *GormMailStore) DeleteExpiredMailsBatched(ctx context.Context, beforeTime time.Time, opts ExpiredDeleteOptions) (int, error) {
+	opts = s.options.expiredDeleteOptions(opts)
+
+	total := 0
+	lastID := ""
+	var hookErrs []error
+	for batch := 1; ; batch++ {
+		if err := ctx.Err(); err != nil {
+			return total, errors.Join(append(hookErrs, err)...)
+		}
+
+		// Select the next batch after the last seen ID, so batches kept by the hook are not revisited
+		query := s.db.WithContext(ctx).
+			Model(&MailEntity{}).
+			Where("starred = ? AND expire_time != ? AND expire_time < ? AND id > ?", false, time.Time{}, beforeTime, lastID).
+			Order("id").
+			Limit(opts.BatchSize)
+
+		var ids []string
+		var mails []*Mail
+		if opts.BeforeDelete != nil {
+			var entities []MailEntity
+			if err := query.Find(&entities).Error; err != nil {
+				return total, s.dbError(ctx, "select expired mails", err)
+			}
+			for _, entity := range entities {
+				mail, err := entityToMail(&entity)
+				if err != nil {
+					return total, s.dbError(ctx, "convert entity to mail", err)
+				}
+				ids = append(ids, mail.ID)
+				mails = append(mails, mail)
+			}
+		} else if err := query.Pluck("id", &ids).Error; err != nil {
+			return total, s.dbError(ctx, "select expired mails", err)
+		}
+		if len(ids) == 0 {
+			return total, errors.Join(hookErrs...)
+		}
+		lastID = ids[len(ids)-1]
+
+		deleted, skipped := 0, 0
+		if err := runExpiryHook(ctx, opts.BeforeDelete, batch, mails); err != nil {
+			hookErrs = append(hookErrs, err)
+			skipped = len(ids)
+		} else {
+			result := s.db.WithContext(ctx).
+				Where("id IN ? AND starred = ? AND expire_time != ? AND expire_time < ?", ids, false, time.Time{}, beforeTime).
+				Delete(&MailEntity{})
+			if result.Error != nil {
+				return total, s.dbError(ctx, "delete expired mails", result.Error)
+			}
+			deleted = int(result.RowsAffected)
+		}
+
+		total += deleted
+		if opts.OnProgress != nil {
+			opts.OnProgress(ExpiredDeleteProgress{Batch: batch, Deleted: deleted, Skipped: skipped, Total: total})
+		}
+
+		if len(ids) < opts.BatchSize {
+			return total, errors.Join(hookErrs...)
+		}
+		if err := sleepContext(ctx, s.options.clock, opts.Pause); err != nil {
+			return total, errors.Join(append(hookErrs, err)...)
+		}
+	}
+}
+
+// UpdateByFilter applies a patch to all mails matching the filter and returns the number of updated mails
+func (s *GormMailStore) UpdateByFilter(ctx context.Context, filter *MailFilter, patch *MailPatch) (int, error) {
+	if filter == nil {
+		return 0, errors.New("filter cannot be nil")
+	}
+	if patch.isEmpty() {
+		return 0, errors.New("patch cannot be empty")
+	}
+
+	updates := map[string]interface{}{
+		"version": gorm.Expr("version + 1"),
+	}
+	if patch.ReadStatus != nil {
+		updates["read_status"] = *patch.ReadStatus
+	}
+	if patch.ReadTime != nil {
+		updates["read_time"] = *patch.ReadTime
+	}
+	if patch.ExpireTime != nil {
+		updates["expire_time"] = *patch.ExpireTime
+	}
+	if patch.Folder != nil {
+		updates["folder"] = folderOrDefault(*patch.Folder)
+	}
+	if patch.Starred != nil {
+		updates["starred"] = *patch.Starred
+	}
+	if patch.Pinned != nil {
+		updates["pinned"] = *patch.Pinned
+	}
+	if patch.Archived != nil {
+		updates["archived"] = *patch.Archived
+	}
+	if len(patch.AddTags) > 0 || len(patch.RemoveTags) > 0 {
+		expr, err := tagPatchExpr(s.db.Dialector.Name(), patch)
+		if err != nil {
+			return 0, err
+		}
+		updates["tags"] = expr
+	}
+
+	// An empty filter matches every mail, which requires an explicit condition in GORM
+	tx := s.applyFilter(s.db.WithContext(ctx).Model(&MailEntity{}), filter, s.options.clock.Now()).Where("1 = 1")
+	result := tx.Updates(updates)
+	if result.Error != nil {
+		return 0, s.dbError(ctx, "update mails by filter", result.Error)
+	}
+
+	return int(result.RowsAffected), nil
+}
+
+// DeleteByFilter deletes all mails matching the filter and returns the number of deleted mails
+func (s *GormMailStore) DeleteByFilter(ctx context.Context, filter *MailFilter) (int, error) {
+	if filter == nil {
+		return 0, errors.New("filter cannot be nil")
+	}
+
+	// An empty filter matches every mail, which requires an explicit condition in GORM
+	tx := s.applyFilter(s.db.WithContext(ctx), filter, s.options.clock.Now()).Where("1 = 1")
+	result := tx.Delete(&MailEntity{})
 	if result.Error != nil {
-		return 0, fmt.Errorf("failed to delete expired mails: %w", result.Error)
+		return 0, s.dbError(ctx, "delete mails by filter", result.Error)
 	}
 
 	return int(result.RowsAffected), nil
 }
 
-// GetMailsByRecipient retrieves mails for a specific recipient with pagination
-func (s *GormMailStore) GetMailsByRecipient(ctx context.Context, recipientID string, page, size int) ([]*Mail, int, error) {
+// dbError logs a failed store operation and wraps the error with the operation name
+func (s *GormMailStore) dbError(ctx context.Context, op string, err error) error {
+	s.logger.ErrorContext(ctx, "mail store operation failed", "store", "gorm", "op", op, "error", err)
+	return fmt.Errorf("failed to %s: %w", op, err)
+}
+
+// GetMailsByRecipient retrieves the mails of a recipient that are not archived with pagination,
+// pinned mails first and then in the given order, newest first by default
+func (s *GormMailStore) GetMailsByRecipient(ctx context.Context, recipientID string, page, size int, order ...SortOrder) ([]*Mail, int, error) {
 	if recipientID == "" {
 		return nil, 0, errors.New("recipientID cannot be empty")
 	}
+	sortOrder, err := optionalSort(order)
+	if err != nil {
+		return nil, 0, err
+	}
 
 	if page <= 0 {
 		page = 1
@@ -250,9 +442,9 @@ func (s *GormMailStore) GetMailsByRecipient(ctx context.Context, recipientID str
 
 	// Query for total count
 	var total int64
-	result := s.db.WithContext(ctx).Model(&MailEntity{}).Where("recipient_id = ?", recipientID).Count(&total)
+	result := s.db.WithContext(ctx).Model(&MailEntity{}).Where("recipient_id = ? AND archived = ?", recipientID, false).Count(&total)
 	if result.Error != nil {
-		return nil, 0, fmt.Errorf("failed to count mails by recipient: %w", result.Error)
+		return nil, 0, s.dbError(ctx, "count mails by recipient", result.Error)
 	}
 
 	// No records found
@@ -266,14 +458,14 @@ func (s *GormMailStore) GetMailsByRecipient(ctx context.Context, recipientID str
 	// Query for mail entities with pagination
 	var entities []MailEntity
 	result = s.db.WithContext(ctx).
-		Where("recipient_id = ?", recipientID).
-		Order("create_time DESC").
+		Where("recipient_id = ? AND archived = ?", recipientID, false).
+		Order(sortOrderBy(sortOrder)).
 		Offset(offset).
 		Limit(size).
 		Find(&entities)
 
 	if result.Error != nil {
-		return nil, 0, fmt.Errorf("failed to get mails by recipient: %w", result.Error)
+		return nil, 0, s.dbError(ctx, "get mails by recipient", result.Error)
 	}
 
 	// Convert entities to mails
@@ -281,7 +473,7 @@ func (s *GormMailStore) GetMailsByRecipient(ctx context.Context, recipientID str
 	for _, entity := range entities {
 		mail, err := entityToMail(&entity)
 		if err != nil {
-			return nil, 0, fmt.Errorf("failed to convert entity to mail: %w", err)
+			return nil, 0, s.dbError(ctx, "convert entity to mail", err)
 		}
 		mails = append(mails, mail)
 	}
@@ -297,44 +489,20 @@ func (s *GormMailStore) QueryMails(ctx context.Context, filter *MailFilter, page
 	if size <= 0 {
 		size = 10
 	}
+	if err := filterSort(filter).validate(); err != nil {
+		return nil, 0, err
+	}
 
 	tx := s.db.WithContext(ctx).Model(&MailEntity{})
 
 	// Apply filters
-	if filter != nil {
-		if filter.SenderID != "" {
-			tx = tx.Where("sender_id = ?", filter.SenderID)
-		}
-		if filter.RecipientID != "" {
-			tx = tx.Where("recipient_id = ?", filter.RecipientID)
-		}
-		if filter.ReadStatus != nil {
-			tx = tx.Where("read_status = ?", *filter.ReadStatus)
-		}
-		if filter.StartTime != nil {
-			tx = tx.Where("create_time >= ?", *filter.StartTime)
-		}
-		if filter.EndTime != nil {
-			tx = tx.Where("create_time <= ?", *filter.EndTime)
-		}
-		if filter.ExpiredOnly {
-			now := time.Now()
-			tx = tx.Where("expire_time != ? AND expire_time < ?", time.Time{}, now)
-		}
-		if len(filter.Tags) > 0 {
-			// This is a simplistic approach - in a real database you might use a more optimized
-			// query for tag filtering, especially for databases that support JSON operations
-			for _, tag := range filter.Tags {
-				tx = tx.Where("tags LIKE ?", "%"+tag+"%")
-			}
-		}
-	}
+	tx = s.applyFilter(tx, filter, s.options.clock.Now())
 
 	// Count total matching records
 	var total int64
 	result := tx.Count(&total)
 	if result.Error != nil {
-		return nil, 0, fmt.Errorf("failed to count filtered mails: %w", result.Error)
+		return nil, 0, s.dbError(ctx, "count filtered mails", result.Error)
 	}
 
 	// No records found
@@ -347,9 +515,15 @@ func (s *GormMailStore) QueryMails(ctx context.Context, filter *MailFilter, page
 
 	// Query for mail entities with pagination
 	var entities []MailEntity
-	result = tx.Order("create_time DESC").Offset(offset).Limit(size).Find(&entities)
+	// Text queries rank by relevance unless another order is asked for
+	if words := filterWords(filter); len(words) > 0 && filterSort(filter) == "" {
+		tx = s.orderByRelevance(tx, words)
+	} else {
+		tx = tx.Order(sortOrderBy(filterSort(filter)))
+	}
+	result = tx.Offset(offset).Limit(size).Find(&entities)
 	if result.Error != nil {
-		return nil, 0, fmt.Errorf("failed to query mails: %w", result.Error)
+		return nil, 0, s.dbError(ctx, "query mails", result.Error)
 	}
 
 	// Convert entities to mails
@@ -357,7 +531,7 @@ func (s *GormMailStore) QueryMails(ctx context.Context, filter *MailFilter, page
 	for _, entity := range entities {
 		mail, err := entityToMail(&entity)
 		if err != nil {
-			return nil, 0, fmt.Errorf("failed to convert entity to mail: %w", err)
+			return nil, 0, s.dbError(ctx, "convert entity to mail", err)
 		}
 		mails = append(mails, mail)
 	}
@@ -365,16 +539,42 @@ func (s *GormMailStore) QueryMails(ctx context.Context, filter *MailFilter, page
 	return mails, int(total), nil
 }
 
-// CountUnreadMails counts the number of unread mails for a specific recipient
+// ListExpiringBetween lists mails expiring in [from, to), soonest first
+func (s *GormMailStore) ListExpiringBetween(ctx context.Context, recipientID string, from, to time.Time) ([]*Mail, error) {
+	query := s.db.WithContext(ctx).
+		Model(&MailEntity{}).
+		Where("starred = ? AND expire_time != ? AND expire_time >= ? AND expire_time < ?", false, time.Time{}, from, to)
+	if recipientID != "" {
+		query = query.Where("recipient_id = ?", recipientID)
+	}
+
+	var entities []MailEntity
+	if err := query.Order("expire_time, id").Find(&entities).Error; err != nil {
+		return nil, s.dbError(ctx, "list expiring mails", err)
+	}
+
+	mails := make([]*Mail, 0, len(entities))
+	for _, entity := range entities {
+		mail, err := entityToMail(&entity)
+		if err != nil {
+			return nil, s.dbError(ctx, "convert entity to mail", err)
+		}
+		mails = append(mails, mail)
+	}
+
+	return mails, nil
+}
+
+// CountUnreadMails counts the number of unread mails for a specific recipient, archived mails excluded
 func (s *GormMailStore) CountUnreadMails(ctx context.Context, recipientID string) (int, error) {
 	if recipientID == "" {
 		return 0, errors.New("recipientID cannot be empty")
 	}
 
 	var count int64
-	result := s.db.WithContext(ctx).Model(&MailEntity{}).Where("recipient_id = ? AND read_status = ?", recipientID, false).Count(&count)
+	result := s.db.WithContext(ctx).Model(&MailEntity{}).Where("recipient_id = ? AND read_status = ? AND archived = ?", recipientID, false, false).Count(&count)
 	if result.Error != nil {
-		return 0, fmt.Errorf("failed to count unread mails: %w", result.Error)
+		return 0, s.dbError(ctx, "count unread mails", result.Error)
 	}
 
 	return int(count), nil
@@ -391,30 +591,336 @@ func (s *GormMailStore) CountMailsWithAttachments(ctx context.Context, recipient
 		Where("recipient_id = ? AND attachments != ? AND attachments != '[]' AND attachments != '{}'", recipientID, "").
 		Count(&count)
 	if result.Error != nil {
-		return 0, fmt.Errorf("failed to count mails with attachments: %w", result.Error)
+		return 0, s.dbError(ctx, "count mails with attachments", result.Error)
 	}
 
 	return int(count), nil
 }
 
+// CountBy counts the mails matching the filter per group, ordered by group key.
+// Groups are counted in SQL, except for dialects without the needed JSON or date functions.
+func (s *GormMailStore) CountBy(ctx context.Context, filter *MailFilter, groupBy GroupBy) ([]GroupCount, error) {
+	if err := groupBy.validate(); err != nil {
+		return nil, err
+	}
+
+	keySQL, ok := groupKeySQL(s.db.Dialector.Name(), groupBy)
+	if !ok {
+		return s.countByScan(ctx, filter, groupBy)
+	}
+
+	var rows []struct {
+		GroupKey  string
+		MailCount int
+	}
+	if err := s.countByQuery(ctx, filter, groupBy, keySQL).Scan(&rows).Error; err != nil {
+		return nil, s.dbError(ctx, "count mails by "+string(groupBy), err)
+	}
+
+	groups := make([]GroupCount, 0, len(rows))
+	for _, row := range rows {
+		groups = append(groups, GroupCount{Key: row.GroupKey, Count: row.MailCount})
+	}
+	return groups, nil
+}
+
+// countByQuery builds the query counting the mails matching the filter per group key
+func (s *GormMailStore) countByQuery(ctx context.Context, filter *MailFilter, groupBy GroupBy, keySQL string) *gorm.DB {
+	tx := s.applyFilter(s.db.WithContext(ctx).Model(&MailEntity{}), filter, s.options.clock.Now())
+	if groupBy == GroupByTag {
+		// Expand the JSON tags of the matching mails into a row per tag
+		matched := tx.Select("id, tags")
+		tx = s.db.WithContext(ctx).
+			Table("(?) AS m, "+tagRowsSQL(s.db.Dialector.Name()), matched).
+			Select(keySQL + " AS group_key, COUNT(DISTINCT m.id) AS mail_count").
+			Group(keySQL)
+	} else {
+		tx = tx.Select(keySQL + " AS group_key, COUNT(*) AS mail_count").Group(keySQL)
+	}
+	return tx.Order("group_key")
+}
+
+// countByScan counts the mails matching the filter per group while scanning them
+func (s *GormMailStore) countByScan(ctx context.Context, filter *MailFilter, groupBy GroupBy) ([]GroupCount, error) {
+	counts := make(map[string]int)
+	err := s.ScanMails(ctx, filter, ScanOptions{}, func(mails []*Mail) error {
+		for _, mail := range mails {
+			for _, key := range groupKeys(mail, groupBy) {
+				counts[key]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan mails to count by %s: %w", groupBy, err)
+	}
+	return sortedGroupCounts(counts), nil
+}
+
+// Helper function: Get the SQL table expression that expands the JSON tags of the mails m into rows t with a value column,
+// or "" if the dialect has no JSON table function
+func tagRowsSQL(dialect string) string {
+	const tags = "COALESCE(NULLIF(m.tags, ''), '[]')"
+	switch dialect {
+	case "sqlite":
+		return "json_each(" + tags + ") AS t"
+	case "postgres":
+		return "LATERAL jsonb_array_elements_text((" + tags + ")::jsonb) AS t(value)"
+	case "mysql":
+		return "JSON_TABLE(" + tags + ", '$[*]' COLUMNS (value VARCHAR(1024) PATH '$')) AS t"
+	}
+	return ""
+}
+
+// Helper function: Get the SQL expression of a group key for a dialect, reporting false if there is none
+func groupKeySQL(dialect string, groupBy GroupBy) (string, bool) {
+	switch groupBy {
+	case GroupBySender:
+		return "sender_id", true
+	case GroupByFolder:
+		return "folder", true
+	case GroupByReadStatus:
+		return "CASE WHEN read_status THEN 'true' ELSE 'false' END", true
+	case GroupByTag:
+		// Tags are expanded into rows by tagRowsSQL
+		return "t.value", tagRowsSQL(dialect) != ""
+	case GroupByDay:
+		switch dialect {
+		case "sqlite":
+			return "strftime('%Y-%m-%d', create_time)", true
+		case "postgres":
+			return "to_char(create_time AT TIME ZONE 'UTC', 'YYYY-MM-DD')", true
+		case "mysql":
+			return "DATE_FORMAT(create_time, '%Y-%m-%d')", true
+		}
+	}
+	return "", false
+}
+
+// ScanMails calls fn with pages of mails matching the filter in ID order
+func (s *GormMailStore) ScanMails(ctx context.Context, filter *MailFilter, opts ScanOptions, fn func(mails []*Mail) error) error {
+	opts = opts.withDefaults()
+	now := s.options.clock.Now()
+
+	lastID := opts.AfterID
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Page by the last seen ID, so concurrent inserts and deletes don't shift the pages
+		var entities []MailEntity
+		tx := s.applyFilter(s.db.WithContext(ctx).Model(&MailEntity{}), filter, now)
+		if err := tx.Where("id > ?", lastID).Order("id").Limit(opts.BatchSize).Find(&entities).Error; err != nil {
+			return s.dbError(ctx, "scan mails", err)
+		}
+		if len(entities) == 0 {
+			return nil
+		}
+
+		mails := make([]*Mail, 0, len(entities))
+		for _, entity := range entities {
+			mail, err := entityToMail(&entity)
+			if err != nil {
+				return s.dbError(ctx, "convert entity to mail", err)
+			}
+			mails = append(mails, mail)
+		}
+		lastID = mails[len(mails)-1].ID
+
+		if err := fn(mails); err != nil {
+			return err
+		}
+		if len(entities) < opts.BatchSize {
+			return nil
+		}
+	}
+}
+
 // ExportMailLogs exports mail logs based on filter
 func (s *GormMailStore) ExportMailLogs(ctx context.Context, filter *MailFilter) (string, error) {
-	// Reuse the QueryMails function to get filtered mails
-	// Set a high limit to get all matching mails
-	mails, _, err := s.QueryMails(ctx, filter, 1, 10000)
+	mails := []*Mail{}
+	err := s.ScanMails(ctx, filter, ScanOptions{}, func(page []*Mail) error {
+		mails = append(mails, page...)
+		return nil
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to query mails for export: %w", err)
 	}
 
+	// Sort by creation time (newest first)
+	sort.SliceStable(mails, func(i, j int) bool {
+		return mails[i].CreateTime.After(mails[j].CreateTime)
+	})
+
 	// Convert mails to JSON
 	data, err := json.MarshalIndent(mails, "", "  ")
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal mails to JSON: %w", err)
+		return "", s.dbError(ctx, "marshal mails to JSON", err)
 	}
 
 	return string(data), nil
 }
 
+// Helper function: Apply filter conditions to a query
+func applyMailFilter(tx *gorm.DB, filter *MailFilter, now time.Time) *gorm.DB {
+	if filter == nil {
+		return tx
+	}
+
+	if filter.SenderID != "" {
+		tx = tx.Where("sender_id = ?", filter.SenderID)
+	}
+	if filter.RecipientID != "" {
+		tx = tx.Where("recipient_id = ?", filter.RecipientID)
+	}
+	if filter.ReadStatus != nil {
+		tx = tx.Where("read_status = ?", *filter.ReadStatus)
+	}
+	if filter.StartTime != nil {
+		tx = tx.Where("create_time >= ?", *filter.StartTime)
+	}
+	if filter.EndTime != nil {
+		tx = tx.Where("create_time <= ?", *filter.EndTime)
+	}
+	if filter.ExpiredOnly {
+		tx = tx.Where("expire_time != ? AND expire_time < ?", time.Time{}, now)
+	}
+	if len(filter.Tags) > 0 {
+		tx = tx.Where(tagsCondition(tx.Dialector.Name(), filter.Tags))
+	}
+	if filter.ExpireStart != nil {
+		tx = tx.Where("expire_time != ? AND expire_time >= ?", time.Time{}, *filter.ExpireStart)
+	}
+	if filter.ExpireEnd != nil {
+		tx = tx.Where("expire_time != ? AND expire_time <= ?", time.Time{}, *filter.ExpireEnd)
+	}
+	if filter.HasAttachments != nil {
+		// Mails without attachments are stored with an empty JSON object
+		if *filter.HasAttachments {
+			tx = tx.Where("attachments IS NOT NULL AND attachments NOT IN ?", noAttachments)
+		} else {
+			tx = tx.Where("(attachments IS NULL OR attachments IN ?)", noAttachments)
+		}
+	}
+	for _, key := range filter.AttachmentKeys {
+		tx = whereAttachmentKey(tx, key)
+	}
+	if len(filter.SenderIDs) > 0 {
+		tx = tx.Where("sender_id IN ?", filter.SenderIDs)
+	}
+	if len(filter.RecipientIDs) > 0 {
+		tx = tx.Where("recipient_id IN ?", filter.RecipientIDs)
+	}
+	if len(filter.IDs) > 0 {
+		tx = tx.Where("id IN ?", filter.IDs)
+	}
+	if filter.Folder != "" {
+		tx = tx.Where("folder = ?", filter.Folder)
+	}
+	if filter.Starred != nil {
+		tx = tx.Where("starred = ?", *filter.Starred)
+	}
+	if filter.Pinned != nil {
+		tx = tx.Where("pinned = ?", *filter.Pinned)
+	}
+	if filter.Archived != nil {
+		tx = tx.Where("archived = ?", *filter.Archived)
+	}
+	if filter.TitlePrefix != "" {
+		// Compare the leading characters, because LIKE ignores case in SQLite
+		tx = tx.Where("SUBSTR(title, 1, ?) = ?", utf8.RuneCountInString(filter.TitlePrefix), filter.TitlePrefix)
+	}
+
+	return tx
+}
+
+// noAttachments are the stored forms of a mail without attachments
+var noAttachments = []string{"", "{}", "[]", "null"}
+
+// Helper function: Match mails whose attachments contain a key.
+// SQLite looks the key up with its JSON functions, other dialects match the serialized key.
+func whereAttachmentKey(tx *gorm.DB, key string) *gorm.DB {
+	if tx.Dialector.Name() == "sqlite" {
+		return tx.Where("json_type(NULLIF(attachments, ''), '$.' || json_quote(?)) IS NOT NULL", key)
+	}
+	keyJSON, _ := json.Marshal(key)
+	replacer := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	return tx.Where("attachments LIKE ? ESCAPE '!'", "%"+replacer.Replace(string(keyJSON))+":%")
+}
+
+// Helper function: Build a condition matching mails that have any of the tags, compared exactly.
+// SQLite expands the JSON tags with json_each; other dialects look for the JSON-encoded tag, quotes included.
+func tagsCondition(dialect string, tags []string) clause.Expression {
+	if dialect == "sqlite" {
+		return gorm.Expr("EXISTS (SELECT 1 FROM json_each(COALESCE(NULLIF(tags, ''), '[]')) WHERE value IN ?)", tags)
+	}
+
+	conditions := make([]clause.Expression, 0, len(tags))
+	for _, tag := range tags {
+		encoded, _ := json.Marshal(tag)
+		conditions = append(conditions, gorm.Expr("POSITION(? IN tags) > 0", string(encoded)))
+	}
+	return clause.Or(conditions...)
+}
+
+// Helper function: Build an expression that applies the tag changes of a patch to the tags column,
+// so a single UPDATE patches every matching mail. Existing tags not listed in RemoveTags are kept in order,
+// followed by AddTags the mail did not have. SQLite, Postgres and MySQL 8 are supported.
+func tagPatchExpr(dialect string, patch *MailPatch) (clause.Expr, error) {
+	addJSON, _ := json.Marshal(uniqueStrings(patch.AddTags))
+	removeJSON, _ := json.Marshal(uniqueStrings(patch.RemoveTags))
+	const tags = "COALESCE(NULLIF(tags, ''), '[]')"
+
+	switch dialect {
+	case "sqlite":
+		return gorm.Expr(
+			"(SELECT json_group_array(value) FROM ("+
+				"SELECT value FROM json_each("+tags+") WHERE value NOT IN (SELECT value FROM json_each(?)) "+
+				"UNION ALL "+
+				"SELECT value FROM json_each(?) WHERE value NOT IN (SELECT value FROM json_each("+tags+"))))",
+			string(removeJSON), string(addJSON),
+		), nil
+	case "postgres":
+		return gorm.Expr(
+			"(SELECT COALESCE(jsonb_agg(value ORDER BY src, ord), '[]'::jsonb)::text FROM ("+
+				"SELECT value, 0 AS src, ord FROM jsonb_array_elements_text(("+tags+")::jsonb) WITH ORDINALITY AS kept(value, ord) "+
+				"WHERE value NOT IN (SELECT jsonb_array_elements_text(CAST(? AS jsonb))) "+
+				"UNION ALL "+
+				"SELECT value, 1 AS src, ord FROM jsonb_array_elements_text(CAST(? AS jsonb)) WITH ORDINALITY AS added(value, ord) "+
+				"WHERE value NOT IN (SELECT jsonb_array_elements_text(("+tags+")::jsonb))) AS patched)",
+			string(removeJSON), string(addJSON),
+		), nil
+	case "mysql":
+		// JSON_ARRAYAGG has no ORDER BY, it follows the order of the derived table
+		const columns = "'$[*]' COLUMNS (ord FOR ORDINALITY, value VARCHAR(1024) PATH '$')"
+		return gorm.Expr(
+			"(SELECT COALESCE(JSON_ARRAYAGG(value), JSON_ARRAY()) FROM ("+
+				"SELECT kept.value, 0 AS src, kept.ord FROM JSON_TABLE("+tags+", "+columns+") AS kept "+
+				"WHERE NOT JSON_CONTAINS(?, JSON_QUOTE(kept.value)) "+
+				"UNION ALL "+
+				"SELECT added.value, 1 AS src, added.ord FROM JSON_TABLE(?, "+columns+") AS added "+
+				"WHERE NOT JSON_CONTAINS("+tags+", JSON_QUOTE(added.value)) "+
+				"ORDER BY src, ord) AS patched)",
+			string(removeJSON), string(addJSON),
+		), nil
+	}
+	return clause.Expr{}, fmt.Errorf("tag patches are not supported on the %s dialect", dialect)
+}
+
+// Helper function: Remove duplicates from a string slice while keeping order
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
 // Helper function: Convert Mail to MailEntity
 func mailToEntity(mail *Mail) (*MailEntity, error) {
 	entity := &MailEntity{
@@ -424,8 +930,15 @@ func mailToEntity(mail *Mail) (*MailEntity, error) {
 		Title:       mail.Title,
 		Content:     mail.Content,
 		ReadStatus:  mail.ReadStatus,
+		ReadTime:    mail.ReadTime,
 		CreateTime:  mail.CreateTime,
 		ExpireTime:  mail.ExpireTime,
+		Priority:    mail.Priority,
+		Folder:      folderOrDefault(mail.Folder),
+		Starred:     mail.Starred,
+		Pinned:      mail.Pinned,
+		Archived:    mail.Archived,
+		Version:     mail.Version,
 	}
 
 	// Serialize attachments to JSON
@@ -462,8 +975,15 @@ func entityToMail(entity *MailEntity) (*Mail, error) {
 		Title:       entity.Title,
 		Content:     entity.Content,
 		ReadStatus:  entity.ReadStatus,
+		ReadTime:    entity.ReadTime,
 		CreateTime:  entity.CreateTime,
 		ExpireTime:  entity.ExpireTime,
+		Priority:    entity.Priority,
+		Folder:      entity.Folder,
+		Starred:     entity.Starred,
+		Pinned:      entity.Pinned,
+		Archived:    entity.Archived,
+		Version:     entity.Version,
 	}
 
 	// Deserialize attachments from JSON
@@ -1,14 +1,22 @@
 package inboxer
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"log/slog"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/weedbox/inboxer/inboxertest"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 // setupTestDB creates an in-memory SQLite database for testing
@@ -742,6 +750,7 @@ func TestMailToEntityAndEntityToMail(t *testing.T) {
 			"items": []string{"sword", "shield"},
 		},
 		ReadStatus: true,
+		ReadTime:   now,
 		CreateTime: now,
 		ExpireTime: now.Add(24 * time.Hour),
 		Tags:       []string{"test", "important"},
@@ -756,6 +765,7 @@ func TestMailToEntityAndEntityToMail(t *testing.T) {
 	assert.Equal(t, mail.Title, entity.Title)
 	assert.Equal(t, mail.Content, entity.Content)
 	assert.Equal(t, mail.ReadStatus, entity.ReadStatus)
+	assert.Equal(t, mail.ReadTime, entity.ReadTime)
 	assert.Equal(t, mail.CreateTime, entity.CreateTime)
 	assert.Equal(t, mail.ExpireTime, entity.ExpireTime)
 	assert.NotEmpty(t, entity.Attachments)
@@ -770,6 +780,7 @@ func TestMailToEntityAndEntityToMail(t *testing.T) {
 	assert.Equal(t, mail.Title, convertedMail.Title)
 	assert.Equal(t, mail.Content, convertedMail.Content)
 	assert.Equal(t, mail.ReadStatus, convertedMail.ReadStatus)
+	assert.Equal(t, mail.ReadTime.Unix(), convertedMail.ReadTime.Unix())
 	assert.Equal(t, mail.CreateTime.Unix(), convertedMail.CreateTime.Unix()) // Compare Unix timestamps for time equality
 	assert.Equal(t, mail.ExpireTime.Unix(), convertedMail.ExpireTime.Unix())
 	assert.Equal(t, len(mail.Tags), len(convertedMail.Tags))
@@ -819,3 +830,937 @@ func TestMailToEntityAndEntityToMail(t *testing.T) {
 	assert.NotNil(t, convertedMailEmptyJSON)
 	assert.Equal(t, "test-id-3", convertedMailEmptyJSON.ID)
 }
+
+func TestGormMailStore_UpdateMailVersionConflict(t *testing.T) {
+	store := setupGormMailStore(t)
+	ctx := context.Background()
+
+	mail := createTestMail("system", "user1", "Original", "Content")
+	id, err := store.CreateMail(ctx, mail)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), mail.Version)
+
+	// Two readers get the same version
+	first, err := store.GetMail(ctx, id)
+	require.NoError(t, err)
+	second, err := store.GetMail(ctx, id)
+	require.NoError(t, err)
+
+	// The first update wins and bumps the version
+	first.Title = "First"
+	assert.NoError(t, store.UpdateMail(ctx, first))
+	assert.Equal(t, int64(2), first.Version)
+
+	// The second update is stale
+	second.ReadStatus = true
+	err = store.UpdateMail(ctx, second)
+	assert.ErrorIs(t, err, ErrVersionConflict)
+
+	stored, err := store.GetMail(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "First", stored.Title)
+	assert.False(t, stored.ReadStatus)
+	assert.Equal(t, int64(2), stored.Version)
+
+	// Missing mails are reported as not found
+	_, err = store.GetMail(ctx, "missing")
+	assert.ErrorIs(t, err, ErrMailNotFound)
+	err = store.UpdateMail(ctx, &Mail{ID: "missing", Version: 1})
+	assert.ErrorIs(t, err, ErrMailNotFound)
+}
+
+func TestGormMailStore_UpdateByFilter(t *testing.T) {
+	store := setupGormMailStore(t)
+	ctx := context.Background()
+
+	_, err := store.CreateBatchMails(ctx, []*Mail{
+		{RecipientID: "user1", Tags: []string{"event", "old"}},
+		{RecipientID: "user1", Tags: []string{"reward"}},
+		{RecipientID: "user1", ReadStatus: true, Tags: []string{"event"}},
+		{RecipientID: "user2", Tags: []string{"event"}},
+	})
+	require.NoError(t, err)
+
+	// Mark unread mails of user1 as read, add and remove tags in one statement
+	unread := false
+	read := true
+	expireTime := time.Now().Add(time.Hour).Truncate(time.Second)
+	count, err := store.UpdateByFilter(ctx, &MailFilter{RecipientID: "user1", ReadStatus: &unread}, &MailPatch{
+		ReadStatus: &read,
+		ExpireTime: &expireTime,
+		AddTags:    []string{"event", "claimed", "claimed"},
+		RemoveTags: []string{"old"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	mails, _, err := store.QueryMails(ctx, &MailFilter{RecipientID: "user1", Tags: []string{"claimed"}}, 1, 10)
+	assert.NoError(t, err)
+	require.Len(t, mails, 2)
+	for _, mail := range mails {
+		assert.True(t, mail.ReadStatus)
+		assert.True(t, mail.ExpireTime.Equal(expireTime))
+		assert.NotContains(t, mail.Tags, "old")
+		assert.Equal(t, int64(2), mail.Version)
+	}
+
+	// Tag order is kept and duplicates are not added
+	for _, mail := range mails {
+		if mail.Tags[0] == "event" {
+			assert.Equal(t, []string{"event", "claimed"}, mail.Tags)
+		} else {
+			assert.Equal(t, []string{"reward", "event", "claimed"}, mail.Tags)
+		}
+	}
+
+	// Other mails are untouched
+	unreadCount, err := store.CountUnreadMails(ctx, "user2")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, unreadCount)
+
+	// Invalid arguments
+	_, err = store.UpdateByFilter(ctx, nil, &MailPatch{ReadStatus: &read})
+	assert.Error(t, err)
+	_, err = store.UpdateByFilter(ctx, &MailFilter{}, &MailPatch{})
+	assert.Error(t, err)
+}
+
+func TestGormMailStore_DeleteByFilter(t *testing.T) {
+	store := setupGormMailStore(t)
+	ctx := context.Background()
+
+	_, err := store.CreateBatchMails(ctx, []*Mail{
+		{SenderID: "system", RecipientID: "user1", Tags: []string{"event"}},
+		{SenderID: "system", RecipientID: "user2", Tags: []string{"event"}},
+		{SenderID: "player1", RecipientID: "user1", Tags: []string{"event"}},
+		{SenderID: "system", RecipientID: "user1", Tags: []string{"reward"}},
+	})
+	require.NoError(t, err)
+
+	count, err := store.DeleteByFilter(ctx, &MailFilter{SenderID: "system", Tags: []string{"event"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// An empty filter deletes everything left
+	count, err = store.DeleteByFilter(ctx, &MailFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// Nil filter is rejected
+	_, err = store.DeleteByFilter(ctx, nil)
+	assert.Error(t, err)
+}
+
+func TestGormMailStore_Logging(t *testing.T) {
+	ctx := context.Background()
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+
+	store, err := NewGormMailStore(setupTestDB(t), WithStoreLogger(logger))
+	require.NoError(t, err)
+
+	// Break the store by dropping its table
+	require.NoError(t, store.db.Migrator().DropTable(&MailEntity{}))
+
+	_, err = store.CreateMail(ctx, createTestMail("system", "user1", "Title", "Content"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to create mail")
+
+	output := buf.String()
+	assert.Contains(t, output, `"level":"ERROR"`)
+	assert.Contains(t, output, `"store":"gorm"`)
+	assert.Contains(t, output, `"op":"create mail"`)
+}
+
+func TestGormMailStore_DeleteExpiredMailsBatched(t *testing.T) {
+	store, err := NewGormMailStore(setupTestDB(t), WithDeleteBatchSize(10), WithDeleteBatchPause(time.Millisecond))
+	require.NoError(t, err)
+	ctx := context.Background()
+	now := time.Now()
+
+	mails := []*Mail{}
+	for i := 0; i < 25; i++ {
+		mails = append(mails, &Mail{RecipientID: "user1", ExpireTime: now.Add(-time.Hour)})
+	}
+	mails = append(mails,
+		&Mail{RecipientID: "user1", ExpireTime: now.Add(time.Hour)},
+		&Mail{RecipientID: "user1"}, // Never expires
+	)
+	_, err = store.CreateBatchMails(ctx, mails)
+	require.NoError(t, err)
+
+	// Mails are deleted in batches of the configured size
+	progress := []ExpiredDeleteProgress{}
+	count, err := store.DeleteExpiredMailsBatched(ctx, now, ExpiredDeleteOptions{
+		OnProgress: func(p ExpiredDeleteProgress) {
+			progress = append(progress, p)
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 25, count)
+	assert.Equal(t, []ExpiredDeleteProgress{
+		{Batch: 1, Deleted: 10, Total: 10},
+		{Batch: 2, Deleted: 10, Total: 20},
+		{Batch: 3, Deleted: 5, Total: 25},
+	}, progress)
+
+	_, total, err := store.GetMailsByRecipient(ctx, "user1", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+
+	// The batch size can be overridden per call
+	_, err = store.CreateBatchMails(ctx, mails[:5])
+	require.NoError(t, err)
+	progress = nil
+	count, err = store.DeleteExpiredMailsBatched(ctx, now, ExpiredDeleteOptions{
+		BatchSize: 2,
+		OnProgress: func(p ExpiredDeleteProgress) {
+			progress = append(progress, p)
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, count)
+	assert.Len(t, progress, 3)
+}
+
+func TestGormMailStore_DeleteExpiredMailsBatchedCancel(t *testing.T) {
+	store, err := NewGormMailStore(setupTestDB(t), WithDeleteBatchSize(10), WithDeleteBatchPause(time.Millisecond))
+	require.NoError(t, err)
+	now := time.Now()
+
+	mails := []*Mail{}
+	for i := 0; i < 25; i++ {
+		mails = append(mails, &Mail{RecipientID: "user1", ExpireTime: now.Add(-time.Hour)})
+	}
+	_, err = store.CreateBatchMails(context.Background(), mails)
+	require.NoError(t, err)
+
+	// Cancelling during the pause stops after the current batch
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	count, err := store.DeleteExpiredMailsBatched(ctx, now, ExpiredDeleteOptions{
+		Pause: time.Hour,
+		OnProgress: func(p ExpiredDeleteProgress) {
+			cancel()
+		},
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 10, count)
+
+	_, total, err := store.GetMailsByRecipient(context.Background(), "user1", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 15, total)
+}
+
+func TestGormMailStore_DeleteExpiredMailsBeforeDelete(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	mails := []*Mail{}
+	for i := 0; i < 25; i++ {
+		mails = append(mails, &Mail{
+			RecipientID: "user1",
+			Attachments: map[string]interface{}{"coins": "100"},
+			ExpireTime:  now.Add(-time.Hour),
+		})
+	}
+	store, err := NewGormMailStore(setupTestDB(t), WithDeleteBatchSize(10))
+	require.NoError(t, err)
+	_, err = store.CreateBatchMails(ctx, mails)
+	require.NoError(t, err)
+
+	// The hook sees every batch with its attachments, and a failure keeps that batch
+	seen := 0
+	progress := []ExpiredDeleteProgress{}
+	count, err := store.DeleteExpiredMailsBatched(ctx, now, ExpiredDeleteOptions{
+		BeforeDelete: func(ctx context.Context, batch []*Mail) error {
+			seen += len(batch)
+			for _, mail := range batch {
+				assert.Equal(t, "100", mail.Attachments["coins"])
+			}
+			if seen > 10 && seen <= 20 {
+				return errors.New("grant service unavailable")
+			}
+			return nil
+		},
+		OnProgress: func(p ExpiredDeleteProgress) {
+			progress = append(progress, p)
+		},
+	})
+	assert.ErrorContains(t, err, "grant service unavailable")
+	assert.ErrorContains(t, err, "batch 2")
+	assert.Equal(t, 15, count)
+	assert.Equal(t, 25, seen)
+	assert.Equal(t, []ExpiredDeleteProgress{
+		{Batch: 1, Deleted: 10, Total: 10},
+		{Batch: 2, Skipped: 10, Total: 10},
+		{Batch: 3, Deleted: 5, Total: 15},
+	}, progress)
+
+	_, total, err := store.GetMailsByRecipient(ctx, "user1", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, total)
+
+	// The kept batch is deleted once the hook succeeds
+	count, err = store.DeleteExpiredMailsBatched(ctx, now, ExpiredDeleteOptions{
+		BeforeDelete: func(ctx context.Context, batch []*Mail) error { return nil },
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, count)
+}
+
+func TestGormMailStore_ListExpiringBetween(t *testing.T) {
+	store := setupGormMailStore(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	_, err := store.CreateBatchMails(ctx, []*Mail{
+		{RecipientID: "user1", Title: "Later", ExpireTime: now.Add(2 * time.Hour)},
+		{RecipientID: "user1", Title: "Soon", ExpireTime: now.Add(time.Hour)},
+		{RecipientID: "user1", Title: "Outside", ExpireTime: now.Add(48 * time.Hour)},
+		{RecipientID: "user1", Title: "Expired", ExpireTime: now.Add(-time.Hour)},
+		{RecipientID: "user1", Title: "Never"},
+		{RecipientID: "user2", Title: "Other", ExpireTime: now.Add(time.Hour)},
+	})
+	require.NoError(t, err)
+
+	// Mails are listed soonest first
+	mails, err := store.ListExpiringBetween(ctx, "user1", now, now.Add(24*time.Hour))
+	assert.NoError(t, err)
+	require.Len(t, mails, 2)
+	assert.Equal(t, "Soon", mails[0].Title)
+	assert.Equal(t, "Later", mails[1].Title)
+
+	// An empty recipient lists every recipient
+	mails, err = store.ListExpiringBetween(ctx, "", now, now.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, mails, 3)
+}
+
+func TestGormMailStore_Clock(t *testing.T) {
+	clock := inboxertest.NewFakeClock(time.Now())
+	store, err := NewGormMailStore(setupTestDB(t), WithStoreClock(clock))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = store.CreateMail(ctx, &Mail{RecipientID: "user1", ExpireTime: clock.Now().Add(time.Hour)})
+	require.NoError(t, err)
+
+	// Expiry is decided by the store clock
+	_, count, err := store.QueryMails(ctx, &MailFilter{ExpiredOnly: true}, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	clock.Advance(2 * time.Hour)
+	_, count, err = store.QueryMails(ctx, &MailFilter{ExpiredOnly: true}, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// Batch pauses wait on the store clock
+	_, err = store.CreateBatchMails(ctx, []*Mail{
+		{RecipientID: "user1", ExpireTime: clock.Now().Add(-time.Minute)},
+		{RecipientID: "user1", ExpireTime: clock.Now().Add(-time.Minute)},
+	})
+	require.NoError(t, err)
+
+	done := make(chan int)
+	go func() {
+		count, err := store.DeleteExpiredMailsBatched(ctx, clock.Now(), ExpiredDeleteOptions{BatchSize: 2, Pause: time.Minute})
+		assert.NoError(t, err)
+		done <- count
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	assert.Equal(t, 3, <-done)
+}
+
+func TestGormMailStore_ScanMails(t *testing.T) {
+	store := setupGormMailStore(t)
+	ctx := context.Background()
+
+	mails := make([]*Mail, 5)
+	for i := range mails {
+		mails[i] = createTestMail("sender1", "user1", "Scan", "Content")
+	}
+	mails = append(mails, createTestMail("sender1", "user2", "Other", "Content"))
+	ids, err := store.CreateBatchMails(ctx, mails)
+	require.NoError(t, err)
+
+	// Pages are returned in ID order, and deleting scanned mails does not skip any
+	var scanned []string
+	pages := 0
+	err = store.ScanMails(ctx, &MailFilter{RecipientID: "user1"}, ScanOptions{BatchSize: 2}, func(mails []*Mail) error {
+		pages++
+		for _, mail := range mails {
+			scanned = append(scanned, mail.ID)
+			require.NoError(t, store.DeleteMail(ctx, mail.ID))
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, ids[:5], scanned)
+
+	// A scan resumes after the given ID
+	count := 0
+	err = store.ScanMails(ctx, nil, ScanOptions{AfterID: ids[4]}, func(mails []*Mail) error {
+		count += len(mails)
+		assert.Equal(t, "user2", mails[0].RecipientID)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// Callback errors stop the scan
+	stop := errors.New("stop")
+	err = store.ScanMails(ctx, nil, ScanOptions{}, func(mails []*Mail) error {
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+
+	// Cancelled scans stop before the next page
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	err = store.ScanMails(cancelCtx, nil, ScanOptions{}, func(mails []*Mail) error {
+		t.Fatal("cancelled scan called fn")
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestGormMailStore_TextSearch(t *testing.T) {
+	store := setupGormMailStore(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	mails := []*Mail{
+		{RecipientID: "user1", Title: "Gold reward", Content: "Claim your gold", CreateTime: now.Add(-time.Hour)},
+		{RecipientID: "user1", Title: "Weekly news", Content: "Gold prices rose", CreateTime: now},
+		{RecipientID: "user1", Title: "Maintenance", Content: "Servers are down", CreateTime: now},
+		{RecipientID: "user2", Title: "Gold reward", Content: "Claim your gold", CreateTime: now},
+	}
+	ids, err := store.CreateBatchMails(ctx, mails)
+	require.NoError(t, err)
+
+	// Ranking and matching are the same with FTS5 and without it
+	t.Logf("full-text index: %t", store.fullText)
+
+	// Results are ranked by relevance, then newest first
+	results, total, err := store.QueryMails(ctx, &MailFilter{RecipientID: "user1", Text: "GOLD"}, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+	require.Len(t, results, 2)
+	assert.Equal(t, ids[0], results[0].ID)
+	assert.Equal(t, ids[1], results[1].ID)
+
+	// Every word must match
+	results, total, err = store.QueryMails(ctx, &MailFilter{Text: "gold prices"}, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, ids[1], results[0].ID)
+
+	// A query without words matches every mail
+	_, total, err = store.QueryMails(ctx, &MailFilter{Text: "  ?! "}, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 4, total)
+
+	// Updates are reindexed
+	mail, err := store.GetMail(ctx, ids[2])
+	require.NoError(t, err)
+	mail.Content = "Gold compensation for the downtime"
+	require.NoError(t, store.UpdateMail(ctx, mail))
+	_, total, err = store.QueryMails(ctx, &MailFilter{Text: "servers"}, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 0, total)
+	_, total, err = store.QueryMails(ctx, &MailFilter{Text: "compensation"}, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+
+	// Deleted mails are no longer found
+	count, err := store.DeleteByFilter(ctx, &MailFilter{RecipientID: "user2", Text: "gold"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	_, total, err = store.QueryMails(ctx, &MailFilter{Text: "gold"}, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+}
+
+func TestGormMailStore_QueryMailsExtendedFilter(t *testing.T) {
+	store := setupGormMailStore(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	mails := []*Mail{
+		{SenderID: "system", RecipientID: "user1", Title: "Season Rewards", ExpireTime: now.Add(time.Hour),
+			Attachments: map[string]interface{}{"coins": 100, "gems": 5}},
+		{SenderID: "player1", RecipientID: "user2", Title: "season greetings", ExpireTime: now.Add(48 * time.Hour),
+			Attachments: map[string]interface{}{"coins": 10}},
+		{SenderID: "player2", RecipientID: "user3", Title: "Season_Pass info",
+			Attachments: map[string]interface{}{"note": map[string]interface{}{"gems": 1}}},
+		{SenderID: "system", RecipientID: "user1", Title: "Maintenance"},
+	}
+	ids, err := store.CreateBatchMails(ctx, mails)
+	require.NoError(t, err)
+
+	query := func(filter *MailFilter) []string {
+		results, _, err := store.QueryMails(ctx, filter, 1, 10)
+		require.NoError(t, err)
+		found := []string{}
+		for _, mail := range results {
+			found = append(found, mail.ID)
+		}
+		return found
+	}
+
+	// Expiration time range excludes mails that never expire
+	start, end, later := now.Add(30*time.Minute), now.Add(2*time.Hour), now.Add(72*time.Hour)
+	assert.ElementsMatch(t, []string{ids[0]}, query(&MailFilter{ExpireStart: &start, ExpireEnd: &end}))
+	assert.ElementsMatch(t, []string{ids[1]}, query(&MailFilter{ExpireStart: &end}))
+	assert.ElementsMatch(t, []string{ids[0], ids[1]}, query(&MailFilter{ExpireEnd: &later}))
+
+	// Attachments
+	has, hasNot := true, false
+	assert.ElementsMatch(t, ids[:3], query(&MailFilter{HasAttachments: &has}))
+	assert.ElementsMatch(t, []string{ids[3]}, query(&MailFilter{HasAttachments: &hasNot}))
+	assert.ElementsMatch(t, []string{ids[0], ids[1]}, query(&MailFilter{AttachmentKeys: []string{"coins"}}))
+	assert.ElementsMatch(t, []string{ids[0]}, query(&MailFilter{AttachmentKeys: []string{"coins", "gems"}}))
+
+	// ID lists
+	assert.ElementsMatch(t, []string{ids[1], ids[2]}, query(&MailFilter{SenderIDs: []string{"player1", "player2"}}))
+	assert.ElementsMatch(t, []string{ids[0], ids[2], ids[3]}, query(&MailFilter{RecipientIDs: []string{"user1", "user3"}}))
+	assert.ElementsMatch(t, []string{ids[0]}, query(&MailFilter{RecipientIDs: []string{"user1", "user3"}, SenderID: "system", HasAttachments: &has}))
+	assert.ElementsMatch(t, []string{ids[1], ids[3]}, query(&MailFilter{IDs: []string{ids[1], ids[3], "missing"}}))
+
+	// Title prefix is case-sensitive and has no wildcards
+	assert.ElementsMatch(t, []string{ids[0], ids[2]}, query(&MailFilter{TitlePrefix: "Season"}))
+	assert.ElementsMatch(t, []string{ids[2]}, query(&MailFilter{TitlePrefix: "Season_"}))
+	assert.Empty(t, query(&MailFilter{TitlePrefix: "Season%"}))
+}
+
+func TestGormMailStore_QueryMailsExpr(t *testing.T) {
+	store := setupGormMailStore(t)
+	ctx := context.Background()
+
+	mails := []*Mail{
+		{SenderID: "system", RecipientID: "user1", Title: "Event rewards", Tags: []string{"event"}, ReadStatus: true},
+		{SenderID: "system", RecipientID: "user1", Title: "Maintenance notice", Tags: []string{"notice"}},
+		{SenderID: "player1", RecipientID: "user1", Title: "Join my event", Tags: []string{"event"}},
+		{SenderID: "player2", RecipientID: "user1", Title: "Hello", Attachments: map[string]interface{}{"coins": 1}},
+		{SenderID: "system", RecipientID: "user2", Title: "Event rewards", Tags: []string{"event"}},
+	}
+	ids, err := store.CreateBatchMails(ctx, mails)
+	require.NoError(t, err)
+
+	query := func(q string) []string {
+		expr, err := ParseQuery(q)
+		require.NoError(t, err)
+		results, total, err := store.QueryMails(ctx, &MailFilter{RecipientID: "user1", Expr: expr}, 1, 10)
+		require.NoError(t, err)
+		assert.Len(t, results, total)
+		found := []string{}
+		for _, mail := range results {
+			found = append(found, mail.ID)
+		}
+		return found
+	}
+
+	assert.ElementsMatch(t, []string{ids[1], ids[2]}, query("(tag:event OR sender:system) AND NOT read"))
+	assert.ElementsMatch(t, []string{ids[0], ids[2], ids[3]}, query("tag:event OR has:attachments"))
+	assert.ElementsMatch(t, []string{ids[3]}, query("NOT (sender:system OR tag:event)"))
+	assert.ElementsMatch(t, []string{ids[0], ids[2]}, query("event NOT maintenance"))
+	assert.ElementsMatch(t, []string{ids[1]}, query(`"maintenance notice" OR title:"Event x"`))
+	assert.ElementsMatch(t, ids[:4], query("*"))
+	assert.Empty(t, query("NOT *"))
+
+	// Expressions apply to bulk operations too
+	expr, err := ParseQuery("sender:system NOT tag:event")
+	require.NoError(t, err)
+	count, err := store.DeleteByFilter(ctx, &MailFilter{Expr: expr})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// Invalid expressions match no mail
+	_, total, err := store.QueryMails(ctx, &MailFilter{Expr: &Expr{Op: "xor"}}, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 0, total)
+}
+
+func TestGormMailStore_QueryMailsSort(t *testing.T) {
+	store := setupGormMailStore(t)
+	ctx := context.Background()
+
+	base := time.Now().Truncate(time.Second)
+	mails := []*Mail{
+		{RecipientID: "user1", Title: "a", CreateTime: base.Add(-3 * time.Hour), ReadStatus: true, ExpireTime: base.Add(2 * time.Hour)},
+		{RecipientID: "user1", Title: "b", CreateTime: base.Add(-2 * time.Hour), Priority: 5},
+		{RecipientID: "user1", Title: "c", CreateTime: base.Add(-1 * time.Hour), ReadStatus: true, Priority: 5, ExpireTime: base.Add(time.Hour)},
+		{RecipientID: "user1", Title: "d", CreateTime: base, Priority: 1},
+		{RecipientID: "user1", Title: "e", CreateTime: base, ReadStatus: true},
+	}
+	_, err := store.CreateBatchMails(ctx, mails)
+	require.NoError(t, err)
+
+	// Pages of two, collected in order
+	titles := func(order SortOrder) string {
+		result := ""
+		for page := 1; page <= 3; page++ {
+			results, total, err := store.QueryMails(ctx, &MailFilter{RecipientID: "user1", Sort: order}, page, 2)
+			require.NoError(t, err)
+			assert.Equal(t, 5, total)
+			for _, mail := range results {
+				result += mail.Title
+			}
+		}
+		return result
+	}
+
+	// Mails created at the same time are ordered by ID
+	assert.Equal(t, "edcba", titles(""))
+	assert.Equal(t, "edcba", titles(SortNewest))
+	assert.Equal(t, "abcde", titles(SortOldest))
+	assert.Equal(t, "dbeca", titles(SortUnreadFirst))
+	assert.Equal(t, "cbdea", titles(SortPriority))
+	assert.Equal(t, "caedb", titles(SortExpiringSoonest))
+
+	_, _, err = store.QueryMails(ctx, &MailFilter{Sort: "random"}, 1, 10)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+func TestGormMailStore_CountBy(t *testing.T) {
+	store := setupGormMailStore(t)
+	ctx := context.Background()
+
+	day1 := time.Date(2026, 1, 2, 23, 30, 0, 0, time.UTC)
+	day2 := time.Date(2026, 1, 3, 1, 0, 0, 0, time.FixedZone("UTC+3", 3*60*60)) // 2026-01-02 22:00 UTC
+	day3 := time.Date(2026, 1, 3, 8, 0, 0, 0, time.UTC)
+	mails := []*Mail{
+		{SenderID: "system", RecipientID: "user1", Title: "Event", CreateTime: day1, Tags: []string{"event", "reward"}},
+		{SenderID: "system", RecipientID: "user1", Title: "Notice", CreateTime: day2, Tags: []string{"event"}, ReadStatus: true},
+		{SenderID: "player1", RecipientID: "user1", Title: "Hi", CreateTime: day3},
+		{SenderID: "system", RecipientID: "user2", Title: "Event", CreateTime: day3, Tags: []string{"event"}},
+	}
+	_, err := store.CreateBatchMails(ctx, mails)
+	require.NoError(t, err)
+
+	filter := &MailFilter{RecipientID: "user1"}
+	groups, err := store.CountBy(ctx, filter, GroupByTag)
+	require.NoError(t, err)
+	assert.Equal(t, []GroupCount{{Key: "event", Count: 2}, {Key: "reward", Count: 1}}, groups)
+
+	groups, err = store.CountBy(ctx, filter, GroupBySender)
+	require.NoError(t, err)
+	assert.Equal(t, []GroupCount{{Key: "player1", Count: 1}, {Key: "system", Count: 2}}, groups)
+
+	groups, err = store.CountBy(ctx, filter, GroupByReadStatus)
+	require.NoError(t, err)
+	assert.Equal(t, []GroupCount{{Key: "false", Count: 2}, {Key: "true", Count: 1}}, groups)
+
+	// Days are in UTC
+	groups, err = store.CountBy(ctx, filter, GroupByDay)
+	require.NoError(t, err)
+	assert.Equal(t, []GroupCount{{Key: "2026-01-02", Count: 2}, {Key: "2026-01-03", Count: 1}}, groups)
+
+	// Every filter field applies
+	expr, err := ParseQuery("tag:event NOT read")
+	require.NoError(t, err)
+	groups, err = store.CountBy(ctx, &MailFilter{Expr: expr}, GroupBySender)
+	require.NoError(t, err)
+	assert.Equal(t, []GroupCount{{Key: "system", Count: 2}}, groups)
+
+	groups, err = store.CountBy(ctx, &MailFilter{RecipientID: "nobody"}, GroupByTag)
+	require.NoError(t, err)
+	assert.Empty(t, groups)
+
+	_, err = store.CountBy(ctx, filter, "recipient")
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+
+	// Counting while scanning gives the same groups as SQL
+	for _, groupBy := range []GroupBy{GroupByTag, GroupBySender, GroupByReadStatus, GroupByDay, GroupByFolder} {
+		inSQL, err := store.CountBy(ctx, filter, groupBy)
+		require.NoError(t, err)
+		scanned, err := store.countByScan(ctx, filter, groupBy)
+		require.NoError(t, err)
+		assert.Equal(t, inSQL, scanned, groupBy)
+	}
+}
+
+func TestGormMailStore_Folders(t *testing.T) {
+	store := setupGormMailStore(t)
+	ctx := context.Background()
+
+	ids, err := store.CreateBatchMails(ctx, []*Mail{
+		{SenderID: "system", RecipientID: "user1", Title: "Notice", CreateTime: time.Now()},
+		{SenderID: "system", RecipientID: "user1", Title: "Reward", CreateTime: time.Now(), Folder: FolderRewards},
+		{SenderID: "player1", RecipientID: "user1", Title: "Hi", CreateTime: time.Now(), Folder: FolderSocial, ReadStatus: true},
+		{SenderID: "system", RecipientID: "user2", Title: "Reward", CreateTime: time.Now(), Folder: FolderRewards},
+	})
+	require.NoError(t, err)
+
+	// Mails without a folder are in the inbox
+	mail, err := store.GetMail(ctx, ids[0])
+	require.NoError(t, err)
+	assert.Equal(t, FolderInbox, mail.Folder)
+
+	mails, total, err := store.QueryMails(ctx, &MailFilter{RecipientID: "user1", Folder: FolderRewards}, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, ids[1], mails[0].ID)
+
+	// Moving mails bumps their versions
+	folder := FolderArchive
+	count, err := store.UpdateByFilter(ctx, &MailFilter{IDs: ids[:2]}, &MailPatch{Folder: &folder})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	mail, err = store.GetMail(ctx, ids[1])
+	require.NoError(t, err)
+	assert.Equal(t, FolderArchive, mail.Folder)
+	assert.Equal(t, int64(2), mail.Version)
+
+	expr, err := ParseQuery("folder:archive OR folder:social")
+	require.NoError(t, err)
+	_, total, err = store.QueryMails(ctx, &MailFilter{Expr: expr}, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+
+	groups, err := store.CountBy(ctx, &MailFilter{RecipientID: "user1"}, GroupByFolder)
+	require.NoError(t, err)
+	assert.Equal(t, []GroupCount{{Key: FolderArchive, Count: 2}, {Key: FolderSocial, Count: 1}}, groups)
+}
+
+func TestGormMailStore_Flags(t *testing.T) {
+	store := setupGormMailStore(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	ids, err := store.CreateBatchMails(ctx, []*Mail{
+		{SenderID: "system", RecipientID: "user1", Title: "Old pinned", Content: "gold", CreateTime: now.Add(-3 * time.Hour), Pinned: true},
+		{SenderID: "system", RecipientID: "user1", Title: "Starred", CreateTime: now.Add(-2 * time.Hour), ExpireTime: now.Add(-time.Minute), Starred: true},
+		{SenderID: "system", RecipientID: "user1", Title: "Archived", CreateTime: now.Add(-time.Hour), Archived: true},
+		{SenderID: "system", RecipientID: "user1", Title: "Newest gold", Content: "gold gold", CreateTime: now, ExpireTime: now.Add(-time.Minute)},
+	})
+	require.NoError(t, err)
+
+	mail, err := store.GetMail(ctx, ids[1])
+	require.NoError(t, err)
+	assert.True(t, mail.Starred)
+
+	// The recipient listing leaves out archived mails and lists pinned mails first
+	mails, total, err := store.GetMailsByRecipient(ctx, "user1", 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, []string{ids[0], ids[3], ids[1]}, mailIDs(mails))
+
+	// Queries include archived mails unless filtered, with pinned mails first in every order
+	mails, total, err = store.QueryMails(ctx, &MailFilter{RecipientID: "user1", Sort: SortOldest}, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 4, total)
+	assert.Equal(t, []string{ids[0], ids[1], ids[2], ids[3]}, mailIDs(mails))
+
+	archived := false
+	mails, _, err = store.QueryMails(ctx, &MailFilter{RecipientID: "user1", Archived: &archived, Sort: SortExpiringSoonest}, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ids[0], ids[3], ids[1]}, mailIDs(mails))
+
+	// Pinned mails also come first when ranked by relevance
+	mails, _, err = store.QueryMails(ctx, &MailFilter{Text: "gold"}, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ids[0], ids[3]}, mailIDs(mails))
+
+	expr, err := ParseQuery("is:starred OR is:archived")
+	require.NoError(t, err)
+	_, total, err = store.QueryMails(ctx, &MailFilter{Expr: expr}, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+
+	// Starred mails are neither listed as expiring nor deleted
+	expiring, err := store.ListExpiringBetween(ctx, "", now.Add(-time.Hour), now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ids[3]}, mailIDs(expiring))
+
+	count, err := store.DeleteExpiredMails(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	_, err = store.GetMail(ctx, ids[1])
+	assert.NoError(t, err)
+
+	// Flags can be changed in bulk
+	set := true
+	count, err = store.UpdateByFilter(ctx, &MailFilter{IDs: ids[:2]}, &MailPatch{Archived: &set})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	_, total, err = store.GetMailsByRecipient(ctx, "user1", 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 0, total)
+
+	count, err = store.UpdateByFilter(ctx, &MailFilter{IDs: ids[1:2]}, &MailPatch{Starred: &archived})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	count, err = store.DeleteExpiredMails(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+// sqlRecorder is a GORM logger that records every statement
+type sqlRecorder struct {
+	logger.Interface
+	statements []string
+}
+
+func (r *sqlRecorder) LogMode(logger.LogLevel) logger.Interface {
+	return r
+}
+
+func (r *sqlRecorder) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
+
+// dryRunStore creates a GormMailStore for a dialect that records its statements without a database connection
+func dryRunStore(t *testing.T, dialector gorm.Dialector) (*GormMailStore, *sqlRecorder) {
+	recorder := &sqlRecorder{Interface: logger.Discard}
+	db, err := gorm.Open(dialector, &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true, Logger: recorder})
+	require.NoError(t, err)
+	store, err := NewGormMailStore(db, WithAutoMigrate(false))
+	require.NoError(t, err)
+	return store, recorder
+}
+
+// serverDialectors are the dialectors of the database servers the GORM store supports
+var serverDialectors = map[string]func() gorm.Dialector{
+	"postgres": func() gorm.Dialector {
+		return postgres.Open("host=localhost user=inboxer dbname=inboxer")
+	},
+	"mysql": func() gorm.Dialector {
+		return mysql.New(mysql.Config{DSN: "inboxer@tcp(localhost:3306)/inboxer", SkipInitializeWithVersion: true})
+	},
+}
+
+// jsonTableFunctions are the functions expanding JSON arrays into rows on each database server
+var jsonTableFunctions = map[string]string{
+	"postgres": "jsonb_array_elements_text",
+	"mysql":    "JSON_TABLE",
+}
+
+func TestGormMailStore_UpdateByFilterTagPatchDialects(t *testing.T) {
+	ctx := context.Background()
+	for name, dialector := range serverDialectors {
+		t.Run(name, func(t *testing.T) {
+			store, recorder := dryRunStore(t, dialector())
+
+			// Tag patches are a single UPDATE statement
+			_, err := store.UpdateByFilter(ctx, &MailFilter{RecipientID: "user1"}, &MailPatch{
+				AddTags:    []string{"vip"},
+				RemoveTags: []string{"old"},
+			})
+			require.NoError(t, err)
+			require.Len(t, recorder.statements, 1)
+			assert.True(t, strings.HasPrefix(recorder.statements[0], "UPDATE"), recorder.statements[0])
+			assert.Contains(t, recorder.statements[0], jsonTableFunctions[name])
+		})
+	}
+
+	// Other dialects are rejected instead of patched row by row
+	_, err := tagPatchExpr("sqlserver", &MailPatch{AddTags: []string{"vip"}})
+	assert.ErrorContains(t, err, "not supported on the sqlserver dialect")
+}
+
+// renamedDialector reports another dialect name, so SQLite can run the code paths of unknown dialects
+type renamedDialector struct {
+	gorm.Dialector
+	name string
+}
+
+func (d renamedDialector) Name() string {
+	return d.name
+}
+
+func TestGormMailStore_CountByTagDialects(t *testing.T) {
+	ctx := context.Background()
+	for name, dialector := range serverDialectors {
+		t.Run(name, func(t *testing.T) {
+			store, recorder := dryRunStore(t, dialector())
+
+			// Tags are grouped in a single SQL query
+			keySQL, ok := groupKeySQL(name, GroupByTag)
+			require.True(t, ok)
+			var rows []map[string]any
+			err := store.countByQuery(ctx, &MailFilter{RecipientID: "user1"}, GroupByTag, keySQL).Find(&rows).Error
+			require.NoError(t, err)
+			require.Len(t, recorder.statements, 1)
+			assert.Contains(t, recorder.statements[0], jsonTableFunctions[name])
+			assert.Contains(t, recorder.statements[0], "GROUP BY")
+		})
+	}
+}
+
+func TestGormMailStore_CountByScanFallback(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(renamedDialector{Dialector: sqlite.Open(":memory:"), name: "other"}, &gorm.Config{})
+	require.NoError(t, err)
+	store, err := NewGormMailStore(db)
+	require.NoError(t, err)
+
+	day := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	_, err = store.CreateBatchMails(ctx, []*Mail{
+		{SenderID: "system", RecipientID: "user1", CreateTime: day, Tags: []string{"event", "reward"}},
+		{SenderID: "system", RecipientID: "user1", CreateTime: day.Add(24 * time.Hour), Tags: []string{"event"}},
+		{SenderID: "player1", RecipientID: "user2", CreateTime: day, Tags: []string{"event"}},
+	})
+	require.NoError(t, err)
+
+	// Dialects without JSON table or date functions count while scanning the mails
+	filter := &MailFilter{RecipientID: "user1"}
+	groups, err := store.CountBy(ctx, filter, GroupByTag)
+	require.NoError(t, err)
+	assert.Equal(t, []GroupCount{{Key: "event", Count: 2}, {Key: "reward", Count: 1}}, groups)
+
+	groups, err = store.CountBy(ctx, filter, GroupByDay)
+	require.NoError(t, err)
+	assert.Equal(t, []GroupCount{{Key: "2026-01-02", Count: 1}, {Key: "2026-01-03", Count: 1}}, groups)
+}
+
+func TestGormMailStore_TextSearchDialects(t *testing.T) {
+	ctx := context.Background()
+	operators := map[string]string{"postgres": " ~ ", "mysql": " REGEXP "}
+	for name, dialector := range serverDialectors {
+		t.Run(name, func(t *testing.T) {
+			store, recorder := dryRunStore(t, dialector())
+
+			// Words are matched with a regular expression instead of a substring
+			var mails []MailEntity
+			err := store.applyFilter(store.db.WithContext(ctx), &MailFilter{Text: "dragon"}, time.Now()).Find(&mails).Error
+			require.NoError(t, err)
+			require.Len(t, recorder.statements, 1)
+			assert.Contains(t, recorder.statements[0], operators[name])
+			assert.Contains(t, recorder.statements[0], regexpWordPattern("dragon"))
+			assert.NotContains(t, recorder.statements[0], "LIKE")
+		})
+	}
+}
+
+func TestGormMailStore_SearchTableTokenizer(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	// A search table created by an earlier release folds diacritics
+	if err := db.Exec("CREATE VIRTUAL TABLE " + searchTable + " USING fts5(title, content, content='mails', content_rowid='rowid')").Error; err != nil {
+		t.Skip("SQLite was built without FTS5")
+	}
+
+	store, err := NewGormMailStore(db)
+	require.NoError(t, err)
+	require.True(t, store.fullText)
+	_, err = store.CreateMail(ctx, &Mail{RecipientID: "user1", Title: "Café opening"})
+	require.NoError(t, err)
+
+	// It is recreated with the tokenizer of the memory store
+	var tableSQL string
+	require.NoError(t, db.Raw("SELECT sql FROM sqlite_master WHERE name = ?", searchTable).Scan(&tableSQL).Error)
+	assert.Contains(t, tableSQL, searchTokenizer)
+	_, total, err := store.QueryMails(ctx, &MailFilter{Text: "cafe"}, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 0, total)
+	_, total, err = store.QueryMails(ctx, &MailFilter{Text: "café"}, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+}
@@ -0,0 +1,345 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration describes a single versioned schema change
+type Migration struct {
+	Version int                  // Unique, increasing version number
+	Name    string               // Short description of the change
+	Up      func(*gorm.DB) error // Applies the change inside a transaction
+}
+
+// SchemaMigration records an applied migration in the schema_migrations table
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// TableName specifies the table name for the SchemaMigration
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// MigrateOptions controls how migrations are applied
+type MigrateOptions struct {
+	DryRun        bool // Only report pending migrations without applying them
+	TargetVersion int  // Stop after this version (0 means apply all)
+}
+
+// Migrator applies ordered migrations and tracks them in the schema_migrations table
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// NewMigrator creates a migrator for the given migrations, defaulting to MailMigrations
+func NewMigrator(db *gorm.DB, migrations ...Migration) (*Migrator, error) {
+	if db == nil {
+		return nil, errors.New("database connection cannot be nil")
+	}
+
+	if len(migrations) == 0 {
+		migrations = MailMigrations()
+	}
+
+	// Sort by version and reject duplicates
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	for i, migration := range sorted {
+		if migration.Version <= 0 {
+			return nil, fmt.Errorf("migration %q must have a positive version", migration.Name)
+		}
+		if migration.Up == nil {
+			return nil, fmt.Errorf("migration %d has no Up function", migration.Version)
+		}
+		if i > 0 && sorted[i-1].Version == migration.Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migration.Version)
+		}
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: sorted,
+	}, nil
+}
+
+// CurrentVersion returns the highest applied migration version, or 0 if none was applied
+func (m *Migrator) CurrentVersion(ctx context.Context) (int, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	current := 0
+	for version := range applied {
+		if version > current {
+			current = version
+		}
+	}
+
+	return current, nil
+}
+
+// Pending returns the migrations that have not been applied yet, in order
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []Migration{}
+	for _, migration := range m.migrations {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// Migrate applies pending migrations in order and returns the migrations that were (or would be) applied
+func (m *Migrator) Migrate(ctx context.Context, opts MigrateOptions) ([]Migration, error) {
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Restrict to the target version if set
+	if opts.TargetVersion > 0 {
+		limited := []Migration{}
+		for _, migration := range pending {
+			if migration.Version <= opts.TargetVersion {
+				limited = append(limited, migration)
+			}
+		}
+		pending = limited
+	}
+
+	if opts.DryRun {
+		return pending, nil
+	}
+
+	if err := m.db.WithContext(ctx).AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	applied := make([]Migration, 0, len(pending))
+	for _, migration := range pending {
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+
+			record := &SchemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}
+			return tx.Create(record).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("failed to apply migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+
+		applied = append(applied, migration)
+	}
+
+	return applied, nil
+}
+
+// appliedVersions returns the set of applied migration versions
+func (m *Migrator) appliedVersions(ctx context.Context) (map[int]bool, error) {
+	applied := make(map[int]bool)
+
+	db := m.db.WithContext(ctx)
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return applied, nil
+	}
+
+	var records []SchemaMigration
+	if err := db.Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to read schema migrations: %w", err)
+	}
+
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+
+	return applied, nil
+}
+
+// MailMigrations returns the ordered schema migrations for GormMailStore
+func MailMigrations() []Migration {
+	return []Migration{
+		{
+			Version: 1,
+			Name:    "create_mails_table",
+			Up: func(tx *gorm.DB) error {
+				// Databases created by earlier releases through AutoMigrate already have the table
+				if tx.Migrator().HasTable(&mailEntityV1{}) {
+					return nil
+				}
+				return tx.Migrator().CreateTable(&mailEntityV1{})
+			},
+		},
+		{
+			Version: 2,
+			Name:    "add_mails_version",
+			Up: func(tx *gorm.DB) error {
+				return tx.Migrator().AddColumn(&mailEntityV2{}, "Version")
+			},
+		},
+		{
+			Version: 3,
+			Name:    "add_mails_read_time",
+			Up: func(tx *gorm.DB) error {
+				return tx.Migrator().AddColumn(&mailEntityV3{}, "ReadTime")
+			},
+		},
+		{
+			Version: 4,
+			Name:    "add_mails_priority",
+			Up: func(tx *gorm.DB) error {
+				if err := tx.Migrator().AddColumn(&mailEntityV4{}, "Priority"); err != nil {
+					return err
+				}
+				return tx.Migrator().CreateIndex(&mailEntityV4{}, "Priority")
+			},
+		},
+		{
+			Version: 5,
+			Name:    "add_mails_folder",
+			Up: func(tx *gorm.DB) error {
+				if err := tx.Migrator().AddColumn(&mailEntityV5{}, "Folder"); err != nil {
+					return err
+				}
+				return tx.Migrator().CreateIndex(&mailEntityV5{}, "Folder")
+			},
+		},
+		{
+			Version: 6,
+			Name:    "add_mails_flags",
+			Up: func(tx *gorm.DB) error {
+				for _, field := range []string{"Starred", "Pinned", "Archived"} {
+					if err := tx.Migrator().AddColumn(&mailEntityV6{}, field); err != nil {
+						return err
+					}
+				}
+				for _, index := range []string{"Starred", "Archived"} {
+					if err := tx.Migrator().CreateIndex(&mailEntityV6{}, index); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Version: 7,
+			Name:    "create_leases_table",
+			Up: func(tx *gorm.DB) error {
+				// Lease managers of earlier releases created the table through AutoMigrate
+				if tx.Migrator().HasTable(&leaseEntityV7{}) {
+					return nil
+				}
+				return tx.Migrator().CreateTable(&leaseEntityV7{})
+			},
+		},
+	}
+}
+
+// mailEntityV1 is a snapshot of the mails table as created by migration 1
+type mailEntityV1 struct {
+	ID          string `gorm:"primaryKey"`
+	SenderID    string `gorm:"index"`
+	RecipientID string `gorm:"index"`
+	Title       string
+	Content     string    `gorm:"type:text"`
+	Attachments string    `gorm:"type:text"`
+	ReadStatus  bool      `gorm:"index"`
+	CreateTime  time.Time `gorm:"index"`
+	ExpireTime  time.Time `gorm:"index"`
+	Tags        string    `gorm:"type:text"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// TableName specifies the table name for the mailEntityV1 snapshot
+func (mailEntityV1) TableName() string {
+	return "mails"
+}
+
+// mailEntityV2 holds the column added to the mails table by migration 2
+type mailEntityV2 struct {
+	Version int64 `gorm:"not null;default:1"`
+}
+
+// TableName specifies the table name for the mailEntityV2 snapshot
+func (mailEntityV2) TableName() string {
+	return "mails"
+}
+
+// mailEntityV3 holds the column added to the mails table by migration 3
+type mailEntityV3 struct {
+	ReadTime time.Time
+}
+
+// TableName specifies the table name for the mailEntityV3 snapshot
+func (mailEntityV3) TableName() string {
+	return "mails"
+}
+
+// mailEntityV4 holds the column added to the mails table by migration 4
+type mailEntityV4 struct {
+	Priority int `gorm:"not null;default:0;index"`
+}
+
+// TableName specifies the table name for the mailEntityV4 snapshot
+func (mailEntityV4) TableName() string {
+	return "mails"
+}
+
+// mailEntityV5 holds the column added to the mails table by migration 5
+type mailEntityV5 struct {
+	Folder string `gorm:"size:64;not null;default:'inbox';index"`
+}
+
+// TableName specifies the table name for the mailEntityV5 snapshot
+func (mailEntityV5) TableName() string {
+	return "mails"
+}
+
+// mailEntityV6 holds the columns added to the mails table by migration 6
+type mailEntityV6 struct {
+	Starred  bool `gorm:"not null;default:false;index"`
+	Pinned   bool `gorm:"not null;default:false"`
+	Archived bool `gorm:"not null;default:false;index"`
+}
+
+// TableName specifies the table name for the mailEntityV6 snapshot
+func (mailEntityV6) TableName() string {
+	return "mails"
+}
+
+// leaseEntityV7 is the leases table as created by migration 7
+type leaseEntityV7 struct {
+	Key       string    `gorm:"column:lease_key;primaryKey"`
+	Holder    string    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// TableName specifies the table name for the leaseEntityV7 snapshot
+func (leaseEntityV7) TableName() string {
+	return "inboxer_leases"
+}
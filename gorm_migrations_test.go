@@ -0,0 +1,150 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestNewMigrator(t *testing.T) {
+	db := setupTestDB(t)
+	up := func(*gorm.DB) error { return nil }
+
+	// Test with nil DB connection
+	_, err := NewMigrator(nil)
+	assert.Error(t, err)
+
+	// Test with invalid migrations
+	_, err = NewMigrator(db, Migration{Version: 0, Name: "zero", Up: up})
+	assert.Error(t, err)
+	_, err = NewMigrator(db, Migration{Version: 1, Name: "no_up"})
+	assert.Error(t, err)
+	_, err = NewMigrator(db, Migration{Version: 1, Up: up}, Migration{Version: 1, Up: up})
+	assert.Error(t, err)
+
+	// Test with default migrations
+	migrator, err := NewMigrator(db)
+	assert.NoError(t, err)
+	assert.Len(t, migrator.migrations, len(MailMigrations()))
+}
+
+func TestMigrator_Migrate(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	applyOrder := []int{}
+	step := func(version int) Migration {
+		return Migration{
+			Version: version,
+			Name:    "step",
+			Up: func(*gorm.DB) error {
+				applyOrder = append(applyOrder, version)
+				return nil
+			},
+		}
+	}
+
+	// Migrations are applied in version order regardless of declaration order
+	migrator, err := NewMigrator(db, step(3), step(1), step(2))
+	require.NoError(t, err)
+
+	// Dry run reports pending migrations without applying them
+	pending, err := migrator.Migrate(ctx, MigrateOptions{DryRun: true})
+	assert.NoError(t, err)
+	assert.Len(t, pending, 3)
+	assert.Empty(t, applyOrder)
+	assert.False(t, db.Migrator().HasTable(&SchemaMigration{}))
+
+	// Migrate up to a target version
+	applied, err := migrator.Migrate(ctx, MigrateOptions{TargetVersion: 2})
+	assert.NoError(t, err)
+	assert.Len(t, applied, 2)
+	assert.Equal(t, []int{1, 2}, applyOrder)
+
+	version, err := migrator.CurrentVersion(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, version)
+
+	// Migrate the rest
+	applied, err = migrator.Migrate(ctx, MigrateOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, applied, 1)
+	assert.Equal(t, []int{1, 2, 3}, applyOrder)
+
+	// Nothing left to apply
+	pending, err = migrator.Pending(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestMigrator_FailedMigration(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	migrator, err := NewMigrator(db,
+		Migration{Version: 1, Name: "ok", Up: func(*gorm.DB) error { return nil }},
+		Migration{Version: 2, Name: "broken", Up: func(*gorm.DB) error { return errors.New("boom") }},
+	)
+	require.NoError(t, err)
+
+	// The failing migration stops the run and is not recorded
+	applied, err := migrator.Migrate(ctx, MigrateOptions{})
+	assert.Error(t, err)
+	assert.Len(t, applied, 1)
+
+	version, err := migrator.CurrentVersion(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, version)
+}
+
+func TestGormMailStore_Migrations(t *testing.T) {
+	ctx := context.Background()
+
+	// Auto migration applies every mail migration
+	db := setupTestDB(t)
+	_, err := NewGormMailStore(db)
+	require.NoError(t, err)
+
+	migrator, err := NewMigrator(db)
+	require.NoError(t, err)
+	version, err := migrator.CurrentVersion(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, len(MailMigrations()), version)
+
+	// Creating the store again is a no-op
+	_, err = NewGormMailStore(db)
+	assert.NoError(t, err)
+
+	// Without auto migration the schema is left untouched
+	db = setupTestDB(t)
+	_, err = NewGormMailStore(db, WithAutoMigrate(false))
+	require.NoError(t, err)
+	assert.False(t, db.Migrator().HasTable(&MailEntity{}))
+	assert.False(t, db.Migrator().HasTable(&SchemaMigration{}))
+
+	// Databases created by AutoMigrate are adopted by the first migration
+	db = setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&mailEntityV1{}))
+	require.NoError(t, db.Create(&mailEntityV1{ID: "legacy", RecipientID: "user1", Attachments: "{}", Tags: "[]"}).Error)
+	store, err := NewGormMailStore(db)
+	require.NoError(t, err)
+	_, err = store.CreateMail(ctx, createTestMail("system", "user1", "Title", "Content"))
+	assert.NoError(t, err)
+
+	// Existing rows get defaults for the added columns
+	legacy, err := store.GetMail(ctx, "legacy")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), legacy.Version)
+	assert.True(t, legacy.ReadTime.IsZero())
+	assert.Equal(t, 0, legacy.Priority)
+	assert.True(t, db.Migrator().HasIndex(&MailEntity{}, "Priority"))
+	assert.Equal(t, FolderInbox, legacy.Folder)
+	assert.True(t, db.Migrator().HasIndex(&MailEntity{}, "Folder"))
+	assert.False(t, legacy.Starred || legacy.Pinned || legacy.Archived)
+	assert.True(t, db.Migrator().HasIndex(&MailEntity{}, "Starred"))
+	assert.True(t, db.Migrator().HasIndex(&MailEntity{}, "Archived"))
+}
@@ -0,0 +1,179 @@
+package inboxer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+// searchTable is the SQLite FTS5 table indexing mail titles and contents
+const searchTable = "mails_fts"
+
+// searchTokenizer splits text like tokenize: FTS5 folds diacritics by default, the memory index doesn't
+const searchTokenizer = "unicode61 remove_diacritics 0"
+
+// SQLite statements keeping the search table in sync with the mails table
+var searchTableStatements = []string{
+	"CREATE VIRTUAL TABLE IF NOT EXISTS " + searchTable + " USING fts5(title, content, content='mails', content_rowid='rowid', tokenize='" + searchTokenizer + "')",
+	`CREATE TRIGGER IF NOT EXISTS mails_fts_insert AFTER INSERT ON mails BEGIN
+		INSERT INTO ` + searchTable + `(rowid, title, content) VALUES (new.rowid, new.title, new.content);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS mails_fts_delete AFTER DELETE ON mails BEGIN
+		INSERT INTO ` + searchTable + `(` + searchTable + `, rowid, title, content) VALUES ('delete', old.rowid, old.title, old.content);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS mails_fts_update AFTER UPDATE OF title, content ON mails BEGIN
+		INSERT INTO ` + searchTable + `(` + searchTable + `, rowid, title, content) VALUES ('delete', old.rowid, old.title, old.content);
+		INSERT INTO ` + searchTable + `(rowid, title, content) VALUES (new.rowid, new.title, new.content);
+	END`,
+}
+
+// setupFullTextSearch creates the search table when SQLite was built with FTS5 and reports whether it is available.
+// It is not a schema migration, because whether FTS5 exists depends on how the driver was built.
+func setupFullTextSearch(db *gorm.DB) (bool, error) {
+	if db.Dialector.Name() != "sqlite" {
+		return false, nil
+	}
+
+	// Probe for FTS5 with a throwaway table
+	quiet := db.Session(&gorm.Session{Logger: db.Logger.LogMode(logger.Silent)})
+	if err := quiet.Exec("CREATE VIRTUAL TABLE temp.mails_fts_probe USING fts5(x)").Error; err != nil {
+		return false, nil
+	}
+	if err := db.Exec("DROP TABLE temp.mails_fts_probe").Error; err != nil {
+		return false, fmt.Errorf("failed to drop FTS5 probe table: %w", err)
+	}
+
+	var tableSQL string
+	if err := db.Raw("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", searchTable).Scan(&tableSQL).Error; err != nil {
+		return false, fmt.Errorf("failed to read search table: %w", err)
+	}
+	exists := tableSQL != ""
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Search tables created by earlier releases use the default tokenizer and are rebuilt
+		if exists && !strings.Contains(tableSQL, searchTokenizer) {
+			if err := tx.Exec("DROP TABLE " + searchTable).Error; err != nil {
+				return err
+			}
+			exists = false
+		}
+		for _, stmt := range searchTableStatements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		// Index the mails stored before the search table existed
+		if !exists {
+			return tx.Exec("INSERT INTO " + searchTable + "(" + searchTable + ") VALUES ('rebuild')").Error
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to create search table: %w", err)
+	}
+	return true, nil
+}
+
+// RebuildSearchIndex rebuilds the full-text index from the mails table.
+// Run it after a VACUUM, which may renumber the rowids the index refers to.
+func (s *GormMailStore) RebuildSearchIndex(ctx context.Context) error {
+	if !s.fullText {
+		return nil
+	}
+	err := s.db.WithContext(ctx).Exec("INSERT INTO " + searchTable + "(" + searchTable + ") VALUES ('rebuild')").Error
+	if err != nil {
+		return s.dbError(ctx, "rebuild search index", err)
+	}
+	return nil
+}
+
+// applyFilter applies the filter conditions, including the query expression and text query, to a query
+func (s *GormMailStore) applyFilter(tx *gorm.DB, filter *MailFilter, now time.Time) *gorm.DB {
+	tx = applyMailFilter(tx, filter, now)
+	if filter != nil && filter.Expr != nil {
+		tx = tx.Where(s.exprCondition(filter.Expr, now))
+	}
+
+	words := filterWords(filter)
+	if len(words) == 0 {
+		return tx
+	}
+	if s.fullText {
+		return tx.Where("rowid IN (SELECT rowid FROM "+searchTable+" WHERE "+searchTable+" MATCH ?)", ftsQuery(words))
+	}
+
+	// Without FTS5 every word must appear in the title or the content
+	dialect := tx.Dialector.Name()
+	for _, word := range words {
+		titleSQL, pattern := wordMatchSQL(dialect, "title", word)
+		contentSQL, _ := wordMatchSQL(dialect, "content", word)
+		tx = tx.Where("("+titleSQL+" OR "+contentSQL+")", pattern, pattern)
+	}
+	return tx
+}
+
+// orderByRelevance orders a query with pinned mails first, then by how well mails match the text query, best first, then newest first
+func (s *GormMailStore) orderByRelevance(tx *gorm.DB, words []string) *gorm.DB {
+	if s.fullText {
+		// bm25 is lower for better matches, with title matches weighted like the memory store
+		return tx.Order(clause.OrderBy{Expression: clause.Expr{
+			SQL: pinnedFirstSQL + "(SELECT bm25(" + searchTable + ", ?, 1.0) FROM " + searchTable +
+				" WHERE " + searchTable + " MATCH ? AND " + searchTable + ".rowid = mails.rowid), " + newestFirstSQL,
+			Vars: []any{float64(titleWeight), ftsQuery(words)},
+		}})
+	}
+
+	// Without FTS5, rank by the number of words found, counting title matches more
+	dialect := tx.Dialector.Name()
+	terms := make([]string, 0, len(words))
+	vars := make([]any, 0, 2*len(words))
+	for _, word := range words {
+		titleSQL, pattern := wordMatchSQL(dialect, "title", word)
+		contentSQL, _ := wordMatchSQL(dialect, "content", word)
+		terms = append(terms, fmt.Sprintf(
+			"(CASE WHEN %s THEN %d ELSE 0 END + CASE WHEN %s THEN 1 ELSE 0 END)",
+			titleSQL, titleWeight, contentSQL))
+		vars = append(vars, pattern, pattern)
+	}
+	return tx.Order(clause.OrderBy{Expression: clause.Expr{
+		SQL:  pinnedFirstSQL + "(" + strings.Join(terms, " + ") + ") DESC, " + newestFirstSQL,
+		Vars: vars,
+	}})
+}
+
+// ftsQuery builds an FTS5 query matching mails containing every word
+func ftsQuery(words []string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = `"` + word + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// wordMatchSQL returns a condition matching a column that contains word as a whole word, as split by tokenize, and its pattern.
+// Words only contain letters and digits, so they never need escaping. SQLite only lowercases ASCII letters and
+// treats every non-ASCII character as part of a word; dialects without pattern matching fall back to substrings.
+func wordMatchSQL(dialect, column, word string) (string, string) {
+	switch dialect {
+	case "sqlite":
+		return "(' ' || LOWER(" + column + ") || ' ') GLOB ?", "*" + sqliteWordBoundary + word + sqliteWordBoundary + "*"
+	case "postgres":
+		return "LOWER(" + column + ") ~ ?", regexpWordPattern(word)
+	case "mysql":
+		return "LOWER(" + column + ") REGEXP ?", regexpWordPattern(word)
+	default:
+		return "LOWER(" + column + ") LIKE ?", "%" + word + "%"
+	}
+}
+
+// sqliteWordBoundary is a GLOB class matching a character that is neither an ASCII letter or digit nor non-ASCII
+const sqliteWordBoundary = "[^0-9a-z\u0080-\U0010FFFF]"
+
+// regexpWordPattern builds a regular expression matching word between non-alphanumeric characters
+func regexpWordPattern(word string) string {
+	return "(^|[^[:alnum:]])" + word + "([^[:alnum:]]|$)"
+}
@@ -0,0 +1,118 @@
+package inboxer
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// IDGenerator defines the interface for generating unique IDs
+type IDGenerator interface {
+	GenerateID() string
+}
+
+// SimpleIDGenerator is a simple implementation of the ID generator
+type SimpleIDGenerator struct {
+	counter int
+	mu      sync.Mutex
+}
+
+// GenerateID generates a simple unique ID
+func (g *SimpleIDGenerator) GenerateID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.counter++
+	return fmt.Sprintf("mail_%d_%d", time.Now().UnixNano(), g.counter)
+}
+
+// ULIDGenerator generates lexicographically sortable ULIDs.
+// IDs generated within the same millisecond by the same generator are strictly increasing,
+// and the 80 random bits make collisions across processes practically impossible.
+type ULIDGenerator struct {
+	mu      sync.Mutex
+	entropy *ulid.MonotonicEntropy
+}
+
+// NewULIDGenerator creates a new ULID generator
+func NewULIDGenerator() *ULIDGenerator {
+	return &ULIDGenerator{
+		entropy: ulid.Monotonic(rand.Reader, 0),
+	}
+}
+
+// GenerateID generates a new ULID
+func (g *ULIDGenerator) GenerateID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return ulid.MustNew(ulid.Timestamp(time.Now()), g.entropy).String()
+}
+
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	snowflakeMaxNodeID    = 1<<snowflakeNodeBits - 1
+	snowflakeMaxSequence  = 1<<snowflakeSequenceBits - 1
+)
+
+// SnowflakeEpoch is the custom epoch used by SnowflakeGenerator (2024-01-01 00:00:00 UTC)
+var SnowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SnowflakeGenerator generates Snowflake-style IDs composed of a 41-bit millisecond timestamp,
+// a 10-bit node ID and a 12-bit sequence number.
+// Every node sharing a database must be configured with a distinct node ID.
+type SnowflakeGenerator struct {
+	mu       sync.Mutex
+	nodeID   int64
+	lastTime int64
+	sequence int64
+}
+
+// NewSnowflakeGenerator creates a new Snowflake generator for the given node ID (0-1023)
+func NewSnowflakeGenerator(nodeID int64) (*SnowflakeGenerator, error) {
+	if nodeID < 0 || nodeID > snowflakeMaxNodeID {
+		return nil, fmt.Errorf("snowflake node ID must be between 0 and %d", snowflakeMaxNodeID)
+	}
+
+	return &SnowflakeGenerator{
+		nodeID: nodeID,
+	}, nil
+}
+
+// GenerateID generates a new Snowflake ID.
+// The ID is zero-padded to 19 digits so that string ordering matches generation order.
+func (g *SnowflakeGenerator) GenerateID() string {
+	return fmt.Sprintf("%019d", g.NextID())
+}
+
+// NextID generates a new Snowflake ID as an integer
+func (g *SnowflakeGenerator) NextID() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Since(SnowflakeEpoch).Milliseconds()
+
+	// Never move backwards if the wall clock is adjusted
+	if now < g.lastTime {
+		now = g.lastTime
+	}
+
+	if now == g.lastTime {
+		g.sequence = (g.sequence + 1) & snowflakeMaxSequence
+		if g.sequence == 0 {
+			// Sequence exhausted for this millisecond, wait for the next one
+			for now <= g.lastTime {
+				time.Sleep(100 * time.Microsecond)
+				now = time.Since(SnowflakeEpoch).Milliseconds()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+
+	g.lastTime = now
+
+	return now<<(snowflakeNodeBits+snowflakeSequenceBits) | g.nodeID<<snowflakeSequenceBits | g.sequence
+}
@@ -0,0 +1,124 @@
+package inboxer
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// generateConcurrently generates IDs from several goroutines and returns them all
+func generateConcurrently(gen IDGenerator, workers, perWorker int) []string {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	ids := make([]string, 0, workers*perWorker)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make([]string, 0, perWorker)
+			for j := 0; j < perWorker; j++ {
+				local = append(local, gen.GenerateID())
+			}
+			mu.Lock()
+			ids = append(ids, local...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return ids
+}
+
+func TestULIDGenerator(t *testing.T) {
+	gen := NewULIDGenerator()
+
+	// IDs must be unique under concurrent use
+	ids := generateConcurrently(gen, 8, 500)
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		assert.Len(t, id, 26)
+		assert.False(t, seen[id], "duplicate ID %s", id)
+		seen[id] = true
+	}
+
+	// IDs must be sortable by generation order
+	sequential := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		sequential = append(sequential, gen.GenerateID())
+	}
+	assert.True(t, sort.StringsAreSorted(sequential))
+}
+
+func TestSnowflakeGenerator(t *testing.T) {
+	// Test invalid node IDs
+	_, err := NewSnowflakeGenerator(-1)
+	assert.Error(t, err)
+	_, err = NewSnowflakeGenerator(1024)
+	assert.Error(t, err)
+
+	gen, err := NewSnowflakeGenerator(7)
+	require.NoError(t, err)
+
+	// IDs must be unique under concurrent use
+	ids := generateConcurrently(gen, 8, 2000)
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		assert.Len(t, id, 19)
+		assert.False(t, seen[id], "duplicate ID %s", id)
+		seen[id] = true
+	}
+
+	// IDs must be sortable by generation order
+	sequential := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		sequential = append(sequential, gen.GenerateID())
+	}
+	assert.True(t, sort.StringsAreSorted(sequential))
+
+	// The node ID and timestamp must be encoded in the ID
+	before := time.Since(SnowflakeEpoch).Milliseconds()
+	id := gen.NextID()
+	assert.Equal(t, int64(7), (id>>snowflakeSequenceBits)&snowflakeMaxNodeID)
+	assert.GreaterOrEqual(t, id>>(snowflakeNodeBits+snowflakeSequenceBits), before)
+
+	// Different nodes never produce the same ID
+	other, err := NewSnowflakeGenerator(8)
+	require.NoError(t, err)
+	assert.NotEqual(t, gen.GenerateID(), other.GenerateID())
+}
+
+func TestStoresWithIDGenerator(t *testing.T) {
+	ctx := context.Background()
+	gen, err := NewSnowflakeGenerator(1)
+	require.NoError(t, err)
+
+	// Memory store
+	memStore := NewMemoryMailStore(WithIDGenerator(gen))
+	id, err := memStore.CreateMail(ctx, &Mail{RecipientID: "user1"})
+	assert.NoError(t, err)
+	assert.Len(t, id, 19)
+
+	// GORM store
+	gormStore, err := NewGormMailStore(setupTestDB(t), WithIDGenerator(gen))
+	require.NoError(t, err)
+	id, err = gormStore.CreateMail(ctx, &Mail{RecipientID: "user1"})
+	assert.NoError(t, err)
+	assert.Len(t, id, 19)
+
+	ids, err := gormStore.CreateBatchMails(ctx, []*Mail{{RecipientID: "user2"}, {RecipientID: "user3"}})
+	assert.NoError(t, err)
+	assert.Len(t, ids, 2)
+	assert.True(t, sort.StringsAreSorted(ids))
+
+	// GORM store defaults to ULIDs
+	defaultStore := setupGormMailStore(t)
+	id, err = defaultStore.CreateMail(ctx, &Mail{RecipientID: "user1"})
+	assert.NoError(t, err)
+	assert.Len(t, id, 26)
+}
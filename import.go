@@ -0,0 +1,372 @@
+package inboxer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultImportChunkSize is the default number of mails written per chunk when importing
+const DefaultImportChunkSize = 500
+
+// ImportConflictPolicy decides what happens to an imported mail whose ID already exists
+type ImportConflictPolicy string
+
+const (
+	ImportSkip       ImportConflictPolicy = "skip"       // Keep the existing mail
+	ImportOverwrite  ImportConflictPolicy = "overwrite"  // Replace the existing mail
+	ImportRegenerate ImportConflictPolicy = "regenerate" // Create the imported mail with a new ID
+)
+
+// ImportOptions controls an import
+type ImportOptions struct {
+	Format     ExportFormat         // JSON or NDJSON, empty detects the format from the input
+	OnConflict ImportConflictPolicy // Policy for existing IDs, empty means ImportSkip
+	ChunkSize  int                  // Mails written per chunk, 0 uses DefaultImportChunkSize
+	DryRun     bool                 // Validate and report without writing
+}
+
+// ImportReport summarizes an import, or what an import would do in a dry run
+type ImportReport struct {
+	DryRun      bool
+	Read        int           // Records read from the input
+	Created     int           // Mails created, including regenerated ones
+	Overwritten int           // Existing mails replaced
+	Skipped     int           // Mails skipped because their ID already exists
+	Regenerated int           // Mails created with a new ID because theirs already exists
+	Chunks      int           // Chunks written, or that would be written in a dry run
+	Errors      []ImportError // Records rejected as invalid, which are not imported
+}
+
+// ImportError describes an invalid record
+type ImportError struct {
+	Record int    // Record number in the input, starting at 1
+	MailID string // ID of the mail, if it could be read
+	Err    error
+}
+
+// Error implements the error interface
+func (e ImportError) Error() string {
+	if e.MailID != "" {
+		return fmt.Sprintf("record %d (mail %s): %v", e.Record, e.MailID, e.Err)
+	}
+	return fmt.Sprintf("record %d: %v", e.Record, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e ImportError) Unwrap() error {
+	return e.Err
+}
+
+// ImportMails reads mails exported as JSON or NDJSON and writes them to the store in chunks with CreateBatchMails.
+// Invalid records are reported and skipped. On error, the report covers the chunks written so far.
+func ImportMails(ctx context.Context, store MailStore, r io.Reader, opts ImportOptions) (*ImportReport, error) {
+	switch opts.OnConflict {
+	case "":
+		opts.OnConflict = ImportSkip
+	case ImportSkip, ImportOverwrite, ImportRegenerate:
+	default:
+		return nil, fmt.Errorf("unsupported import conflict policy %q", opts.OnConflict)
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultImportChunkSize
+	}
+
+	dec, err := newImportDecoder(r, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	imp := &importer{
+		store:  store,
+		opts:   opts,
+		report: &ImportReport{DryRun: opts.DryRun},
+		seen:   make(map[string]bool),
+	}
+
+	chunk := make([]*Mail, 0, opts.ChunkSize)
+	for record := 1; ; record++ {
+		if err := ctx.Err(); err != nil {
+			return imp.report, err
+		}
+
+		mail, err := dec.next()
+		if err == io.EOF {
+			break
+		}
+		var invalid ImportError
+		if errors.As(err, &invalid) {
+			invalid.Record = record
+			imp.report.Read++
+			imp.report.Errors = append(imp.report.Errors, invalid)
+			continue
+		}
+		if err != nil {
+			return imp.report, fmt.Errorf("failed to read record %d: %w", record, err)
+		}
+		imp.report.Read++
+
+		if err := validateImportMail(mail); err != nil {
+			imp.report.Errors = append(imp.report.Errors, ImportError{Record: record, MailID: mail.ID, Err: err})
+			continue
+		}
+
+		chunk = append(chunk, mail)
+		if len(chunk) == opts.ChunkSize {
+			if err := imp.writeChunk(ctx, chunk); err != nil {
+				return imp.report, err
+			}
+			chunk = chunk[:0]
+		}
+	}
+
+	if len(chunk) > 0 {
+		if err := imp.writeChunk(ctx, chunk); err != nil {
+			return imp.report, err
+		}
+	}
+	return imp.report, nil
+}
+
+// validateImportMail checks that an imported mail can be stored
+func validateImportMail(mail *Mail) error {
+	if mail.RecipientID == "" {
+		return errors.New("recipient ID cannot be empty")
+	}
+	if !mail.CreateTime.IsZero() && !mail.ExpireTime.IsZero() && mail.ExpireTime.Before(mail.CreateTime) {
+		return errors.New("expire time is before create time")
+	}
+	return nil
+}
+
+// importer applies the conflict policy and writes chunks of mails
+type importer struct {
+	store  MailStore
+	opts   ImportOptions
+	report *ImportReport
+	seen   map[string]bool // IDs created by earlier chunks of a dry run
+}
+
+// writeChunk resolves ID conflicts for a chunk and writes it, unless this is a dry run.
+// The report is only updated once the chunk has been written.
+func (imp *importer) writeChunk(ctx context.Context, chunk []*Mail) error {
+	var creates, updates []*Mail
+	pendingCreates := make(map[string]int) // Index in creates of IDs created by this chunk
+	pendingUpdates := make(map[string]int) // Index in updates of IDs overwritten by this chunk
+	var created, overwritten, skipped, regenerated int
+
+	stored, err := imp.storedVersions(ctx, chunk)
+	if err != nil {
+		return fmt.Errorf("failed to check existing mails: %w", err)
+	}
+
+	for _, mail := range chunk {
+		createIdx, inCreates := pendingCreates[mail.ID]
+		updateIdx, inUpdates := pendingUpdates[mail.ID]
+		version, exists := stored[mail.ID]
+		if imp.opts.DryRun && imp.seen[mail.ID] {
+			// IDs created by earlier chunks of a dry run are taken although they were never written
+			exists = true
+		}
+
+		if !exists && !inCreates {
+			if mail.ID != "" {
+				pendingCreates[mail.ID] = len(creates)
+			}
+			creates = append(creates, mail)
+			created++
+			continue
+		}
+
+		switch imp.opts.OnConflict {
+		case ImportSkip:
+			skipped++
+		case ImportRegenerate:
+			mail.ID = ""
+			creates = append(creates, mail)
+			created++
+			regenerated++
+		case ImportOverwrite:
+			// A later record wins over an earlier one in the same chunk, and only the first one is counted
+			switch {
+			case inCreates:
+				creates[createIdx] = mail
+			case inUpdates:
+				mail.Version = version
+				updates[updateIdx] = mail
+			default:
+				mail.Version = version
+				pendingUpdates[mail.ID] = len(updates)
+				updates = append(updates, mail)
+				overwritten++
+			}
+		}
+	}
+
+	if !imp.opts.DryRun {
+		if len(creates) > 0 {
+			if _, err := imp.store.CreateBatchMails(ctx, creates); err != nil {
+				return fmt.Errorf("failed to write chunk %d: %w", imp.report.Chunks+1, err)
+			}
+		}
+		for _, mail := range updates {
+			if err := imp.store.UpdateMail(ctx, mail); err != nil {
+				return fmt.Errorf("failed to write chunk %d: overwrite mail %s: %w", imp.report.Chunks+1, mail.ID, err)
+			}
+		}
+	}
+
+	if imp.opts.DryRun {
+		for id := range pendingCreates {
+			imp.seen[id] = true
+		}
+	}
+	imp.report.Chunks++
+	imp.report.Created += created
+	imp.report.Overwritten += overwritten
+	imp.report.Skipped += skipped
+	imp.report.Regenerated += regenerated
+	return nil
+}
+
+// storedVersions returns the version of every mail of the chunk that is already stored, looked up by ID
+func (imp *importer) storedVersions(ctx context.Context, chunk []*Mail) (map[string]int64, error) {
+	ids := make([]string, 0, len(chunk))
+	for _, mail := range chunk {
+		if mail.ID != "" {
+			ids = append(ids, mail.ID)
+		}
+	}
+
+	versions := make(map[string]int64)
+	// Look IDs up in batches, keeping the number of query parameters bounded
+	for start := 0; start < len(ids); start += DefaultScanBatchSize {
+		filter := &MailFilter{IDs: ids[start:min(start+DefaultScanBatchSize, len(ids))]}
+		err := imp.store.ScanMails(ctx, filter, ScanOptions{}, func(mails []*Mail) error {
+			for _, mail := range mails {
+				versions[mail.ID] = mail.Version
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
+// importDecoder reads mails one record at a time.
+// It returns io.EOF at the end of the input and an ImportError for records that can't be decoded.
+type importDecoder interface {
+	next() (*Mail, error)
+}
+
+// newImportDecoder creates the decoder for the format, detecting it from the first byte if empty
+func newImportDecoder(r io.Reader, format ExportFormat) (importDecoder, error) {
+	buf := bufio.NewReader(r)
+	if format == "" {
+		format = ExportFormatNDJSON
+		for {
+			b, err := buf.ReadByte()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to detect import format: %w", err)
+			}
+			if b == ' ' || b == '\t' || b == '\r' || b == '\n' {
+				continue
+			}
+			if b == '[' {
+				format = ExportFormatJSON
+			}
+			if err := buf.UnreadByte(); err != nil {
+				return nil, fmt.Errorf("failed to detect import format: %w", err)
+			}
+			break
+		}
+	}
+
+	switch format {
+	case ExportFormatJSON:
+		return &jsonImportDecoder{dec: json.NewDecoder(buf)}, nil
+	case ExportFormatNDJSON:
+		return &ndjsonImportDecoder{r: buf}, nil
+	default:
+		return nil, fmt.Errorf("unsupported import format %q", format)
+	}
+}
+
+// jsonImportDecoder reads the elements of a JSON array
+type jsonImportDecoder struct {
+	dec     *json.Decoder
+	started bool
+}
+
+func (d *jsonImportDecoder) next() (*Mail, error) {
+	if !d.started {
+		d.started = true
+		tok, err := d.dec.Token()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			return nil, errors.New("expected a JSON array")
+		}
+	}
+
+	if !d.dec.More() {
+		// Consume the closing bracket so a truncated array is reported
+		if _, err := d.dec.Token(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	// Values of the wrong type are skipped as a whole, syntax errors end the import
+	var mail Mail
+	err := d.dec.Decode(&mail)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return nil, ImportError{MailID: mail.ID, Err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &mail, nil
+}
+
+// ndjsonImportDecoder reads one mail per line, skipping blank lines
+type ndjsonImportDecoder struct {
+	r *bufio.Reader
+}
+
+func (d *ndjsonImportDecoder) next() (*Mail, error) {
+	for {
+		line, err := d.r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			continue
+		}
+
+		// Lines are independent, so a malformed line only invalidates itself
+		var mail Mail
+		if err := json.Unmarshal(line, &mail); err != nil {
+			return nil, ImportError{MailID: mail.ID, Err: err}
+		}
+		return &mail, nil
+	}
+}
@@ -0,0 +1,252 @@
+package inboxer
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exportAll exports every mail in the store in the given format
+func exportAll(t *testing.T, store MailStore, format ExportFormat) *bytes.Buffer {
+	var buf bytes.Buffer
+	_, err := ExportMails(context.Background(), store, &buf, nil, ExportOptions{Format: format})
+	require.NoError(t, err)
+	return &buf
+}
+
+func TestImportMailsRoundTrip(t *testing.T) {
+	for _, format := range []ExportFormat{ExportFormatJSON, ExportFormatNDJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			ctx := context.Background()
+			source := NewMemoryMailStore()
+			ids := createExportMails(t, source, 5)
+			readTime := time.Date(2026, 1, 2, 5, 0, 0, 0, time.UTC)
+			mail, err := source.GetMail(ctx, ids[0])
+			require.NoError(t, err)
+			mail.ReadStatus = true
+			mail.ReadTime = readTime
+			require.NoError(t, source.UpdateMail(ctx, mail))
+
+			// Mails keep their IDs and timestamps, and are written in chunks
+			target := setupGormMailStore(t)
+			report, err := ImportMails(ctx, target, exportAll(t, source, format), ImportOptions{ChunkSize: 2})
+			require.NoError(t, err)
+			assert.Equal(t, 5, report.Read)
+			assert.Equal(t, 5, report.Created)
+			assert.Equal(t, 3, report.Chunks)
+			assert.Empty(t, report.Errors)
+
+			restored, err := target.GetMail(ctx, ids[0])
+			require.NoError(t, err)
+			assert.True(t, restored.ReadStatus)
+			assert.True(t, readTime.Equal(restored.ReadTime))
+			assert.Equal(t, "Line one\nLine two", restored.Content)
+			assert.Equal(t, []string{"event"}, restored.Tags)
+			assert.Equal(t, int64(1), restored.Version)
+		})
+	}
+}
+
+func TestImportMailsConflicts(t *testing.T) {
+	ctx := context.Background()
+	source := NewMemoryMailStore()
+	ids := createExportMails(t, source, 3)
+	export := exportAll(t, source, ExportFormatNDJSON).String()
+
+	// The target already holds a changed copy of the first mail
+	newTarget := func() MailStore {
+		target := NewMemoryMailStore()
+		_, err := target.CreateMail(ctx, &Mail{ID: ids[0], RecipientID: "user1", Title: "Changed"})
+		require.NoError(t, err)
+		return target
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		target := newTarget()
+		report, err := ImportMails(ctx, target, strings.NewReader(export), ImportOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, 2, report.Created)
+		assert.Equal(t, 1, report.Skipped)
+
+		mail, err := target.GetMail(ctx, ids[0])
+		require.NoError(t, err)
+		assert.Equal(t, "Changed", mail.Title)
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		target := newTarget()
+		report, err := ImportMails(ctx, target, strings.NewReader(export), ImportOptions{OnConflict: ImportOverwrite})
+		require.NoError(t, err)
+		assert.Equal(t, 2, report.Created)
+		assert.Equal(t, 1, report.Overwritten)
+
+		mail, err := target.GetMail(ctx, ids[0])
+		require.NoError(t, err)
+		assert.Equal(t, "Title, with \"quotes\"", mail.Title)
+		assert.Equal(t, int64(2), mail.Version)
+	})
+
+	t.Run("regenerate", func(t *testing.T) {
+		target := newTarget()
+		report, err := ImportMails(ctx, target, strings.NewReader(export), ImportOptions{OnConflict: ImportRegenerate})
+		require.NoError(t, err)
+		assert.Equal(t, 3, report.Created)
+		assert.Equal(t, 1, report.Regenerated)
+
+		_, total, err := target.QueryMails(ctx, &MailFilter{RecipientID: "user1"}, 1, 10)
+		require.NoError(t, err)
+		assert.Equal(t, 4, total)
+	})
+
+	t.Run("duplicates in input", func(t *testing.T) {
+		target := NewMemoryMailStore()
+		input := export + `{"ID":"` + ids[1] + `","RecipientID":"user1","Title":"Again"}` + "\n"
+		report, err := ImportMails(ctx, target, strings.NewReader(input), ImportOptions{OnConflict: ImportOverwrite, ChunkSize: 2})
+		require.NoError(t, err)
+		assert.Equal(t, 3, report.Created)
+		assert.Equal(t, 1, report.Overwritten)
+
+		mail, err := target.GetMail(ctx, ids[1])
+		require.NoError(t, err)
+		assert.Equal(t, "Again", mail.Title)
+	})
+
+	t.Run("duplicates in one chunk", func(t *testing.T) {
+		target := newTarget()
+		input := export +
+			`{"ID":"` + ids[0] + `","RecipientID":"user1","Title":"Overwritten again"}` + "\n" +
+			`{"ID":"` + ids[1] + `","RecipientID":"user1","Title":"Created again"}` + "\n"
+		report, err := ImportMails(ctx, target, strings.NewReader(input), ImportOptions{OnConflict: ImportOverwrite})
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Chunks)
+		assert.Equal(t, 2, report.Created)
+		assert.Equal(t, 1, report.Overwritten)
+
+		// Every mail is written once, with the last record
+		_, total, err := target.QueryMails(ctx, &MailFilter{RecipientID: "user1"}, 1, 10)
+		require.NoError(t, err)
+		assert.Equal(t, 3, total)
+		mail, err := target.GetMail(ctx, ids[0])
+		require.NoError(t, err)
+		assert.Equal(t, "Overwritten again", mail.Title)
+		mail, err = target.GetMail(ctx, ids[1])
+		require.NoError(t, err)
+		assert.Equal(t, "Created again", mail.Title)
+	})
+}
+
+func TestImportMailsDryRun(t *testing.T) {
+	ctx := context.Background()
+	input := strings.Join([]string{
+		`{"ID":"a","RecipientID":"user1","Title":"Valid"}`,
+		`{"ID":"b","Title":"No recipient"}`,
+		`not json`,
+		``,
+		`{"ID":"a","RecipientID":"user1","Title":"Duplicate"}`,
+		`{"ID":"c","RecipientID":"user1","CreateTime":"2026-01-02T00:00:00Z","ExpireTime":"2026-01-01T00:00:00Z"}`,
+		`{"ID":"d","RecipientID":"user1","Tags":"not a list"}`,
+	}, "\n")
+
+	// Nothing is written, invalid records are reported with their position
+	store := NewMemoryMailStore()
+	report, err := ImportMails(ctx, store, strings.NewReader(input), ImportOptions{DryRun: true, ChunkSize: 1})
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 6, report.Read)
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 1, report.Skipped)
+	require.Len(t, report.Errors, 4)
+	assert.Equal(t, 2, report.Errors[0].Record)
+	assert.Equal(t, "b", report.Errors[0].MailID)
+	assert.ErrorContains(t, report.Errors[0], "recipient ID cannot be empty")
+	assert.Equal(t, 3, report.Errors[1].Record)
+	assert.ErrorContains(t, report.Errors[2], "expire time is before create time")
+	assert.Equal(t, 6, report.Errors[3].Record)
+
+	_, total, err := store.QueryMails(ctx, nil, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 0, total)
+}
+
+func TestImportMailsErrors(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryMailStore()
+
+	_, err := ImportMails(ctx, store, strings.NewReader("[]"), ImportOptions{OnConflict: "merge"})
+	assert.ErrorContains(t, err, "unsupported import conflict policy")
+
+	_, err = ImportMails(ctx, store, strings.NewReader("[]"), ImportOptions{Format: ExportFormatCSV})
+	assert.ErrorContains(t, err, "unsupported import format")
+
+	// Empty inputs import nothing
+	report, err := ImportMails(ctx, store, strings.NewReader("  \n"), ImportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Read)
+
+	// Broken JSON arrays stop the import after the chunks written so far
+	input := `[{"ID":"a","RecipientID":"user1"}, {"ID":"b", "RecipientID"`
+	report, err = ImportMails(ctx, store, strings.NewReader(input), ImportOptions{ChunkSize: 1})
+	assert.ErrorContains(t, err, "failed to read record 2")
+	assert.Equal(t, 1, report.Created)
+
+	_, err = ImportMails(ctx, store, strings.NewReader(`{"ID":"a"}`), ImportOptions{Format: ExportFormatJSON})
+	assert.ErrorContains(t, err, "expected a JSON array")
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = ImportMails(cancelCtx, store, strings.NewReader("[]"), ImportOptions{})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestManagerImportMails(t *testing.T) {
+	ctx := context.Background()
+	source := NewMemoryMailStore()
+	createExportMails(t, source, 2)
+
+	manager := NewDefaultMailManager(NewMemoryMailStore())
+	report, err := manager.ImportMails(ctx, exportAll(t, source, ExportFormatJSON), ImportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Created)
+
+	count, err := manager.CountMailsWithAttachments(ctx, "user1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	_, err = manager.ImportMails(ctx, nil, ImportOptions{})
+	assert.Error(t, err)
+}
+
+// getMailCountingStore counts GetMail calls
+type getMailCountingStore struct {
+	MailStore
+	calls int
+}
+
+func (s *getMailCountingStore) GetMail(ctx context.Context, mailID string) (*Mail, error) {
+	s.calls++
+	return s.MailStore.GetMail(ctx, mailID)
+}
+
+func TestImportMailsLooksUpChunksInBatches(t *testing.T) {
+	ctx := context.Background()
+	source := NewMemoryMailStore()
+	mails := make([]*Mail, DefaultScanBatchSize+10)
+	for i := range mails {
+		mails[i] = &Mail{RecipientID: "user1", Title: "Mail", CreateTime: time.Now()}
+	}
+	_, err := source.CreateBatchMails(ctx, mails)
+	require.NoError(t, err)
+
+	// A chunk larger than a lookup batch finds every existing mail without reading mails one by one
+	target := &getMailCountingStore{MailStore: source}
+	report, err := ImportMails(ctx, target, exportAll(t, source, ExportFormatNDJSON), ImportOptions{ChunkSize: len(mails)})
+	require.NoError(t, err)
+	assert.Equal(t, len(mails), report.Skipped)
+	assert.Zero(t, report.Created)
+	assert.Zero(t, target.calls)
+}
@@ -2,6 +2,7 @@ package inboxer
 
 import (
 	"context"
+	"io"
 	"time"
 )
 
@@ -14,20 +15,61 @@ type Mail struct {
 	Content     string                 // Mail content
 	Attachments map[string]interface{} // Attachments (items, coins, etc.)
 	ReadStatus  bool                   // Read status
+	ReadTime    time.Time              // Time the mail was first read, zero if unread
 	CreateTime  time.Time              // Creation time
 	ExpireTime  time.Time              // Expiration time
 	Tags        []string               // Tags (can be used for mail categorization)
+	Priority    int                    // Priority, higher values are more important
+	Folder      string                 // Folder, such as FolderRewards, stores put mails without one in FolderInbox
+	Starred     bool                   // Starred mails are never deleted when they expire
+	Pinned      bool                   // Pinned mails are listed before the others
+	Archived    bool                   // Archived mails are left out of the default listings
+	Version     int64                  // Version for optimistic concurrency control, managed by the store
 }
 
 // MailFilter defines conditions for filtering mails
 type MailFilter struct {
-	SenderID    string     // Filter by sender
-	RecipientID string     // Filter by recipient
-	ReadStatus  *bool      // Filter by read status
-	StartTime   *time.Time // Filter by creation time (start)
-	EndTime     *time.Time // Filter by creation time (end)
-	ExpiredOnly bool       // Query only expired mails
-	Tags        []string   // Filter by tags
+	SenderID       string     // Filter by sender
+	RecipientID    string     // Filter by recipient
+	ReadStatus     *bool      // Filter by read status
+	StartTime      *time.Time // Filter by creation time (start)
+	EndTime        *time.Time // Filter by creation time (end)
+	ExpiredOnly    bool       // Query only expired mails
+	Tags           []string   // Filter by any of these tags, matched exactly; GormMailStore used to require all of them and match substrings
+	Text           string     // Full-text query over title and content, results are ranked by relevance
+	ExpireStart    *time.Time // Filter by expiration time (start), mails that never expire are excluded
+	ExpireEnd      *time.Time // Filter by expiration time (end), mails that never expire are excluded
+	HasAttachments *bool      // Filter by whether the mail has attachments
+	AttachmentKeys []string   // Filter by mails having all of these attachment keys
+	SenderIDs      []string   // Filter by any of these senders
+	RecipientIDs   []string   // Filter by any of these recipients
+	IDs            []string   // Filter by any of these mail IDs
+	TitlePrefix    string     // Filter by titles starting with this prefix, case-sensitive
+	Expr           *Expr      // Boolean query expression, combined with the other fields by AND
+	Sort           SortOrder  // Order of QueryMails results, text queries are ranked by relevance unless set
+	Folder         string     // Filter by folder
+	Starred        *bool      // Filter by starred state
+	Pinned         *bool      // Filter by pinned state
+	Archived       *bool      // Filter by archived state, archived mails are included unless set to false
+}
+
+// MailPatch describes the changes applied to every mail matched by a bulk update
+type MailPatch struct {
+	ReadStatus *bool      // Set read status
+	ReadTime   *time.Time // Set read time
+	ExpireTime *time.Time // Set expiration time
+	AddTags    []string   // Tags to add if not already present
+	RemoveTags []string   // Tags to remove
+	Folder     *string    // Move to folder
+	Starred    *bool      // Set starred state
+	Pinned     *bool      // Set pinned state
+	Archived   *bool      // Set archived state
+}
+
+// isEmpty reports whether the patch contains no changes
+func (p *MailPatch) isEmpty() bool {
+	return p == nil || (p.ReadStatus == nil && p.ReadTime == nil && p.ExpireTime == nil && len(p.AddTags) == 0 && len(p.RemoveTags) == 0 &&
+		p.Folder == nil && p.Starred == nil && p.Pinned == nil && p.Archived == nil)
 }
 
 // MailManager defines the interface for managing game system mails
@@ -38,13 +80,20 @@ type MailManager interface {
 	SendSystemAnnouncement(ctx context.Context, mail *Mail) (string, error)                 // Send system announcement (to all players)
 
 	// Mail query operations
-	GetMailByID(ctx context.Context, mailID string) (*Mail, error)                                     // Get mail by ID
-	GetMailsByRecipient(ctx context.Context, recipientID string, page, size int) ([]*Mail, int, error) // Get user's mails with pagination
-	QueryMails(ctx context.Context, filter *MailFilter, page, size int) ([]*Mail, int, error)          // Query mails by conditions
+	GetMailByID(ctx context.Context, mailID string) (*Mail, error)                                                         // Get mail by ID
+	GetMailsByRecipient(ctx context.Context, recipientID string, page, size int, order ...SortOrder) ([]*Mail, int, error) // Get user's mails with pagination, pinned mails first, then in the optional order (SortNewest by default)
+	QueryMails(ctx context.Context, filter *MailFilter, page, size int) ([]*Mail, int, error)                              // Query mails by conditions
+	ListExpiringSoon(ctx context.Context, recipientID string, within time.Duration) ([]*Mail, error)                       // Get user's mails expiring within the duration, soonest first
+	GetMailsByFolder(ctx context.Context, recipientID, folder string, page, size int) ([]*Mail, int, error)                // Get user's mails in a folder that are not archived with pagination, pinned then newest first
+	GetArchivedMails(ctx context.Context, recipientID string, page, size int) ([]*Mail, int, error)                        // Get user's archived mails with pagination
 
 	// Mail action operations
-	MarkAsRead(ctx context.Context, mailID string) error         // Mark mail as read
-	MarkAllAsRead(ctx context.Context, recipientID string) error // Mark all user's mails as read
+	MarkAsRead(ctx context.Context, mailID string) error                            // Mark mail as read
+	MarkAllAsRead(ctx context.Context, recipientID string) error                    // Mark all user's mails as read
+	MoveToFolder(ctx context.Context, mailIDs []string, folder string) (int, error) // Move mails to a folder, returns the moved count
+	SetStarred(ctx context.Context, mailID string, starred bool) error              // Star or unstar a mail, starred mails never expire
+	SetPinned(ctx context.Context, mailID string, pinned bool) error                // Pin or unpin a mail, pinned mails are listed first
+	SetArchived(ctx context.Context, mailID string, archived bool) error            // Archive or unarchive a mail, archived mails are left out of the default listings
 
 	// Mail management operations
 	DeleteMail(ctx context.Context, mailID string) error                  // Delete mail
@@ -52,10 +101,19 @@ type MailManager interface {
 	DeleteExpiredMails(ctx context.Context) (int, error)                  // Delete all expired mails, returns deletion count
 
 	// Mail statistics operations
-	CountUnreadMails(ctx context.Context, recipientID string) (int, error)          // Get unread mail count
-	CountMailsWithAttachments(ctx context.Context, recipientID string) (int, error) // Get count of mails with attachments
+	CountUnreadMails(ctx context.Context, recipientID string) (int, error)                  // Get unread mail count, archived mails excluded
+	CountMailsWithAttachments(ctx context.Context, recipientID string) (int, error)         // Get count of mails with attachments
+	CountBy(ctx context.Context, filter *MailFilter, groupBy GroupBy) ([]GroupCount, error) // Count matching mails per tag, sender, read status, day or folder
+	CountUnreadByFolder(ctx context.Context, recipientID string) (map[string]int, error)    // Get unread mail counts of every folder with unread mails, archived mails excluded
 
 	// System operations
-	ScheduleCleanup(ctx context.Context, duration time.Duration) error      // Set interval for automatic expired mail cleanup
-	ExportMailLogs(ctx context.Context, filter *MailFilter) (string, error) // Export mail logs
+	ScheduleCleanup(ctx context.Context, duration time.Duration) error                                 // Set interval for automatic expired mail cleanup
+	ScheduleExpiryNotifications(ctx context.Context, interval, within time.Duration) error             // Periodically emit one "expiring soon" event per mail
+	ExportMailLogs(ctx context.Context, filter *MailFilter) (string, error)                            // Export mail logs
+	ExportMails(ctx context.Context, w io.Writer, filter *MailFilter, opts ExportOptions) (int, error) // Stream matching mails to w, returns the exported count
+	ImportMails(ctx context.Context, r io.Reader, opts ImportOptions) (*ImportReport, error)           // Restore mails exported as JSON or NDJSON
+
+	// Lifecycle operations
+	Start(ctx context.Context) error // Start configured background workers
+	Close(ctx context.Context) error // Stop all background workers and wait for in-flight runs
 }
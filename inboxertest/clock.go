@@ -0,0 +1,126 @@
+// Package inboxertest provides helpers for testing code that uses inboxer.
+package inboxertest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// FakeClock is a manually controlled clock that implements inboxer.Clock.
+// Time only moves when Advance or Set is called, firing every timer whose deadline has passed.
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*waiter
+}
+
+// waiter is a pending timer
+type waiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// NewFakeClock creates a fake clock set to the given time
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the current fake time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer creates a timer that fires once the clock has been advanced by d.
+// The returned function removes the timer from the clock, reporting whether it was still pending.
+func (c *FakeClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := &waiter{deadline: c.now.Add(d), ch: make(chan time.Time, 1)}
+	stop := func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.removeLocked(w)
+	}
+	if d <= 0 {
+		w.ch <- c.now
+		return w.ch, stop
+	}
+
+	c.waiters = append(c.waiters, w)
+	c.cond.Broadcast()
+	return w.ch, stop
+}
+
+// After returns a channel that receives the fake time once the clock has been advanced by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	ch, _ := c.NewTimer(d)
+	return ch
+}
+
+// Advance moves the clock forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(c.now.Add(d))
+}
+
+// Set moves the clock to t, which may be in the past
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(t)
+}
+
+// BlockUntil blocks until at least n timers are pending.
+// It lets tests advance the clock only once background workers are ready for it.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// Waiters returns the number of pending timers; stopped timers are not counted
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// setLocked sets the time and fires due timers in deadline order; the caller must hold c.mu
+func (c *FakeClock) setLocked(t time.Time) {
+	c.now = t
+
+	sort.SliceStable(c.waiters, func(i, j int) bool {
+		return c.waiters[i].deadline.Before(c.waiters[j].deadline)
+	})
+
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.deadline.After(t) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- t
+	}
+	c.waiters = pending
+}
+
+// removeLocked removes a pending timer, reporting whether it was pending; the caller must hold c.mu
+func (c *FakeClock) removeLocked(w *waiter) bool {
+	for i, pending := range c.waiters {
+		if pending == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package inboxertest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	assert.Equal(t, start, clock.Now())
+
+	// Timers fire once the clock passes their deadline
+	minute := clock.After(time.Minute)
+	hour := clock.After(time.Hour)
+	assert.Equal(t, 2, clock.Waiters())
+
+	clock.Advance(30 * time.Second)
+	assert.Len(t, minute, 0)
+
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, start.Add(time.Minute), <-minute)
+	assert.Len(t, hour, 0)
+	assert.Equal(t, 1, clock.Waiters())
+
+	// Setting the time fires due timers too
+	clock.Set(start.Add(2 * time.Hour))
+	assert.Equal(t, start.Add(2*time.Hour), <-hour)
+	assert.Equal(t, 0, clock.Waiters())
+
+	// Non-positive durations fire immediately
+	assert.Equal(t, start.Add(2*time.Hour), <-clock.After(0))
+}
+
+func TestFakeClockBlockUntil(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+
+	fired := make(chan struct{})
+	go func() {
+		<-clock.After(time.Second)
+		close(fired)
+	}()
+
+	// Advancing only after the goroutine waits guarantees the timer fires
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	<-fired
+}
+
+func TestFakeClockStopTimer(t *testing.T) {
+	start := time.Now()
+	clock := NewFakeClock(start)
+
+	// Stopped timers no longer count as waiters and never fire
+	minute, stop := clock.NewTimer(time.Minute)
+	assert.Equal(t, 1, clock.Waiters())
+	assert.True(t, stop())
+	assert.False(t, stop())
+	assert.Equal(t, 0, clock.Waiters())
+
+	clock.Advance(time.Minute)
+	assert.Len(t, minute, 0)
+
+	// Fired timers cannot be stopped
+	hour, stop := clock.NewTimer(time.Hour)
+	clock.Advance(time.Hour)
+	assert.Equal(t, start.Add(time.Minute+time.Hour), <-hour)
+	assert.False(t, stop())
+}
@@ -0,0 +1,132 @@
+// Package dsn opens GORM mail stores from the store strings accepted by the inboxer commands.
+package dsn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/weedbox/inboxer"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Dialector returns the GORM dialector for a store string.
+// Stores are given as driver:dsn with the sqlite, postgres or mysql driver.
+// Plain file paths, file: URIs and :memory: are opened with SQLite.
+func Dialector(target string) (gorm.Dialector, error) {
+	driver, dsn, ok := strings.Cut(target, ":")
+	if !ok || driver == "file" || target == ":memory:" {
+		return sqlite.Open(target), nil
+	}
+	if dsn == "" {
+		return nil, fmt.Errorf("invalid store %q, expected driver:dsn", target)
+	}
+
+	switch driver {
+	case "sqlite":
+		return sqlite.Open(dsn), nil
+	case "postgres", "postgresql":
+		// URL style DSNs keep their scheme
+		if strings.HasPrefix(dsn, "//") {
+			dsn = target
+		}
+		return postgres.Open(dsn), nil
+	case "mysql":
+		return mysql.Open(dsn), nil
+	default:
+		return nil, fmt.Errorf("unsupported driver %q", driver)
+	}
+}
+
+// ErrPendingMigrations is returned by OpenStore for databases whose schema is not up to date
+var ErrPendingMigrations = errors.New("database schema has pending migrations")
+
+// Open opens the database of a store string.
+// A SQLite database file must already exist unless create is set, so a mistyped path is not silently created.
+func Open(target string, create bool) (*gorm.DB, error) {
+	dialector, err := Dialector(target)
+	if err != nil {
+		return nil, err
+	}
+
+	if path, ok := sqliteFile(target); ok && !create {
+		if _, err := os.Stat(path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("database file %s does not exist", path)
+			}
+			return nil, err
+		}
+	}
+
+	return gorm.Open(dialector, &gorm.Config{Logger: logger.Default.LogMode(logger.Error)})
+}
+
+// OpenStore opens a GORM mail store on an existing database without changing its schema.
+// Databases with pending migrations are rejected with ErrPendingMigrations; apply them with Migrate.
+func OpenStore(target string, opts ...inboxer.StoreOption) (*inboxer.GormMailStore, error) {
+	db, err := Open(target, false)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := inboxer.NewMigrator(db)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := migrator.Pending(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to check schema version: %w", err)
+	}
+	if len(pending) > 0 {
+		return nil, fmt.Errorf("%w: %d not applied", ErrPendingMigrations, len(pending))
+	}
+
+	return inboxer.NewGormMailStore(db, append([]inboxer.StoreOption{inboxer.WithAutoMigrate(false)}, opts...)...)
+}
+
+// Migrate applies the pending schema migrations of a database, creating a missing SQLite file,
+// and returns the migrations that were applied. A dry run only returns the pending migrations.
+func Migrate(ctx context.Context, target string, dryRun bool) ([]inboxer.Migration, error) {
+	db, err := Open(target, !dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := inboxer.NewMigrator(db)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := migrator.Migrate(ctx, inboxer.MigrateOptions{DryRun: dryRun})
+	if err != nil || dryRun {
+		return applied, err
+	}
+
+	// Opening a migrating store completes the setup that is not versioned, such as the SQLite search index
+	if _, err := inboxer.NewGormMailStore(db); err != nil {
+		return applied, err
+	}
+	return applied, nil
+}
+
+// sqliteFile returns the file path of a SQLite store string, or false for other drivers and in-memory databases
+func sqliteFile(target string) (string, bool) {
+	path := target
+	if driver, dsn, ok := strings.Cut(target, ":"); ok && driver == "sqlite" {
+		path = dsn
+	} else if ok && driver != "file" && target != ":memory:" {
+		return "", false
+	}
+
+	path, query, _ := strings.Cut(path, "?")
+	path = strings.TrimPrefix(path, "file:")
+	if path == "" || path == ":memory:" || strings.Contains(query, "mode=memory") {
+		return "", false
+	}
+	return path, true
+}
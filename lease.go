@@ -0,0 +1,96 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// LeaseManager grants time-limited exclusive leases on named keys.
+// It is used to elect a single instance to run periodic jobs when several servers share a store.
+type LeaseManager interface {
+	// TryAcquire acquires the lease on key for holder if it is free or expired.
+	// Acquiring a lease that holder already owns renews it for another ttl.
+	TryAcquire(ctx context.Context, key, holder string, ttl time.Duration) (bool, error)
+
+	// Release gives up the lease on key if it is owned by holder
+	Release(ctx context.Context, key, holder string) error
+}
+
+// lease is a lease granted by MemoryLeaseManager
+type lease struct {
+	holder    string
+	expiresAt time.Time
+}
+
+// MemoryLeaseManager implements LeaseManager in memory for managers sharing a process
+type MemoryLeaseManager struct {
+	leases map[string]lease
+	mu     sync.Mutex
+	clock  Clock
+}
+
+// NewMemoryLeaseManager creates a new in-memory lease manager; only WithStoreClock applies to it
+func NewMemoryLeaseManager(opts ...StoreOption) *MemoryLeaseManager {
+	options := newStoreOptions(storeOptions{
+		clock: SystemClock{},
+	}, opts...)
+
+	return &MemoryLeaseManager{
+		leases: make(map[string]lease),
+		clock:  options.clock,
+	}
+}
+
+// TryAcquire acquires or renews the lease on key for holder
+func (l *MemoryLeaseManager) TryAcquire(ctx context.Context, key, holder string, ttl time.Duration) (bool, error) {
+	if err := validateLease(key, holder); err != nil {
+		return false, err
+	}
+	if ttl <= 0 {
+		return false, errors.New("lease ttl must be positive")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if current, ok := l.leases[key]; ok && current.holder != holder && now.Before(current.expiresAt) {
+		return false, nil
+	}
+
+	l.leases[key] = lease{
+		holder:    holder,
+		expiresAt: now.Add(ttl),
+	}
+
+	return true, nil
+}
+
+// Release gives up the lease on key if it is owned by holder
+func (l *MemoryLeaseManager) Release(ctx context.Context, key, holder string) error {
+	if err := validateLease(key, holder); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if current, ok := l.leases[key]; ok && current.holder == holder {
+		delete(l.leases, key)
+	}
+
+	return nil
+}
+
+// validateLease checks the key and holder of a lease request
+func validateLease(key, holder string) error {
+	if key == "" {
+		return errors.New("lease key cannot be empty")
+	}
+	if holder == "" {
+		return errors.New("lease holder cannot be empty")
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package inboxer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weedbox/inboxer/inboxertest"
+)
+
+// leaseManagers returns every lease manager implementation under test, using the given clock
+func leaseManagers(t *testing.T, clock Clock) map[string]LeaseManager {
+	gormLeases, err := NewGormLeaseManager(setupTestDB(t), WithStoreClock(clock))
+	require.NoError(t, err)
+
+	return map[string]LeaseManager{
+		"memory": NewMemoryLeaseManager(WithStoreClock(clock)),
+		"gorm":   gormLeases,
+	}
+}
+
+func TestLeaseManager(t *testing.T) {
+	ctx := context.Background()
+
+	for name, leases := range leaseManagers(t, SystemClock{}) {
+		t.Run(name, func(t *testing.T) {
+			// Test invalid requests
+			_, err := leases.TryAcquire(ctx, "", "a", time.Second)
+			assert.Error(t, err)
+			_, err = leases.TryAcquire(ctx, "job:cleanup", "", time.Second)
+			assert.Error(t, err)
+			_, err = leases.TryAcquire(ctx, "job:cleanup", "a", 0)
+			assert.Error(t, err)
+
+			// The first holder gets the lease
+			acquired, err := leases.TryAcquire(ctx, "job:cleanup", "a", time.Hour)
+			require.NoError(t, err)
+			assert.True(t, acquired)
+
+			// Other holders are rejected while it is held
+			acquired, err = leases.TryAcquire(ctx, "job:cleanup", "b", time.Hour)
+			require.NoError(t, err)
+			assert.False(t, acquired)
+
+			// Leases on other keys are independent
+			acquired, err = leases.TryAcquire(ctx, "job:other", "b", time.Hour)
+			require.NoError(t, err)
+			assert.True(t, acquired)
+
+			// Releasing a lease held by someone else has no effect
+			require.NoError(t, leases.Release(ctx, "job:cleanup", "b"))
+			acquired, err = leases.TryAcquire(ctx, "job:cleanup", "b", time.Hour)
+			require.NoError(t, err)
+			assert.False(t, acquired)
+
+			// Once released, another holder can take over
+			require.NoError(t, leases.Release(ctx, "job:cleanup", "a"))
+			acquired, err = leases.TryAcquire(ctx, "job:cleanup", "b", time.Hour)
+			require.NoError(t, err)
+			assert.True(t, acquired)
+		})
+	}
+}
+
+func TestLeaseManagerRenewal(t *testing.T) {
+	ctx := context.Background()
+
+	clock := inboxertest.NewFakeClock(time.Now())
+
+	for name, leases := range leaseManagers(t, clock) {
+		t.Run(name, func(t *testing.T) {
+			ttl := time.Minute
+
+			acquired, err := leases.TryAcquire(ctx, "job:cleanup", "a", ttl)
+			require.NoError(t, err)
+			require.True(t, acquired)
+
+			// Renewing before the lease expires keeps it past the original ttl
+			for i := 0; i < 3; i++ {
+				clock.Advance(ttl / 2)
+				acquired, err = leases.TryAcquire(ctx, "job:cleanup", "a", ttl)
+				require.NoError(t, err)
+				assert.True(t, acquired)
+
+				acquired, err = leases.TryAcquire(ctx, "job:cleanup", "b", ttl)
+				require.NoError(t, err)
+				assert.False(t, acquired)
+			}
+
+			// Without renewal the lease expires and fails over
+			clock.Advance(ttl + time.Second)
+			acquired, err = leases.TryAcquire(ctx, "job:cleanup", "b", ttl)
+			require.NoError(t, err)
+			assert.True(t, acquired)
+
+			acquired, err = leases.TryAcquire(ctx, "job:cleanup", "a", ttl)
+			require.NoError(t, err)
+			assert.False(t, acquired)
+		})
+	}
+}
+
+func TestGormLeaseManagerMigrations(t *testing.T) {
+	ctx := context.Background()
+
+	// The leases table is created by a versioned migration
+	db := setupTestDB(t)
+	_, err := NewGormLeaseManager(db)
+	require.NoError(t, err)
+	assert.True(t, db.Migrator().HasTable(&LeaseEntity{}))
+	migrator, err := NewMigrator(db)
+	require.NoError(t, err)
+	version, err := migrator.CurrentVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 7, version)
+
+	// Without auto migration the schema is left untouched
+	db = setupTestDB(t)
+	_, err = NewGormLeaseManager(db, WithAutoMigrate(false))
+	require.NoError(t, err)
+	assert.False(t, db.Migrator().HasTable(&LeaseEntity{}))
+	assert.False(t, db.Migrator().HasTable(&SchemaMigration{}))
+
+	// Tables created by AutoMigrate in earlier releases are adopted with their leases
+	db = setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&LeaseEntity{}))
+	require.NoError(t, db.Create(&LeaseEntity{Key: "job:cleanup", Holder: "old", ExpiresAt: time.Now().Add(time.Hour)}).Error)
+	leases, err := NewGormLeaseManager(db)
+	require.NoError(t, err)
+	acquired, err := leases.TryAcquire(ctx, "job:cleanup", "new", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+}
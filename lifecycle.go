@@ -0,0 +1,299 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrManagerClosed is returned when starting or scheduling work on a closed manager
+var ErrManagerClosed = errors.New("mail manager is closed")
+
+// ErrJobLeaseLost is returned by a job run that stopped because another instance took over its lease
+var ErrJobLeaseLost = errors.New("job lease lost")
+
+// cleanupJobName is the name of the expired mail cleanup job
+const cleanupJobName = "cleanup"
+
+// jobLeasePrefix prefixes the lease key of every periodic job
+const jobLeasePrefix = "job:"
+
+// leaseReleaseTimeout bounds releasing job leases on Close, which runs even if the close deadline has passed
+const leaseReleaseTimeout = 5 * time.Second
+
+// JobHooks observe the periodic jobs of a manager, e.g. to export their state as metrics.
+// Hooks are called synchronously and must not call back into the manager.
+type JobHooks struct {
+	OnStart   func(job string, interval time.Duration)          // Called when a job is scheduled, by Start or a Schedule method
+	OnStop    func(job string)                                  // Called once a job has stopped, when it is replaced or the manager is closed
+	OnCleanup func(ctx context.Context, deleted int, err error) // Called after every expired mail cleanup, scheduled or not
+}
+
+// jobLeaseKey is the context key of the job lease held by a run
+type jobLeaseKey struct{}
+
+// jobLease is the lease a job run holds, renewed before every batch of work
+type jobLease struct {
+	job    *backgroundJob
+	cancel context.CancelCauseFunc // Stops the run once the lease is lost
+}
+
+// backgroundJob is a periodic worker owned by the manager
+type backgroundJob struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
+	stop     chan struct{} // Closed to stop scheduling new runs
+	done     chan struct{} // Closed once the worker goroutine has exited
+}
+
+// Start starts the background workers configured through options, such as WithCleanupInterval.
+// Workers scheduled later, e.g. with ScheduleCleanup, start immediately.
+func (m *DefaultMailManager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrManagerClosed
+	}
+	if m.started {
+		return nil
+	}
+	m.started = true
+
+	if m.cleanupInterval > 0 && m.jobs[cleanupJobName] == nil {
+		m.startJobLocked(cleanupJobName, m.cleanupInterval, m.runCleanup)
+	}
+
+	m.logger.InfoContext(ctx, "mail manager started", "jobs", len(m.jobs))
+
+	return nil
+}
+
+// Close stops every background worker and waits for in-flight runs to finish.
+// If ctx is done before the workers exit, in-flight runs are cancelled and the context error is returned.
+// Errors returned by runs that were in flight during shutdown are reported as well.
+func (m *DefaultMailManager) Close(ctx context.Context) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	m.closing.Store(true)
+
+	jobs := make([]*backgroundJob, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		close(job.stop)
+		jobs = append(jobs, job)
+	}
+	m.jobs = make(map[string]*backgroundJob)
+	m.mu.Unlock()
+
+	// Wait for every worker to exit
+	done := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(done)
+	}()
+
+	var waitErr error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// Abort in-flight runs and wait for them to return
+		m.runCancel()
+		<-done
+		waitErr = fmt.Errorf("timed out waiting for background workers: %w", ctx.Err())
+	}
+	m.runCancel()
+
+	// Hand periodic jobs over to other instances right away
+	m.releaseJobLeases(ctx, jobs)
+
+	m.errMu.Lock()
+	errs := append(m.shutdownErrs, waitErr)
+	m.shutdownErrs = nil
+	m.errMu.Unlock()
+
+	m.logger.InfoContext(ctx, "mail manager closed", "jobs", len(jobs))
+
+	return errors.Join(errs...)
+}
+
+// schedule (re)starts a periodic job, stopping any previous job with the same name first
+func (m *DefaultMailManager) schedule(name string, interval time.Duration, run func(ctx context.Context) error) error {
+	for {
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			return ErrManagerClosed
+		}
+
+		existing := m.jobs[name]
+		if existing == nil {
+			m.startJobLocked(name, interval, run)
+			m.mu.Unlock()
+			return nil
+		}
+
+		// Stop the existing job and wait for its in-flight run without holding the lock,
+		// then check again in case the job was rescheduled in the meantime
+		close(existing.stop)
+		delete(m.jobs, name)
+		m.mu.Unlock()
+		<-existing.done
+	}
+}
+
+// startJobLocked starts a job goroutine; the caller must hold m.mu
+func (m *DefaultMailManager) startJobLocked(name string, interval time.Duration, run func(ctx context.Context) error) {
+	job := &backgroundJob{
+		name:     name,
+		interval: interval,
+		run:      run,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	m.jobs[name] = job
+	if m.jobHooks.OnStart != nil {
+		m.jobHooks.OnStart(name, interval)
+	}
+
+	m.workers.Add(1)
+	go m.runJob(job)
+}
+
+// runJob runs a job on every tick until it is stopped
+func (m *DefaultMailManager) runJob(job *backgroundJob) {
+	defer m.workers.Done()
+	defer close(job.done)
+	if m.jobHooks.OnStop != nil {
+		defer m.jobHooks.OnStop(job.name)
+	}
+
+	for {
+		tick, stopTimer := m.clock.NewTimer(job.interval)
+		select {
+		case <-job.stop:
+			stopTimer()
+			return
+		case <-tick:
+			if !m.acquireJobLease(job) {
+				continue
+			}
+
+			ctx, cancel := context.WithCancelCause(m.runCtx)
+			if m.leases != nil {
+				ctx = context.WithValue(ctx, jobLeaseKey{}, &jobLease{job: job, cancel: cancel})
+			}
+			err := job.run(ctx)
+			if cause := context.Cause(ctx); errors.Is(cause, ErrJobLeaseLost) {
+				m.logger.WarnContext(m.runCtx, "job lease taken over by another instance, run stopped",
+					"job", job.name,
+					"holder", m.leaseHolder,
+				)
+			}
+			cancel(nil)
+
+			// Report failures of the run that was in flight when the job was stopped
+			select {
+			case <-job.stop:
+				if err != nil && m.closing.Load() {
+					m.errMu.Lock()
+					m.shutdownErrs = append(m.shutdownErrs, fmt.Errorf("%s job: %w", job.name, err))
+					m.errMu.Unlock()
+				}
+				return
+			default:
+			}
+		}
+	}
+}
+
+// acquireJobLease reports whether this instance should run the job now.
+// Leases last two intervals so the leader renews its lease on every tick and a standby takes over once it stops.
+// Runs renew the lease before every batch as well, see renewJobLease.
+func (m *DefaultMailManager) acquireJobLease(job *backgroundJob) bool {
+	if m.leases == nil {
+		return true
+	}
+
+	acquired, err := m.leases.TryAcquire(m.runCtx, jobLeasePrefix+job.name, m.leaseHolder, 2*job.interval)
+	if err != nil {
+		m.logger.WarnContext(m.runCtx, "failed to acquire job lease",
+			"job", job.name,
+			"holder", m.leaseHolder,
+			"error", err,
+		)
+		return false
+	}
+	if !acquired {
+		m.logger.DebugContext(m.runCtx, "job lease held by another instance",
+			"job", job.name,
+			"holder", m.leaseHolder,
+		)
+	}
+
+	return acquired
+}
+
+// renewJobLease fences a batch of work done by a job run. It renews the lease held by the run in ctx, if any,
+// and fails and stops the run once another instance has taken the lease over, e.g. because a batch took longer than the lease.
+func (m *DefaultMailManager) renewJobLease(ctx context.Context) error {
+	lease, ok := ctx.Value(jobLeaseKey{}).(*jobLease)
+	if !ok {
+		return nil
+	}
+
+	acquired, err := m.leases.TryAcquire(ctx, jobLeasePrefix+lease.job.name, m.leaseHolder, 2*lease.job.interval)
+	if err != nil {
+		return fmt.Errorf("failed to renew %s job lease: %w", lease.job.name, err)
+	}
+	if !acquired {
+		err := fmt.Errorf("%s job: %w", lease.job.name, ErrJobLeaseLost)
+		lease.cancel(err)
+		return err
+	}
+
+	return nil
+}
+
+// releaseJobLeases releases the leases of stopped jobs.
+// It doesn't inherit the cancellation of ctx, so the leases are released even after the close deadline.
+func (m *DefaultMailManager) releaseJobLeases(ctx context.Context, jobs []*backgroundJob) {
+	if m.leases == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), leaseReleaseTimeout)
+	defer cancel()
+
+	for _, job := range jobs {
+		if err := m.leases.Release(ctx, jobLeasePrefix+job.name, m.leaseHolder); err != nil {
+			m.logger.WarnContext(ctx, "failed to release job lease",
+				"job", job.name,
+				"holder", m.leaseHolder,
+				"error", err,
+			)
+		}
+	}
+}
+
+// sleepContext pauses for d on the given clock or until ctx is done, returning the context error in the latter case
+func sleepContext(ctx context.Context, clock Clock, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer, stopTimer := clock.NewTimer(d)
+	defer stopTimer()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer:
+		return nil
+	}
+}
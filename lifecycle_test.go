@@ -0,0 +1,389 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weedbox/inboxer/inboxertest"
+)
+
+// blockingStore counts expired mail deletions and optionally blocks them until released
+type blockingStore struct {
+	MailStore
+	runs    atomic.Int32
+	started chan struct{}
+	release chan struct{}
+	err     error
+}
+
+func (s *blockingStore) DeleteExpiredMailsBatched(ctx context.Context, beforeTime time.Time, opts ExpiredDeleteOptions) (int, error) {
+	s.runs.Add(1)
+	if s.started != nil {
+		select {
+		case s.started <- struct{}{}:
+		default:
+		}
+	}
+	if s.release != nil {
+		select {
+		case <-s.release:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.MailStore.DeleteExpiredMailsBatched(ctx, beforeTime, opts)
+}
+
+// advanceJobs waits until n jobs are waiting on the clock, advances it by d and waits for their runs to finish.
+// d must be at least the interval of every job.
+func advanceJobs(clock *inboxertest.FakeClock, n int, d time.Duration) {
+	clock.BlockUntil(n)
+	clock.Advance(d)
+	clock.BlockUntil(n)
+}
+
+func TestManagerStartClose(t *testing.T) {
+	ctx := context.Background()
+	clock := inboxertest.NewFakeClock(time.Now())
+	store := &blockingStore{MailStore: NewMemoryMailStore()}
+	manager := NewDefaultMailManager(store, WithClock(clock), WithCleanupInterval(time.Hour))
+
+	// Configured workers only run once started
+	assert.Equal(t, 0, clock.Waiters())
+
+	require.NoError(t, manager.Start(ctx))
+	require.NoError(t, manager.Start(ctx)) // Starting twice is a no-op
+	advanceJobs(clock, 1, time.Hour)
+	assert.Equal(t, int32(1), store.runs.Load())
+
+	// No runs happen after Close returns
+	require.NoError(t, manager.Close(ctx))
+	clock.Advance(time.Hour)
+	assert.Equal(t, int32(1), store.runs.Load())
+	assert.Empty(t, manager.jobs)
+
+	// Closing twice is a no-op, everything else reports the closed manager
+	assert.NoError(t, manager.Close(ctx))
+	assert.ErrorIs(t, manager.Start(ctx), ErrManagerClosed)
+	assert.ErrorIs(t, manager.ScheduleCleanup(ctx, time.Second), ErrManagerClosed)
+}
+
+func TestManagerRescheduleCleanup(t *testing.T) {
+	ctx := context.Background()
+	manager := NewDefaultMailManager(NewMemoryMailStore())
+
+	// Rescheduling replaces the running job without blocking
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, manager.ScheduleCleanup(ctx, time.Hour))
+		assert.NoError(t, manager.ScheduleCleanup(ctx, time.Hour))
+		assert.NoError(t, manager.ScheduleCleanup(ctx, time.Minute))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("rescheduling cleanup blocked")
+	}
+
+	assert.Len(t, manager.jobs, 1)
+	assert.Equal(t, time.Minute, manager.jobs[cleanupJobName].interval)
+	assert.NoError(t, manager.Close(ctx))
+}
+
+func TestManagerRescheduleStopsTimer(t *testing.T) {
+	ctx := context.Background()
+	clock := inboxertest.NewFakeClock(time.Now())
+	store := &blockingStore{MailStore: NewMemoryMailStore()}
+	manager := NewDefaultMailManager(store, WithClock(clock))
+	require.NoError(t, manager.ScheduleCleanup(ctx, time.Hour))
+	clock.BlockUntil(1)
+
+	// The replaced job no longer waits on the clock, so only the new job counts as a waiter
+	require.NoError(t, manager.ScheduleCleanup(ctx, time.Minute))
+	assert.LessOrEqual(t, clock.Waiters(), 1)
+	advanceJobs(clock, 1, time.Minute)
+	assert.Equal(t, int32(1), store.runs.Load())
+	assert.Equal(t, 1, clock.Waiters())
+
+	require.NoError(t, manager.Close(ctx))
+	assert.Equal(t, 0, clock.Waiters())
+}
+
+func TestManagerCloseWaitsForInFlightRun(t *testing.T) {
+	ctx := context.Background()
+	store := &blockingStore{
+		MailStore: NewMemoryMailStore(),
+		started:   make(chan struct{}, 1),
+		release:   make(chan struct{}),
+		err:       errors.New("database unavailable"),
+	}
+	clock := inboxertest.NewFakeClock(time.Now())
+	manager := NewDefaultMailManager(store, WithClock(clock))
+	require.NoError(t, manager.ScheduleCleanup(ctx, time.Minute))
+
+	// Wait for a run to be in flight
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	<-store.started
+
+	closed := make(chan error)
+	go func() {
+		closed <- manager.Close(ctx)
+	}()
+
+	// Close waits for the in-flight run
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the in-flight run finished")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	// The error of the in-flight run is reported by Close
+	close(store.release)
+	err := <-closed
+	assert.ErrorContains(t, err, "database unavailable")
+}
+
+func TestManagerCloseTimeout(t *testing.T) {
+	store := &blockingStore{
+		MailStore: NewMemoryMailStore(),
+		started:   make(chan struct{}, 1),
+		release:   make(chan struct{}),
+	}
+	clock := inboxertest.NewFakeClock(time.Now())
+	manager := NewDefaultMailManager(store, WithClock(clock))
+	require.NoError(t, manager.ScheduleCleanup(context.Background(), time.Minute))
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	<-store.started
+
+	// The in-flight run is cancelled when the close deadline passes
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := manager.Close(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// ctxLeaseManager fails lease releases with a done context, like a lease manager backed by a database
+type ctxLeaseManager struct {
+	LeaseManager
+}
+
+func (l ctxLeaseManager) Release(ctx context.Context, key, holderID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return l.LeaseManager.Release(ctx, key, holderID)
+}
+
+func TestManagerCloseTimeoutReleasesLeases(t *testing.T) {
+	clock := inboxertest.NewFakeClock(time.Now())
+	leases := NewMemoryLeaseManager(WithStoreClock(clock))
+	store := &blockingStore{
+		MailStore: NewMemoryMailStore(),
+		started:   make(chan struct{}, 1),
+		release:   make(chan struct{}),
+	}
+	manager := NewDefaultMailManager(store, WithClock(clock), WithLeaseManager(ctxLeaseManager{leases}, "leader"))
+	require.NoError(t, manager.ScheduleCleanup(context.Background(), time.Minute))
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	<-store.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, manager.Close(ctx), context.DeadlineExceeded)
+
+	// Another instance takes over right away instead of waiting for the lease to expire
+	acquired, err := leases.TryAcquire(context.Background(), jobLeasePrefix+cleanupJobName, "standby", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+}
+
+func TestManagerRescheduleDoesNotBlockDuringRun(t *testing.T) {
+	ctx := context.Background()
+	store := &blockingStore{
+		MailStore: NewMemoryMailStore(),
+		started:   make(chan struct{}, 1),
+		release:   make(chan struct{}),
+	}
+	clock := inboxertest.NewFakeClock(time.Now())
+	manager := NewDefaultMailManager(store, WithClock(clock))
+	require.NoError(t, manager.ScheduleCleanup(ctx, time.Minute))
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	<-store.started
+
+	// Rescheduling waits for the in-flight run
+	rescheduled := make(chan error)
+	go func() {
+		rescheduled <- manager.ScheduleCleanup(ctx, time.Hour)
+	}()
+	select {
+	case <-rescheduled:
+		t.Fatal("rescheduling returned before the in-flight run finished")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	// Other calls are not blocked meanwhile
+	started := make(chan error)
+	go func() {
+		started <- manager.Start(ctx)
+	}()
+	select {
+	case err := <-started:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Start blocked while a job was being rescheduled")
+	}
+
+	close(store.release)
+	require.NoError(t, <-rescheduled)
+	assert.Equal(t, time.Hour, manager.jobs[cleanupJobName].interval)
+	assert.NoError(t, manager.Close(ctx))
+}
+
+func TestManagerJobLeaderElection(t *testing.T) {
+	ctx := context.Background()
+	clock := inboxertest.NewFakeClock(time.Now())
+	leases := NewMemoryLeaseManager(WithStoreClock(clock))
+	interval := time.Minute
+
+	leaderStore := &blockingStore{MailStore: NewMemoryMailStore()}
+	leader := NewDefaultMailManager(leaderStore, WithClock(clock), WithLeaseManager(leases, "leader"))
+	require.NoError(t, leader.ScheduleCleanup(ctx, interval))
+	advanceJobs(clock, 1, interval)
+	assert.Equal(t, int32(1), leaderStore.runs.Load())
+
+	// The standby does not run cleanup while the leader keeps renewing its lease
+	standbyStore := &blockingStore{MailStore: NewMemoryMailStore()}
+	standby := NewDefaultMailManager(standbyStore, WithClock(clock), WithLeaseManager(leases, "standby"))
+	require.NoError(t, standby.ScheduleCleanup(ctx, interval))
+	for i := 0; i < 5; i++ {
+		advanceJobs(clock, 2, interval)
+	}
+	assert.Equal(t, int32(6), leaderStore.runs.Load())
+	assert.Equal(t, int32(0), standbyStore.runs.Load())
+
+	// Once the leader shuts down, the standby takes over on its next run
+	require.NoError(t, leader.Close(ctx))
+	advanceJobs(clock, 1, interval)
+	assert.Equal(t, int32(1), standbyStore.runs.Load())
+	require.NoError(t, standby.Close(ctx))
+}
+
+func TestManagerJobLeaseFailover(t *testing.T) {
+	ctx := context.Background()
+	clock := inboxertest.NewFakeClock(time.Now())
+	leases := NewMemoryLeaseManager(WithStoreClock(clock))
+	interval := time.Minute
+
+	// A crashed leader keeps its lease until it expires
+	acquired, err := leases.TryAcquire(ctx, jobLeasePrefix+cleanupJobName, "crashed", 5*interval)
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	store := &blockingStore{MailStore: NewMemoryMailStore()}
+	manager := NewDefaultMailManager(store, WithClock(clock), WithLeaseManager(leases, ""))
+	assert.NotEmpty(t, manager.leaseHolder)
+	require.NoError(t, manager.ScheduleCleanup(ctx, interval))
+
+	for i := 0; i < 4; i++ {
+		advanceJobs(clock, 1, interval)
+	}
+	assert.Equal(t, int32(0), store.runs.Load())
+
+	advanceJobs(clock, 1, interval)
+	assert.Equal(t, int32(1), store.runs.Load())
+	require.NoError(t, manager.Close(ctx))
+}
+
+func TestManagerJobRenewsLeaseDuringRun(t *testing.T) {
+	ctx := context.Background()
+	clock := inboxertest.NewFakeClock(time.Now())
+	leases := NewMemoryLeaseManager(WithStoreClock(clock))
+	interval := time.Minute
+
+	store := NewMemoryMailStore(WithStoreClock(clock), WithDeleteBatchSize(1))
+	for i := 0; i < 5; i++ {
+		_, err := store.CreateMail(ctx, &Mail{RecipientID: "user1", ExpireTime: clock.Now().Add(-time.Hour)})
+		require.NoError(t, err)
+	}
+
+	// Every batch takes longer than the lease would last without renewal
+	batches := 0
+	manager := NewDefaultMailManager(store,
+		WithClock(clock),
+		WithLeaseManager(leases, "leader"),
+		WithExpiryHook(func(ctx context.Context, mails []*Mail) error {
+			batches++
+			clock.Advance(interval)
+			return nil
+		}),
+	)
+	require.NoError(t, manager.ScheduleCleanup(ctx, interval))
+	advanceJobs(clock, 1, interval)
+	assert.Equal(t, 5, batches)
+
+	_, total, err := store.QueryMails(ctx, nil, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 0, total)
+
+	// The lease is still held after the long run
+	acquired, err := leases.TryAcquire(ctx, jobLeasePrefix+cleanupJobName, "standby", interval)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+	require.NoError(t, manager.Close(ctx))
+}
+
+func TestManagerJobStopsWhenLeaseLost(t *testing.T) {
+	ctx := context.Background()
+	clock := inboxertest.NewFakeClock(time.Now())
+	leases := NewMemoryLeaseManager(WithStoreClock(clock))
+	interval := time.Minute
+
+	store := NewMemoryMailStore(WithStoreClock(clock), WithDeleteBatchSize(1))
+	for i := 0; i < 5; i++ {
+		_, err := store.CreateMail(ctx, &Mail{RecipientID: "user1", ExpireTime: clock.Now().Add(-time.Hour)})
+		require.NoError(t, err)
+	}
+
+	// A batch stalls past the lease and another instance takes the job over meanwhile
+	cleanups := make(chan error, 1)
+	manager := NewDefaultMailManager(store,
+		WithClock(clock),
+		WithLeaseManager(leases, "leader"),
+		WithExpiryHook(func(ctx context.Context, mails []*Mail) error {
+			clock.Advance(3 * interval)
+			acquired, err := leases.TryAcquire(ctx, jobLeasePrefix+cleanupJobName, "standby", 2*interval)
+			require.NoError(t, err)
+			require.True(t, acquired)
+			return nil
+		}),
+		WithJobHooks(JobHooks{OnCleanup: func(ctx context.Context, deleted int, err error) {
+			cleanups <- err
+		}}),
+	)
+	require.NoError(t, manager.ScheduleCleanup(ctx, interval))
+	clock.BlockUntil(1)
+	clock.Advance(interval)
+
+	// The stalled batch is deleted, but the run stops before the next one
+	assert.Error(t, <-cleanups)
+	require.NoError(t, manager.Close(ctx))
+
+	_, total, err := store.QueryMails(ctx, nil, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 4, total)
+}
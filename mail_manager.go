@@ -4,28 +4,86 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// DefaultMaxUpdateRetries is the default number of retries for read-modify-write operations
+const DefaultMaxUpdateRetries = 3
+
 // DefaultMailManager implements the MailManager interface
 type DefaultMailManager struct {
-	store       MailStore    // Storage backend
-	cleanupTick *time.Ticker // Ticker for periodic cleanup
-	cleanupStop chan bool    // Channel to stop cleanup goroutine
-	mu          sync.Mutex   // Mutex for managing concurrent operations
+	store            MailStore    // Storage backend
+	mu               sync.Mutex   // Mutex for managing concurrent operations
+	maxUpdateRetries int          // Retries on version conflicts for read-modify-write operations
+	logger           *slog.Logger // Logger for structured mail events
+	clock            Clock        // Source of the current time for timestamps, expiry and job schedules
+	tracerProvider   trace.TracerProvider
+	tracer           trace.Tracer
+
+	// Background workers
+	jobs            map[string]*backgroundJob // Running periodic jobs by name
+	workers         sync.WaitGroup            // Tracks running worker goroutines
+	runCtx          context.Context           // Context for job runs, cancelled when Close gives up waiting
+	runCancel       context.CancelFunc
+	cleanupInterval time.Duration // Cleanup interval started by Start
+	started         bool
+	closed          bool
+	closing         atomic.Bool // Set once Close begins, read by workers without holding mu
+	errMu           sync.Mutex
+	shutdownErrs    []error          // Errors of runs in flight during shutdown
+	leases          LeaseManager     // Elects a single instance to run periodic jobs, nil runs them everywhere
+	leaseHolder     string           // Identifies this instance as a lease holder
+	expiryHooks     []ExpiryHook     // Called with every batch of expired mails before deletion
+	retention       *RetentionPolicy // Limits how long mails are kept, nil keeps them until their own expire time
+	jobHooks        JobHooks         // Observes periodic jobs and cleanup runs
+
+	// Expiring soon notifications
+	expiringSoonHandler ExpiringSoonHandler
+	expiryNotifier      expiryNotifier
 }
 
 // NewDefaultMailManager creates a new mail manager with the provided store
-func NewDefaultMailManager(store MailStore) *DefaultMailManager {
-	return &DefaultMailManager{
-		store:       store,
-		cleanupStop: make(chan bool),
+func NewDefaultMailManager(store MailStore, opts ...ManagerOption) *DefaultMailManager {
+	m := &DefaultMailManager{
+		store:            store,
+		maxUpdateRetries: DefaultMaxUpdateRetries,
+		logger:           slog.Default(),
+		clock:            SystemClock{},
+		jobs:             make(map[string]*backgroundJob),
+	}
+	m.runCtx, m.runCancel = context.WithCancel(context.Background())
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(m)
+		}
+	}
+
+	if m.leases != nil && m.leaseHolder == "" {
+		m.leaseHolder = NewULIDGenerator().GenerateID()
 	}
+
+	// Trace store calls as children of the manager spans
+	m.tracer = newTracer(m.tracerProvider)
+	if m.tracerProvider != nil {
+		m.store = NewTracingMailStore(m.store, m.tracerProvider)
+	}
+
+	return m
 }
 
 // SendMail sends a single mail
-func (m *DefaultMailManager) SendMail(ctx context.Context, mail *Mail) (string, error) {
+func (m *DefaultMailManager) SendMail(ctx context.Context, mail *Mail) (mailID string, err error) {
+	ctx, span := m.startSpan(ctx, "SendMail")
+	defer func() { endSpan(span, err) }()
+
 	if mail == nil {
 		return "", errors.New("mail cannot be nil")
 	}
@@ -34,11 +92,30 @@ func (m *DefaultMailManager) SendMail(ctx context.Context, mail *Mail) (string,
 	m.prepareMailForSending(mail)
 
 	// Store the mail
-	return m.store.CreateMail(ctx, mail)
+	span.SetAttributes(AttrRecipientID.String(mail.RecipientID), AttrSenderID.String(mail.SenderID))
+
+	start := time.Now()
+	mailID, err = m.store.CreateMail(ctx, mail)
+	if err != nil {
+		m.logStoreError(ctx, "send mail", err, "recipient_id", mail.RecipientID)
+		return "", err
+	}
+
+	m.logger.DebugContext(ctx, "mail sent",
+		"mail_id", mailID,
+		"sender_id", mail.SenderID,
+		"recipient_id", mail.RecipientID,
+		"duration", time.Since(start),
+	)
+
+	return mailID, nil
 }
 
 // SendBatchMail sends the same mail content to multiple recipients
-func (m *DefaultMailManager) SendBatchMail(ctx context.Context, mail *Mail, recipientIDs []string) ([]string, error) {
+func (m *DefaultMailManager) SendBatchMail(ctx context.Context, mail *Mail, recipientIDs []string) (ids []string, err error) {
+	ctx, span := m.startSpan(ctx, "SendBatchMail", AttrMailCount.Int(len(recipientIDs)))
+	defer func() { endSpan(span, err) }()
+
 	if mail == nil {
 		return nil, errors.New("mail cannot be nil")
 	}
@@ -65,6 +142,11 @@ func (m *DefaultMailManager) SendBatchMail(ctx context.Context, mail *Mail, reci
 			CreateTime:  mail.CreateTime,
 			ExpireTime:  mail.ExpireTime,
 			Tags:        make([]string, len(mail.Tags)),
+			Priority:    mail.Priority,
+			Folder:      mail.Folder,
+			Starred:     mail.Starred,
+			Pinned:      mail.Pinned,
+			Archived:    mail.Archived,
 		}
 
 		// Copy tags
@@ -82,42 +164,62 @@ func (m *DefaultMailManager) SendBatchMail(ctx context.Context, mail *Mail, reci
 	}
 
 	// Store all mails in batch
-	return m.store.CreateBatchMails(ctx, mails)
+	start := time.Now()
+	ids, err = m.store.CreateBatchMails(ctx, mails)
+	if err != nil {
+		m.logStoreError(ctx, "send batch mail", err, "count", len(mails))
+		return nil, err
+	}
+
+	m.logger.InfoContext(ctx, "batch mail sent",
+		"sender_id", mail.SenderID,
+		"count", len(ids),
+		"duration", time.Since(start),
+	)
+
+	return ids, nil
 }
 
 // SendSystemAnnouncement sends a system announcement to all players
 // Note: In a real implementation, this would fetch all active player IDs from a player management system
 // For this implementation, we're using a placeholder that simply tags the mail appropriately
-func (m *DefaultMailManager) SendSystemAnnouncement(ctx context.Context, mail *Mail) (string, error) {
+func (m *DefaultMailManager) SendSystemAnnouncement(ctx context.Context, mail *Mail) (mailID string, err error) {
+	ctx, span := m.startSpan(ctx, "SendSystemAnnouncement")
+	defer func() { endSpan(span, err) }()
+
 	if mail == nil {
 		return "", errors.New("mail cannot be nil")
 	}
 
-	// Set default values
-	m.prepareMailForSending(mail)
-
 	// Mark as system announcement
-	mail.SenderID = "system"
+	mail.SenderID = SystemSenderID
 	mail.RecipientID = "all_players" // Special recipient ID for system announcements
 
 	// Add system announcement tag if not already present
-	hasAnnouncementTag := false
-	for _, tag := range mail.Tags {
-		if tag == "system_announcement" {
-			hasAnnouncementTag = true
-			break
-		}
-	}
-	if !hasAnnouncementTag {
-		mail.Tags = append(mail.Tags, "system_announcement")
+	if !containsString(mail.Tags, SystemAnnouncementTag) {
+		mail.Tags = append(mail.Tags, SystemAnnouncementTag)
 	}
 
+	// Set default values, after the announcement tag so retention rules can match it
+	m.prepareMailForSending(mail)
+
 	// Store the announcement
-	return m.store.CreateMail(ctx, mail)
+	mailID, err = m.store.CreateMail(ctx, mail)
+	if err != nil {
+		m.logStoreError(ctx, "send system announcement", err)
+		return "", err
+	}
+
+	m.logger.InfoContext(ctx, "system announcement sent", "mail_id", mailID)
+
+	return mailID, nil
 }
 
 // GetMailByID gets a mail by ID
-func (m *DefaultMailManager) GetMailByID(ctx context.Context, mailID string) (*Mail, error) {
+func (m *DefaultMailManager) GetMailByID(ctx context.Context, mailID string) (mail *Mail, err error) {
+	ctx, span := m.startSpan(ctx, "GetMailByID", AttrMailID.String(mailID))
+	defer func() { endSpan(span, err) }()
+
 	if mailID == "" {
 		return nil, errors.New("mail ID cannot be empty")
 	}
@@ -125,17 +227,23 @@ func (m *DefaultMailManager) GetMailByID(ctx context.Context, mailID string) (*M
 	return m.store.GetMail(ctx, mailID)
 }
 
-// GetMailsByRecipient gets a user's mails with pagination
-func (m *DefaultMailManager) GetMailsByRecipient(ctx context.Context, recipientID string, page, size int) ([]*Mail, int, error) {
+// GetMailsByRecipient gets a user's mails with pagination, pinned mails first and then in the optional order
+func (m *DefaultMailManager) GetMailsByRecipient(ctx context.Context, recipientID string, page, size int, order ...SortOrder) (mails []*Mail, total int, err error) {
+	ctx, span := m.startSpan(ctx, "GetMailsByRecipient", AttrRecipientID.String(recipientID), AttrPage.Int(page), AttrPageSize.Int(size))
+	defer func() { endSpan(span, err) }()
+
 	if recipientID == "" {
 		return nil, 0, errors.New("recipient ID cannot be empty")
 	}
 
-	return m.store.GetMailsByRecipient(ctx, recipientID, page, size)
+	return m.store.GetMailsByRecipient(ctx, recipientID, page, size, order...)
 }
 
 // QueryMails queries mails by conditions with pagination
-func (m *DefaultMailManager) QueryMails(ctx context.Context, filter *MailFilter, page, size int) ([]*Mail, int, error) {
+func (m *DefaultMailManager) QueryMails(ctx context.Context, filter *MailFilter, page, size int) (mails []*Mail, total int, err error) {
+	ctx, span := m.startSpan(ctx, "QueryMails", append(filterAttributes(filter), AttrPage.Int(page), AttrPageSize.Int(size))...)
+	defer func() { endSpan(span, err) }()
+
 	if filter == nil {
 		filter = &MailFilter{}
 	}
@@ -143,98 +251,306 @@ func (m *DefaultMailManager) QueryMails(ctx context.Context, filter *MailFilter,
 	return m.store.QueryMails(ctx, filter, page, size)
 }
 
+// GetMailsByFolder gets a user's mails in a folder that are not archived with pagination
+func (m *DefaultMailManager) GetMailsByFolder(ctx context.Context, recipientID, folder string, page, size int) (mails []*Mail, total int, err error) {
+	ctx, span := m.startSpan(ctx, "GetMailsByFolder", AttrRecipientID.String(recipientID), AttrFolder.String(folder), AttrPage.Int(page), AttrPageSize.Int(size))
+	defer func() { endSpan(span, err) }()
+
+	if recipientID == "" {
+		return nil, 0, errors.New("recipient ID cannot be empty")
+	}
+	if folder == "" {
+		return nil, 0, errors.New("folder cannot be empty")
+	}
+
+	archived := false
+	return m.store.QueryMails(ctx, &MailFilter{RecipientID: recipientID, Folder: folder, Archived: &archived}, page, size)
+}
+
+// GetArchivedMails gets a user's archived mails with pagination
+func (m *DefaultMailManager) GetArchivedMails(ctx context.Context, recipientID string, page, size int) (mails []*Mail, total int, err error) {
+	ctx, span := m.startSpan(ctx, "GetArchivedMails", AttrRecipientID.String(recipientID), AttrPage.Int(page), AttrPageSize.Int(size))
+	defer func() { endSpan(span, err) }()
+
+	if recipientID == "" {
+		return nil, 0, errors.New("recipient ID cannot be empty")
+	}
+
+	archived := true
+	return m.store.QueryMails(ctx, &MailFilter{RecipientID: recipientID, Archived: &archived}, page, size)
+}
+
 // MarkAsRead marks a mail as read
-func (m *DefaultMailManager) MarkAsRead(ctx context.Context, mailID string) error {
+func (m *DefaultMailManager) MarkAsRead(ctx context.Context, mailID string) (err error) {
+	ctx, span := m.startSpan(ctx, "MarkAsRead", AttrMailID.String(mailID))
+	defer func() { endSpan(span, err) }()
+
 	if mailID == "" {
 		return errors.New("mail ID cannot be empty")
 	}
 
-	// Get the mail first
-	mail, err := m.store.GetMail(ctx, mailID)
+	_, err = m.UpdateMailWithRetry(ctx, mailID, func(mail *Mail) (bool, error) {
+		// If already read, no need to update
+		if mail.ReadStatus {
+			return false, nil
+		}
+
+		mail.ReadStatus = true
+		mail.ReadTime = m.clock.Now()
+		mail.ExpireTime = m.retention.ExpireTime(mail)
+		return true, nil
+	})
 	if err != nil {
+		m.logStoreError(ctx, "mark as read", err, "mail_id", mailID)
 		return err
 	}
 
-	// If already read, no need to update
-	if mail.ReadStatus {
-		return nil
-	}
-
-	// Mark as read and update
-	mail.ReadStatus = true
-	return m.store.UpdateMail(ctx, mail)
+	return nil
 }
 
 // MarkAllAsRead marks all user's mails as read
-func (m *DefaultMailManager) MarkAllAsRead(ctx context.Context, recipientID string) error {
+func (m *DefaultMailManager) MarkAllAsRead(ctx context.Context, recipientID string) (err error) {
+	ctx, span := m.startSpan(ctx, "MarkAllAsRead", AttrRecipientID.String(recipientID))
+	defer func() { endSpan(span, err) }()
+
 	if recipientID == "" {
 		return errors.New("recipient ID cannot be empty")
 	}
 
-	// Fetch all user's mails
-	// Note: This uses pagination internally but processes all pages to mark everything as read
-	// In a real production system with many mails, this might need a direct DB update instead
-	page := 1
-	pageSize := 100
-	totalProcessed := 0
-
-	for {
-		mails, total, err := m.store.GetMailsByRecipient(ctx, recipientID, page, pageSize)
+	// Expiration after read depends on each mail, so mark them one by one
+	if m.retention.hasAfterRead() {
+		count, err := m.markAllAsReadEach(ctx, recipientID)
 		if err != nil {
+			m.logStoreError(ctx, "mark all as read", err, "recipient_id", recipientID)
 			return err
 		}
 
-		// No more mails to process
-		if len(mails) == 0 {
-			break
-		}
+		m.logger.DebugContext(ctx, "all mails marked as read", "recipient_id", recipientID, "count", count)
+		return nil
+	}
 
-		// Mark each unread mail as read
-		for _, mail := range mails {
-			if !mail.ReadStatus {
-				mail.ReadStatus = true
-				if err := m.store.UpdateMail(ctx, mail); err != nil {
-					return err
-				}
-			}
-		}
+	// Mark every unread mail as read in a single bulk update
+	readStatus := false
+	markRead := true
+	readTime := m.clock.Now()
+	count, err := m.store.UpdateByFilter(ctx, &MailFilter{
+		RecipientID: recipientID,
+		ReadStatus:  &readStatus,
+	}, &MailPatch{
+		ReadStatus: &markRead,
+		ReadTime:   &readTime,
+	})
+	if err != nil {
+		m.logStoreError(ctx, "mark all as read", err, "recipient_id", recipientID)
+		return err
+	}
 
-		totalProcessed += len(mails)
-		if totalProcessed >= total {
-			break
-		}
+	m.logger.DebugContext(ctx, "all mails marked as read", "recipient_id", recipientID, "count", count)
+
+	return nil
+}
+
+// MoveToFolder moves mails to a folder in a single bulk update
+func (m *DefaultMailManager) MoveToFolder(ctx context.Context, mailIDs []string, folder string) (count int, err error) {
+	ctx, span := m.startSpan(ctx, "MoveToFolder", AttrFolder.String(folder), AttrMailCount.Int(len(mailIDs)))
+	defer func() { endSpan(span, err) }()
+
+	if folder == "" {
+		return 0, errors.New("folder cannot be empty")
+	}
+	if len(mailIDs) == 0 {
+		return 0, nil
+	}
+
+	count, err = m.store.UpdateByFilter(ctx, &MailFilter{IDs: mailIDs}, &MailPatch{Folder: &folder})
+	if err != nil {
+		m.logStoreError(ctx, "move to folder", err, "folder", folder, "mail_count", len(mailIDs))
+		return 0, err
+	}
+
+	m.logger.DebugContext(ctx, "mails moved to folder", "folder", folder, "count", count)
+	return count, nil
+}
+
+// SetStarred stars or unstars a mail
+func (m *DefaultMailManager) SetStarred(ctx context.Context, mailID string, starred bool) error {
+	return m.setFlag(ctx, "SetStarred", "set starred", mailID, starred, func(mail *Mail) *bool { return &mail.Starred })
+}
+
+// SetPinned pins or unpins a mail
+func (m *DefaultMailManager) SetPinned(ctx context.Context, mailID string, pinned bool) error {
+	return m.setFlag(ctx, "SetPinned", "set pinned", mailID, pinned, func(mail *Mail) *bool { return &mail.Pinned })
+}
+
+// SetArchived archives or unarchives a mail
+func (m *DefaultMailManager) SetArchived(ctx context.Context, mailID string, archived bool) error {
+	return m.setFlag(ctx, "SetArchived", "set archived", mailID, archived, func(mail *Mail) *bool { return &mail.Archived })
+}
+
+// setFlag sets a flag of a mail, skipping the update if it already has the value
+func (m *DefaultMailManager) setFlag(ctx context.Context, method, op, mailID string, value bool, flag func(mail *Mail) *bool) (err error) {
+	ctx, span := m.startSpan(ctx, method, AttrMailID.String(mailID))
+	defer func() { endSpan(span, err) }()
 
-		page++
+	if mailID == "" {
+		return errors.New("mail ID cannot be empty")
+	}
+
+	_, err = m.UpdateMailWithRetry(ctx, mailID, func(mail *Mail) (bool, error) {
+		field := flag(mail)
+		if *field == value {
+			return false, nil
+		}
+		*field = value
+		return true, nil
+	})
+	if err != nil {
+		m.logStoreError(ctx, op, err, "mail_id", mailID)
+		return err
 	}
 
 	return nil
 }
 
 // DeleteMail deletes a mail
-func (m *DefaultMailManager) DeleteMail(ctx context.Context, mailID string) error {
+func (m *DefaultMailManager) DeleteMail(ctx context.Context, mailID string) (err error) {
+	ctx, span := m.startSpan(ctx, "DeleteMail", AttrMailID.String(mailID))
+	defer func() { endSpan(span, err) }()
+
 	if mailID == "" {
 		return errors.New("mail ID cannot be empty")
 	}
 
-	return m.store.DeleteMail(ctx, mailID)
+	if err := m.store.DeleteMail(ctx, mailID); err != nil {
+		m.logStoreError(ctx, "delete mail", err, "mail_id", mailID)
+		return err
+	}
+
+	m.logger.DebugContext(ctx, "mail deleted", "mail_id", mailID)
+
+	return nil
 }
 
 // DeleteMailsByRecipient deletes all user's mails
-func (m *DefaultMailManager) DeleteMailsByRecipient(ctx context.Context, recipientID string) error {
+func (m *DefaultMailManager) DeleteMailsByRecipient(ctx context.Context, recipientID string) (err error) {
+	ctx, span := m.startSpan(ctx, "DeleteMailsByRecipient", AttrRecipientID.String(recipientID))
+	defer func() { endSpan(span, err) }()
+
 	if recipientID == "" {
 		return errors.New("recipient ID cannot be empty")
 	}
 
-	return m.store.DeleteMailsByRecipient(ctx, recipientID)
+	if err := m.store.DeleteMailsByRecipient(ctx, recipientID); err != nil {
+		m.logStoreError(ctx, "delete mails by recipient", err, "recipient_id", recipientID)
+		return err
+	}
+
+	m.logger.InfoContext(ctx, "recipient mails deleted", "recipient_id", recipientID)
+
+	return nil
 }
 
 // DeleteExpiredMails deletes all expired mails
-func (m *DefaultMailManager) DeleteExpiredMails(ctx context.Context) (int, error) {
-	return m.store.DeleteExpiredMails(ctx, time.Now())
+func (m *DefaultMailManager) DeleteExpiredMails(ctx context.Context) (count int, err error) {
+	ctx, span := m.startSpan(ctx, "DeleteExpiredMails")
+	defer func() { endSpan(span, err) }()
+
+	start := time.Now()
+	now := m.clock.Now()
+	opts := ExpiredDeleteOptions{
+		OnProgress: func(progress ExpiredDeleteProgress) {
+			m.logger.InfoContext(ctx, "expired mail deletion progress",
+				"batch", progress.Batch,
+				"count", progress.Deleted,
+				"skipped", progress.Skipped,
+				"total", progress.Total,
+				"duration", time.Since(start),
+			)
+		},
+	}
+	if len(m.expiryHooks) > 0 || ctx.Value(jobLeaseKey{}) != nil {
+		opts.BeforeDelete = m.beforeExpiredDelete
+	}
+
+	// Mails the policy has not been applied to yet expire before they are deleted
+	if err := m.applyRetention(ctx); err != nil {
+		m.logStoreError(ctx, "apply retention policy", err)
+		return 0, err
+	}
+
+	count, err = m.store.DeleteExpiredMailsBatched(ctx, now, opts)
+	if m.jobHooks.OnCleanup != nil {
+		m.jobHooks.OnCleanup(ctx, count, err)
+	}
+	if err != nil {
+		// Batches deleted before the failure stay deleted
+		m.logStoreError(ctx, "delete expired mails", err, "count", count)
+		return count, err
+	}
+
+	m.logger.InfoContext(ctx, "expired mails deleted",
+		"count", count,
+		"duration", time.Since(start),
+	)
+
+	return count, nil
+}
+
+// applyRetention brings the expire time of stored mails forward to the retention policy.
+// It covers mails stored before the policy was configured or changed and mails written to the store directly, such as imports.
+func (m *DefaultMailManager) applyRetention(ctx context.Context) error {
+	if m.retention == nil {
+		return nil
+	}
+
+	start := time.Now()
+	count := 0
+	err := m.store.ScanMails(ctx, nil, ScanOptions{}, func(mails []*Mail) error {
+		if err := m.renewJobLease(ctx); err != nil {
+			return err
+		}
+		for _, mail := range mails {
+			if m.retention.ExpireTime(mail).Equal(mail.ExpireTime) {
+				continue
+			}
+
+			// The mail is read again, so a concurrent change is not overwritten
+			_, err := m.UpdateMailWithRetry(ctx, mail.ID, func(mail *Mail) (bool, error) {
+				expireTime := m.retention.ExpireTime(mail)
+				if expireTime.Equal(mail.ExpireTime) {
+					return false, nil
+				}
+				mail.ExpireTime = expireTime
+				return true, nil
+			})
+			if errors.Is(err, ErrMailNotFound) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("mail %s: %w", mail.ID, err)
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		m.logger.InfoContext(ctx, "retention policy applied",
+			"count", count,
+			"duration", time.Since(start),
+		)
+	}
+	return nil
 }
 
 // CountUnreadMails counts unread mails for a recipient
-func (m *DefaultMailManager) CountUnreadMails(ctx context.Context, recipientID string) (int, error) {
+func (m *DefaultMailManager) CountUnreadMails(ctx context.Context, recipientID string) (count int, err error) {
+	ctx, span := m.startSpan(ctx, "CountUnreadMails", AttrRecipientID.String(recipientID))
+	defer func() { endSpan(span, err) }()
+
 	if recipientID == "" {
 		return 0, errors.New("recipient ID cannot be empty")
 	}
@@ -243,7 +559,10 @@ func (m *DefaultMailManager) CountUnreadMails(ctx context.Context, recipientID s
 }
 
 // CountMailsWithAttachments counts mails with attachments for a recipient
-func (m *DefaultMailManager) CountMailsWithAttachments(ctx context.Context, recipientID string) (int, error) {
+func (m *DefaultMailManager) CountMailsWithAttachments(ctx context.Context, recipientID string) (count int, err error) {
+	ctx, span := m.startSpan(ctx, "CountMailsWithAttachments", AttrRecipientID.String(recipientID))
+	defer func() { endSpan(span, err) }()
+
 	if recipientID == "" {
 		return 0, errors.New("recipient ID cannot be empty")
 	}
@@ -251,59 +570,246 @@ func (m *DefaultMailManager) CountMailsWithAttachments(ctx context.Context, reci
 	return m.store.CountMailsWithAttachments(ctx, recipientID)
 }
 
+// CountBy counts the mails matching the filter per group, ordered by group key
+func (m *DefaultMailManager) CountBy(ctx context.Context, filter *MailFilter, groupBy GroupBy) (groups []GroupCount, err error) {
+	ctx, span := m.startSpan(ctx, "CountBy", append(filterAttributes(filter), AttrGroupBy.String(string(groupBy)))...)
+	defer func() { endSpan(span, err) }()
+
+	if filter == nil {
+		filter = &MailFilter{}
+	}
+
+	return m.store.CountBy(ctx, filter, groupBy)
+}
+
+// CountUnreadByFolder counts a recipient's unread mails per folder with a single grouped count
+func (m *DefaultMailManager) CountUnreadByFolder(ctx context.Context, recipientID string) (counts map[string]int, err error) {
+	ctx, span := m.startSpan(ctx, "CountUnreadByFolder", AttrRecipientID.String(recipientID))
+	defer func() { endSpan(span, err) }()
+
+	if recipientID == "" {
+		return nil, errors.New("recipient ID cannot be empty")
+	}
+
+	unread, archived := false, false
+	groups, err := m.store.CountBy(ctx, &MailFilter{RecipientID: recipientID, ReadStatus: &unread, Archived: &archived}, GroupByFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	counts = make(map[string]int, len(groups))
+	for _, group := range groups {
+		counts[group.Key] = group.Count
+	}
+	return counts, nil
+}
+
 // ScheduleCleanup sets up automatic cleanup of expired mails
-func (m *DefaultMailManager) ScheduleCleanup(ctx context.Context, duration time.Duration) error {
+func (m *DefaultMailManager) ScheduleCleanup(ctx context.Context, duration time.Duration) (err error) {
+	ctx, span := m.startSpan(ctx, "ScheduleCleanup")
+	defer func() { endSpan(span, err) }()
+
 	if duration <= 0 {
 		return errors.New("cleanup duration must be positive")
 	}
 
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	// Replace any running cleanup job
+	return m.schedule(cleanupJobName, duration, m.runCleanup)
+}
+
+// ExportMailLogs exports mail logs based on filter
+func (m *DefaultMailManager) ExportMailLogs(ctx context.Context, filter *MailFilter) (logs string, err error) {
+	ctx, span := m.startSpan(ctx, "ExportMailLogs", filterAttributes(filter)...)
+	defer func() { endSpan(span, err) }()
+
+	if filter == nil {
+		filter = &MailFilter{}
+	}
 
-	// Stop existing cleanup if running
-	if m.cleanupTick != nil {
-		m.cleanupTick.Stop()
-		m.cleanupStop <- true
+	return m.store.ExportMailLogs(ctx, filter)
+}
+
+// ExportMails streams mails matching the filter to w in the selected format
+func (m *DefaultMailManager) ExportMails(ctx context.Context, w io.Writer, filter *MailFilter, opts ExportOptions) (count int, err error) {
+	ctx, span := m.startSpan(ctx, "ExportMails", filterAttributes(filter)...)
+	defer func() { endSpan(span, err) }()
+
+	if w == nil {
+		return 0, errors.New("writer cannot be nil")
 	}
 
-	// Create new ticker
-	m.cleanupTick = time.NewTicker(duration)
+	count, err = ExportMails(ctx, m.store, w, filter, opts)
+	span.SetAttributes(AttrMailCount.Int(count))
+	return count, err
+}
+
+// ImportMails restores mails exported as JSON or NDJSON, keeping their IDs and timestamps
+func (m *DefaultMailManager) ImportMails(ctx context.Context, r io.Reader, opts ImportOptions) (report *ImportReport, err error) {
+	ctx, span := m.startSpan(ctx, "ImportMails")
+	defer func() { endSpan(span, err) }()
 
-	// Start cleanup goroutine
-	go func() {
-		for {
-			select {
-			case <-m.cleanupTick.C:
-				// Execute cleanup in a new context since the original might have expired
-				cleanupCtx := context.Background()
-				count, err := m.DeleteExpiredMails(cleanupCtx)
-				if err != nil {
-					// In a real system, we'd log this error
-					fmt.Printf("Error during automatic mail cleanup: %v\n", err)
-				} else if count > 0 {
-					fmt.Printf("Automatic cleanup removed %d expired mails\n", count)
+	if r == nil {
+		return nil, errors.New("reader cannot be nil")
+	}
+
+	start := time.Now()
+	report, err = ImportMails(ctx, m.store, r, opts)
+	if err != nil {
+		// Chunks written before the failure stay imported
+		m.logStoreError(ctx, "import mails", err)
+		return report, err
+	}
+
+	span.SetAttributes(AttrMailCount.Int(report.Created + report.Overwritten))
+	m.logger.InfoContext(ctx, "mails imported",
+		"dry_run", report.DryRun,
+		"read", report.Read,
+		"created", report.Created,
+		"overwritten", report.Overwritten,
+		"skipped", report.Skipped,
+		"invalid", len(report.Errors),
+		"duration", time.Since(start),
+	)
+
+	return report, nil
+}
+
+// UpdateMailWithRetry performs a read-modify-write on a mail, retrying when a concurrent update causes a version conflict.
+// The mutate function is called with a fresh copy of the mail on every attempt and returns false if no update is needed.
+func (m *DefaultMailManager) UpdateMailWithRetry(ctx context.Context, mailID string, mutate func(mail *Mail) (bool, error)) (*Mail, error) {
+	if mailID == "" {
+		return nil, errors.New("mail ID cannot be empty")
+	}
+	if mutate == nil {
+		return nil, errors.New("mutate function cannot be nil")
+	}
+
+	for attempt := 0; ; attempt++ {
+		mail, err := m.store.GetMail(ctx, mailID)
+		if err != nil {
+			return nil, err
+		}
+
+		changed, err := mutate(mail)
+		if err != nil {
+			return nil, err
+		}
+		if !changed {
+			return mail, nil
+		}
+
+		err = m.store.UpdateMail(ctx, mail)
+		if err == nil {
+			return mail, nil
+		}
+		if !errors.Is(err, ErrVersionConflict) || attempt >= m.maxUpdateRetries {
+			return nil, err
+		}
+
+		// Back off briefly before re-reading the mail
+		if err := sleepContext(ctx, m.clock, time.Duration(attempt+1)*time.Millisecond); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// runCleanup performs a single automatic cleanup run in its own root span and logs its outcome
+func (m *DefaultMailManager) runCleanup(ctx context.Context) error {
+	ctx, span := m.tracer.Start(ctx, "MailManager.cleanup", trace.WithNewRoot())
+	start := time.Now()
+	count, err := m.DeleteExpiredMails(ctx)
+	span.SetAttributes(AttrMailCount.Int(count))
+	endSpan(span, err)
+	if err != nil {
+		m.logger.ErrorContext(ctx, "automatic mail cleanup failed",
+			"duration", time.Since(start),
+			"error", err,
+		)
+		return err
+	}
+
+	m.logger.InfoContext(ctx, "automatic mail cleanup finished",
+		"count", count,
+		"duration", time.Since(start),
+	)
+
+	return nil
+}
+
+// markAllAsReadEach marks a recipient's unread mails as read one at a time, applying the retention policy to each
+func (m *DefaultMailManager) markAllAsReadEach(ctx context.Context, recipientID string) (int, error) {
+	readStatus := false
+	filter := &MailFilter{RecipientID: recipientID, ReadStatus: &readStatus}
+
+	count := 0
+	for {
+		// Marked mails drop out of the filter, so always read the first page
+		mails, _, err := m.store.QueryMails(ctx, filter, 1, 100)
+		if err != nil {
+			return count, err
+		}
+		if len(mails) == 0 {
+			return count, nil
+		}
+
+		for _, mail := range mails {
+			_, err := m.UpdateMailWithRetry(ctx, mail.ID, func(mail *Mail) (bool, error) {
+				if mail.ReadStatus {
+					return false, nil
 				}
-			case <-m.cleanupStop:
-				return
+				mail.ReadStatus = true
+				mail.ReadTime = m.clock.Now()
+				mail.ExpireTime = m.retention.ExpireTime(mail)
+				return true, nil
+			})
+			if err != nil && !errors.Is(err, ErrMailNotFound) {
+				return count, err
 			}
+			count++
 		}
-	}()
+	}
+}
 
+// beforeExpiredDelete renews the lease of a cleanup job run and calls the expiry hooks before a batch is deleted
+func (m *DefaultMailManager) beforeExpiredDelete(ctx context.Context, mails []*Mail) error {
+	if err := m.renewJobLease(ctx); err != nil {
+		return err
+	}
+	return m.runExpiryHooks(ctx, mails)
+}
+
+// runExpiryHooks calls every expiry hook in order, stopping at the first error so the batch is kept
+func (m *DefaultMailManager) runExpiryHooks(ctx context.Context, mails []*Mail) error {
+	for _, hook := range m.expiryHooks {
+		if err := hook(ctx, mails); err != nil {
+			m.logger.WarnContext(ctx, "expiry hook failed, keeping expired mails",
+				"count", len(mails),
+				"error", err,
+			)
+			return err
+		}
+	}
 	return nil
 }
 
-// ExportMailLogs exports mail logs based on filter
-func (m *DefaultMailManager) ExportMailLogs(ctx context.Context, filter *MailFilter) (string, error) {
-	if filter == nil {
-		filter = &MailFilter{}
+// startSpan starts a span for a manager method
+func (m *DefaultMailManager) startSpan(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return m.tracer.Start(ctx, "MailManager."+method, trace.WithAttributes(attrs...))
+}
+
+// logStoreError logs a failed store operation, ignoring missing mails which are expected
+func (m *DefaultMailManager) logStoreError(ctx context.Context, op string, err error, attrs ...any) {
+	if errors.Is(err, ErrMailNotFound) {
+		return
 	}
 
-	return m.store.ExportMailLogs(ctx, filter)
+	attrs = append([]any{"op", op, "error", err}, attrs...)
+	m.logger.ErrorContext(ctx, "mail store operation failed", attrs...)
 }
 
 // prepareMailForSending sets default values for a mail before sending
 func (m *DefaultMailManager) prepareMailForSending(mail *Mail) {
-	now := time.Now()
+	now := m.clock.Now()
 
 	// Set creation time if not set
 	if mail.CreateTime.IsZero() {
@@ -322,4 +828,8 @@ func (m *DefaultMailManager) prepareMailForSending(mail *Mail) {
 
 	// Ensure read status is false for new mails
 	mail.ReadStatus = false
+	mail.ReadTime = time.Time{}
+
+	// Limit the lifetime of the mail by the retention policy
+	mail.ExpireTime = m.retention.ExpireTime(mail)
 }
@@ -1,11 +1,18 @@
 package inboxer
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weedbox/inboxer/inboxertest"
 )
 
 func TestNewDefaultMailManager(t *testing.T) {
@@ -14,7 +21,7 @@ func TestNewDefaultMailManager(t *testing.T) {
 
 	assert.NotNil(t, manager)
 	assert.NotNil(t, manager.store)
-	assert.NotNil(t, manager.cleanupStop)
+	assert.NotNil(t, manager.jobs)
 }
 
 func TestSendMail(t *testing.T) {
@@ -553,14 +560,15 @@ func TestDeleteMailsByRecipient(t *testing.T) {
 }
 
 func TestDeleteExpiredMails(t *testing.T) {
-	// Initialize store and manager
-	store := NewMemoryMailStore()
-	manager := NewDefaultMailManager(store)
+	// Initialize store and manager with a fake clock
+	clock := inboxertest.NewFakeClock(time.Now())
+	store := NewMemoryMailStore(WithStoreClock(clock))
+	manager := NewDefaultMailManager(store, WithClock(clock))
 	ctx := context.Background()
 
 	// Create mails with different expiration times
-	now := time.Now()
-	pastExpiry := now.Add(-1 * time.Hour)
+	now := clock.Now()
+	soonExpiry := now.Add(1 * time.Hour)
 	futureExpiry := now.Add(24 * time.Hour)
 
 	mails := []*Mail{
@@ -568,13 +576,13 @@ func TestDeleteExpiredMails(t *testing.T) {
 			SenderID:    "system",
 			RecipientID: "user1",
 			Title:       "Expired Mail 1",
-			ExpireTime:  pastExpiry,
+			ExpireTime:  soonExpiry,
 		},
 		{
 			SenderID:    "system",
 			RecipientID: "user1",
 			Title:       "Expired Mail 2",
-			ExpireTime:  pastExpiry,
+			ExpireTime:  soonExpiry,
 		},
 		{
 			SenderID:    "system",
@@ -595,9 +603,15 @@ func TestDeleteExpiredMails(t *testing.T) {
 		assert.NoError(t, err)
 	}
 
-	// Delete expired mails
+	// Nothing has expired yet
 	count, err := manager.DeleteExpiredMails(ctx)
 	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// Delete expired mails once their expiration time has passed
+	clock.Advance(2 * time.Hour)
+	count, err = manager.DeleteExpiredMails(ctx)
+	assert.NoError(t, err)
 	assert.Equal(t, 2, count)
 
 	// Verify only expired mails are deleted
@@ -753,28 +767,160 @@ func TestCountMailsWithAttachments(t *testing.T) {
 	assert.Error(t, err)
 }
 
-func TestScheduleCleanup(t *testing.T) {
-	// Initialize store and manager
+func TestManagerCountBy(t *testing.T) {
 	store := NewMemoryMailStore()
 	manager := NewDefaultMailManager(store)
 	ctx := context.Background()
 
-	// Create expired mails
-	now := time.Now()
-	pastExpiry := now.Add(-1 * time.Hour)
+	_, err := manager.SendBatchMail(ctx, &Mail{SenderID: "system", Title: "Event", Tags: []string{"event"}}, []string{"user1", "user2"})
+	require.NoError(t, err)
+	_, err = manager.SendMail(ctx, &Mail{SenderID: "player1", RecipientID: "user1", Title: "Hi"})
+	require.NoError(t, err)
+
+	// A nil filter counts every mail
+	groups, err := manager.CountBy(ctx, nil, GroupBySender)
+	assert.NoError(t, err)
+	assert.Equal(t, []GroupCount{{Key: "player1", Count: 1}, {Key: "system", Count: 2}}, groups)
+
+	groups, err = manager.CountBy(ctx, &MailFilter{RecipientID: "user1"}, GroupByTag)
+	assert.NoError(t, err)
+	assert.Equal(t, []GroupCount{{Key: "event", Count: 1}}, groups)
+
+	_, err = manager.CountBy(ctx, nil, "unknown")
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+func TestFolders(t *testing.T) {
+	store := NewMemoryMailStore()
+	manager := NewDefaultMailManager(store)
+	ctx := context.Background()
+
+	ids, err := manager.SendBatchMail(ctx, &Mail{SenderID: "system", Title: "Reward", Folder: FolderRewards}, []string{"user1", "user2"})
+	require.NoError(t, err)
+	noticeID, err := manager.SendMail(ctx, &Mail{SenderID: "system", RecipientID: "user1", Title: "Notice"})
+	require.NoError(t, err)
+	_, err = manager.SendMail(ctx, &Mail{SenderID: "player1", RecipientID: "user1", Title: "Hi", Folder: FolderSocial})
+	require.NoError(t, err)
+
+	mails, total, err := manager.GetMailsByFolder(ctx, "user1", FolderRewards, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, ids[0], mails[0].ID)
+
+	counts, err := manager.CountUnreadByFolder(ctx, "user1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{FolderInbox: 1, FolderRewards: 1, FolderSocial: 1}, counts)
+
+	// Read mails are not counted
+	require.NoError(t, manager.MarkAsRead(ctx, noticeID))
+	count, err := manager.MoveToFolder(ctx, []string{ids[0], noticeID, "missing"}, FolderArchive)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	counts, err = manager.CountUnreadByFolder(ctx, "user1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{FolderArchive: 1, FolderSocial: 1}, counts)
+
+	_, total, err = manager.GetMailsByFolder(ctx, "user1", FolderArchive, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+
+	count, err = manager.MoveToFolder(ctx, nil, FolderArchive)
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+	_, err = manager.MoveToFolder(ctx, ids, "")
+	assert.Error(t, err)
+	_, _, err = manager.GetMailsByFolder(ctx, "user1", "", 1, 10)
+	assert.Error(t, err)
+	_, err = manager.CountUnreadByFolder(ctx, "")
+	assert.Error(t, err)
+}
+
+func TestMailFlags(t *testing.T) {
+	store := NewMemoryMailStore()
+	manager := NewDefaultMailManager(store)
+	ctx := context.Background()
+
+	oldID, err := manager.SendMail(ctx, &Mail{SenderID: "system", RecipientID: "user1", Title: "Old", CreateTime: time.Now().Add(-time.Hour), Folder: FolderRewards})
+	require.NoError(t, err)
+	newID, err := manager.SendMail(ctx, &Mail{SenderID: "system", RecipientID: "user1", Title: "New", Folder: FolderRewards, ExpireTime: time.Now().Add(time.Millisecond)})
+	require.NoError(t, err)
+
+	// Pinned mails are listed first
+	require.NoError(t, manager.SetPinned(ctx, oldID, true))
+	mails, _, err := manager.GetMailsByRecipient(ctx, "user1", 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{oldID, newID}, mailIDs(mails))
+
+	// Setting a flag to its current value doesn't update the mail
+	require.NoError(t, manager.SetPinned(ctx, oldID, true))
+	mail, err := manager.GetMailByID(ctx, oldID)
+	require.NoError(t, err)
+	assert.True(t, mail.Pinned)
+	assert.Equal(t, int64(2), mail.Version)
+
+	// Archived mails are left out of listings and unread counts
+	require.NoError(t, manager.SetArchived(ctx, oldID, true))
+	_, total, err := manager.GetMailsByRecipient(ctx, "user1", 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+	_, total, err = manager.GetMailsByFolder(ctx, "user1", FolderRewards, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+	counts, err := manager.CountUnreadByFolder(ctx, "user1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{FolderRewards: 1}, counts)
+	mails, total, err = manager.GetArchivedMails(ctx, "user1", 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, oldID, mails[0].ID)
+
+	require.NoError(t, manager.SetArchived(ctx, oldID, false))
+	_, total, err = manager.GetMailsByRecipient(ctx, "user1", 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+
+	// Starred mails survive expiry cleanup
+	require.NoError(t, manager.SetStarred(ctx, newID, true))
+	time.Sleep(5 * time.Millisecond)
+	count, err := manager.DeleteExpiredMails(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	require.NoError(t, manager.SetStarred(ctx, newID, false))
+	count, err = manager.DeleteExpiredMails(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	assert.Error(t, manager.SetStarred(ctx, "", true))
+	assert.ErrorIs(t, manager.SetPinned(ctx, "missing", true), ErrMailNotFound)
+	_, _, err = manager.GetArchivedMails(ctx, "", 1, 10)
+	assert.Error(t, err)
+}
+
+func TestScheduleCleanup(t *testing.T) {
+	// Initialize store and manager with a fake clock
+	clock := inboxertest.NewFakeClock(time.Now())
+	store := NewMemoryMailStore(WithStoreClock(clock))
+	manager := NewDefaultMailManager(store, WithClock(clock))
+	ctx := context.Background()
+
+	// Create mails that expire within the first cleanup interval
+	now := clock.Now()
+	soonExpiry := now.Add(30 * time.Minute)
 
 	mails := []*Mail{
 		{
 			SenderID:    "system",
 			RecipientID: "user1",
 			Title:       "Expired Mail 1",
-			ExpireTime:  pastExpiry,
+			ExpireTime:  soonExpiry,
 		},
 		{
 			SenderID:    "system",
 			RecipientID: "user1",
 			Title:       "Expired Mail 2",
-			ExpireTime:  pastExpiry,
+			ExpireTime:  soonExpiry,
 		},
 	}
 
@@ -784,12 +930,12 @@ func TestScheduleCleanup(t *testing.T) {
 		assert.NoError(t, err)
 	}
 
-	// Schedule cleanup with short duration
-	err := manager.ScheduleCleanup(ctx, 100*time.Millisecond)
+	// Schedule hourly cleanup
+	err := manager.ScheduleCleanup(ctx, time.Hour)
 	assert.NoError(t, err)
 
-	// Wait for cleanup to run
-	time.Sleep(200 * time.Millisecond)
+	// Let the cleanup run once
+	advanceJobs(clock, 1, time.Hour)
 
 	// Verify expired mails were deleted
 	allMails, total, err := manager.QueryMails(ctx, &MailFilter{}, 1, 10)
@@ -798,10 +944,7 @@ func TestScheduleCleanup(t *testing.T) {
 	assert.Empty(t, allMails)
 
 	// Clean up
-	if manager.cleanupTick != nil {
-		manager.cleanupTick.Stop()
-		manager.cleanupStop <- true
-	}
+	assert.NoError(t, manager.Close(ctx))
 
 	// Test with negative duration
 	err = manager.ScheduleCleanup(ctx, -1*time.Second)
@@ -862,3 +1005,286 @@ func TestExportMailLogs(t *testing.T) {
 	assert.Contains(t, playerLogsJSON, "Player Mail")
 	assert.NotContains(t, playerLogsJSON, "System Mail")
 }
+
+// conflictingStore simulates a concurrent writer by modifying the mail right after it is read
+type conflictingStore struct {
+	MailStore
+	conflicts int
+}
+
+func (s *conflictingStore) GetMail(ctx context.Context, mailID string) (*Mail, error) {
+	mail, err := s.MailStore.GetMail(ctx, mailID)
+	if err != nil || s.conflicts == 0 {
+		return mail, err
+	}
+
+	s.conflicts--
+	concurrent, _ := s.MailStore.GetMail(ctx, mailID)
+	concurrent.Tags = append(concurrent.Tags, "concurrent")
+	if err := s.MailStore.UpdateMail(ctx, concurrent); err != nil {
+		return nil, err
+	}
+
+	return mail, nil
+}
+
+func TestUpdateMailWithRetry(t *testing.T) {
+	ctx := context.Background()
+	store := &conflictingStore{MailStore: NewMemoryMailStore()}
+	manager := NewDefaultMailManager(store, WithMaxUpdateRetries(2))
+
+	id, err := manager.SendMail(ctx, &Mail{RecipientID: "user1", Title: "Test"})
+	assert.NoError(t, err)
+
+	// Conflicts within the retry budget are resolved without losing the concurrent change
+	store.conflicts = 2
+	assert.NoError(t, manager.MarkAsRead(ctx, id))
+
+	mail, err := manager.GetMailByID(ctx, id)
+	assert.NoError(t, err)
+	assert.True(t, mail.ReadStatus)
+	assert.Equal(t, []string{"concurrent", "concurrent"}, mail.Tags)
+
+	// Conflicts beyond the retry budget are reported
+	store.conflicts = 3
+	_, err = manager.UpdateMailWithRetry(ctx, id, func(mail *Mail) (bool, error) {
+		mail.Title = "Updated"
+		return true, nil
+	})
+	assert.ErrorIs(t, err, ErrVersionConflict)
+
+	// No write happens when the mutation reports no change
+	store.conflicts = 0
+	before, err := manager.GetMailByID(ctx, id)
+	assert.NoError(t, err)
+	_, err = manager.UpdateMailWithRetry(ctx, id, func(mail *Mail) (bool, error) {
+		return false, nil
+	})
+	assert.NoError(t, err)
+	after, err := manager.GetMailByID(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, before.Version, after.Version)
+
+	// Invalid arguments
+	_, err = manager.UpdateMailWithRetry(ctx, "", func(*Mail) (bool, error) { return true, nil })
+	assert.Error(t, err)
+	_, err = manager.UpdateMailWithRetry(ctx, id, nil)
+	assert.Error(t, err)
+}
+
+// newTestLogger returns a JSON logger writing every level into the returned buffer
+
+func TestUpdateMailWithRetryBacksOffOnClock(t *testing.T) {
+	ctx := context.Background()
+	clock := inboxertest.NewFakeClock(time.Now())
+	store := &conflictingStore{MailStore: NewMemoryMailStore()}
+	manager := NewDefaultMailManager(store, WithClock(clock), WithMaxUpdateRetries(2))
+
+	id, err := manager.SendMail(ctx, &Mail{RecipientID: "user1", Title: "Test"})
+	require.NoError(t, err)
+
+	// The retry waits for the injected clock to pass the backoff
+	store.conflicts = 1
+	done := make(chan error)
+	go func() {
+		done <- manager.MarkAsRead(ctx, id)
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Millisecond)
+	assert.NoError(t, <-done)
+	assert.Equal(t, 0, clock.Waiters())
+
+	// A cancelled context stops the backoff and removes its timer
+	store.conflicts = 1
+	cancelCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		_, err := manager.UpdateMailWithRetry(cancelCtx, id, func(mail *Mail) (bool, error) {
+			mail.Title = "Updated"
+			return true, nil
+		})
+		done <- err
+	}()
+
+	clock.BlockUntil(1)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Equal(t, 0, clock.Waiters())
+}
+func newTestLogger() (*slog.Logger, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	return slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})), buf
+}
+
+// logEntries decodes the JSON log lines in buf
+func logEntries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	entries := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// findLogEntry returns the first log entry with the given message
+func findLogEntry(entries []map[string]interface{}, msg string) map[string]interface{} {
+	for _, entry := range entries {
+		if entry["msg"] == msg {
+			return entry
+		}
+	}
+	return nil
+}
+
+func TestManagerLogging(t *testing.T) {
+	ctx := context.Background()
+	logger, buf := newTestLogger()
+	manager := NewDefaultMailManager(NewMemoryMailStore(), WithLogger(logger))
+
+	// Send
+	id, err := manager.SendMail(ctx, &Mail{SenderID: "system", RecipientID: "user1"})
+	assert.NoError(t, err)
+
+	// Batch send
+	_, err = manager.SendBatchMail(ctx, &Mail{SenderID: "system"}, []string{"user2", "user3"})
+	assert.NoError(t, err)
+
+	// Delete
+	assert.NoError(t, manager.DeleteMail(ctx, id))
+
+	// Cleanup
+	_, err = manager.DeleteExpiredMails(ctx)
+	assert.NoError(t, err)
+
+	entries := logEntries(t, buf)
+
+	sent := findLogEntry(entries, "mail sent")
+	if assert.NotNil(t, sent) {
+		assert.Equal(t, id, sent["mail_id"])
+		assert.Equal(t, "user1", sent["recipient_id"])
+		assert.Contains(t, sent, "duration")
+	}
+
+	batch := findLogEntry(entries, "batch mail sent")
+	if assert.NotNil(t, batch) {
+		assert.Equal(t, float64(2), batch["count"])
+	}
+
+	deleted := findLogEntry(entries, "mail deleted")
+	if assert.NotNil(t, deleted) {
+		assert.Equal(t, id, deleted["mail_id"])
+	}
+
+	cleanup := findLogEntry(entries, "expired mails deleted")
+	if assert.NotNil(t, cleanup) {
+		assert.Equal(t, float64(0), cleanup["count"])
+		assert.Contains(t, cleanup, "duration")
+	}
+}
+
+func TestManagerLoggingStoreErrors(t *testing.T) {
+	ctx := context.Background()
+	logger, buf := newTestLogger()
+	store := setupGormMailStore(t)
+	manager := NewDefaultMailManager(store, WithLogger(logger))
+
+	// Break the store by dropping its table
+	assert.NoError(t, store.db.Migrator().DropTable(&MailEntity{}))
+
+	_, err := manager.SendMail(ctx, &Mail{RecipientID: "user1"})
+	assert.Error(t, err)
+
+	entry := findLogEntry(logEntries(t, buf), "mail store operation failed")
+	if assert.NotNil(t, entry) {
+		assert.Equal(t, "ERROR", entry["level"])
+		assert.Equal(t, "send mail", entry["op"])
+		assert.Equal(t, "user1", entry["recipient_id"])
+		assert.Contains(t, entry, "error")
+	}
+
+	// Missing mails are not reported as errors
+	buf.Reset()
+	assert.NoError(t, store.db.AutoMigrate(&MailEntity{}))
+	err = manager.DeleteMail(ctx, "missing")
+	assert.ErrorIs(t, err, ErrMailNotFound)
+	assert.Nil(t, findLogEntry(logEntries(t, buf), "mail store operation failed"))
+}
+
+func TestDeleteExpiredMailsProgress(t *testing.T) {
+	ctx := context.Background()
+	logger, buf := newTestLogger()
+	store := NewMemoryMailStore(WithDeleteBatchSize(2))
+	manager := NewDefaultMailManager(store, WithLogger(logger))
+
+	for i := 0; i < 5; i++ {
+		_, err := manager.SendMail(ctx, &Mail{RecipientID: "user1", ExpireTime: time.Now().Add(-time.Hour)})
+		require.NoError(t, err)
+	}
+
+	count, err := manager.DeleteExpiredMails(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, count)
+
+	// Every batch is reported
+	progress := []map[string]interface{}{}
+	for _, entry := range logEntries(t, buf) {
+		if entry["msg"] == "expired mail deletion progress" {
+			progress = append(progress, entry)
+		}
+	}
+	require.Len(t, progress, 3)
+	assert.Equal(t, float64(3), progress[2]["batch"])
+	assert.Equal(t, float64(1), progress[2]["count"])
+	assert.Equal(t, float64(5), progress[2]["total"])
+}
+
+func TestExpiryHooks(t *testing.T) {
+	ctx := context.Background()
+	granted := map[string]interface{}{}
+	failing := true
+
+	// The first hook grants unclaimed attachments, the second one can block the deletion
+	manager := NewDefaultMailManager(NewMemoryMailStore(),
+		WithExpiryHook(func(ctx context.Context, mails []*Mail) error {
+			for _, mail := range mails {
+				for key, value := range mail.Attachments {
+					granted[mail.RecipientID+":"+key] = value
+				}
+			}
+			return nil
+		}),
+		WithExpiryHook(func(ctx context.Context, mails []*Mail) error {
+			if failing {
+				return errors.New("archive unavailable")
+			}
+			return nil
+		}),
+	)
+
+	_, err := manager.SendMail(ctx, &Mail{
+		RecipientID: "user1",
+		Attachments: map[string]interface{}{"coins": 100},
+		ExpireTime:  time.Now().Add(-time.Hour),
+	})
+	require.NoError(t, err)
+
+	// A failing hook keeps the mail
+	count, err := manager.DeleteExpiredMails(ctx)
+	assert.ErrorContains(t, err, "archive unavailable")
+	assert.Equal(t, 0, count)
+	assert.Equal(t, 100, granted["user1:coins"])
+
+	_, total, err := manager.GetMailsByRecipient(ctx, "user1", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+
+	// Once every hook succeeds, the mail is deleted
+	failing = false
+	count, err = manager.DeleteExpiredMails(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
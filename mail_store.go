@@ -2,29 +2,86 @@ package inboxer
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
+var (
+	// ErrMailNotFound is returned when a mail does not exist in the store
+	ErrMailNotFound = errors.New("mail not found")
+
+	// ErrVersionConflict is returned by UpdateMail when the mail was modified after it was read
+	ErrVersionConflict = errors.New("mail version conflict")
+)
+
+// DefaultDeleteBatchSize is the default number of mails removed per batch when deleting expired mails
+const DefaultDeleteBatchSize = 500
+
+// ExpiryHook is called with a batch of expired mails before they are deleted.
+// Returning an error keeps the whole batch in the store.
+type ExpiryHook func(ctx context.Context, mails []*Mail) error
+
+// ExpiredDeleteOptions controls batched deletion of expired mails
+type ExpiredDeleteOptions struct {
+	BatchSize    int                         // Mails per batch, 0 uses the store default
+	Pause        time.Duration               // Pause between batches, 0 uses the store default
+	OnProgress   func(ExpiredDeleteProgress) // Called after every batch
+	BeforeDelete ExpiryHook                  // Called with every batch before it is deleted
+}
+
+// ExpiredDeleteProgress reports the progress of a batched expired mail deletion
+type ExpiredDeleteProgress struct {
+	Batch   int // Batch number, starting at 1
+	Deleted int // Mails deleted in this batch
+	Skipped int // Mails kept in this batch because BeforeDelete failed
+	Total   int // Mails deleted so far
+}
+
+// DefaultScanBatchSize is the default number of mails returned per page when scanning
+const DefaultScanBatchSize = 500
+
+// ScanOptions controls cursor paging through mails with ScanMails
+type ScanOptions struct {
+	AfterID   string // Only scan mails with an ID greater than this, used to resume a scan
+	BatchSize int    // Mails per page, 0 uses DefaultScanBatchSize
+}
+
+// withDefaults fills unset scan options
+func (o ScanOptions) withDefaults() ScanOptions {
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultScanBatchSize
+	}
+	return o
+}
+
 // MailStore defines the interface for mail storage, used for persistent storage of mail data
 type MailStore interface {
 	// Basic CRUD operations
 	CreateMail(ctx context.Context, mail *Mail) (string, error)
 	GetMail(ctx context.Context, mailID string) (*Mail, error)
-	UpdateMail(ctx context.Context, mail *Mail) error
+	UpdateMail(ctx context.Context, mail *Mail) error // Fails with ErrVersionConflict if mail.Version is stale
 	DeleteMail(ctx context.Context, mailID string) error
 
 	// Batch operations
 	CreateBatchMails(ctx context.Context, mails []*Mail) ([]string, error)
 	DeleteMailsByRecipient(ctx context.Context, recipientID string) error
-	DeleteExpiredMails(ctx context.Context, beforeTime time.Time) (int, error)
+	DeleteExpiredMails(ctx context.Context, beforeTime time.Time) (int, error)                                   // Starred mails are kept
+	DeleteExpiredMailsBatched(ctx context.Context, beforeTime time.Time, opts ExpiredDeleteOptions) (int, error) // Returns the mails deleted so far on error, and the joined errors of failed BeforeDelete calls
+
+	// Bulk operations, returning the number of affected mails
+	UpdateByFilter(ctx context.Context, filter *MailFilter, patch *MailPatch) (int, error)
+	DeleteByFilter(ctx context.Context, filter *MailFilter) (int, error)
 
 	// Query operations
-	GetMailsByRecipient(ctx context.Context, recipientID string, page, size int) ([]*Mail, int, error)
+	GetMailsByRecipient(ctx context.Context, recipientID string, page, size int, order ...SortOrder) ([]*Mail, int, error) // Leaves out archived mails, pinned mails first, then in the optional order (SortNewest by default)
 	QueryMails(ctx context.Context, filter *MailFilter, page, size int) ([]*Mail, int, error)
+	ListExpiringBetween(ctx context.Context, recipientID string, from, to time.Time) ([]*Mail, error)        // Expire time in [from, to), soonest first, starred mails excluded; empty recipientID means all recipients
+	ScanMails(ctx context.Context, filter *MailFilter, opts ScanOptions, fn func(mails []*Mail) error) error // Calls fn with pages of matching mails in ID order, stopping at the first error
 
 	// Count operations
-	CountUnreadMails(ctx context.Context, recipientID string) (int, error)
+	CountUnreadMails(ctx context.Context, recipientID string) (int, error) // Archived mails excluded
 	CountMailsWithAttachments(ctx context.Context, recipientID string) (int, error)
+	CountBy(ctx context.Context, filter *MailFilter, groupBy GroupBy) ([]GroupCount, error) // Counts matching mails per group, ordered by group key
 
 	// System operations
 	ExportMailLogs(ctx context.Context, filter *MailFilter) (string, error)
@@ -0,0 +1,234 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/weedbox/inboxer"
+)
+
+// MailManager wraps an inboxer.MailManager and records metrics for every call
+type MailManager struct {
+	next    inboxer.MailManager
+	metrics *Metrics
+}
+
+var _ inboxer.MailManager = (*MailManager)(nil)
+
+// WrapManager returns an instrumented MailManager that delegates to next
+func (m *Metrics) WrapManager(next inboxer.MailManager) *MailManager {
+	return &MailManager{
+		next:    next,
+		metrics: m,
+	}
+}
+
+// JobHooks returns the hooks that record the scheduled job state and cleanup deletions.
+// Pass them to the manager with inboxer.WithJobHooks so jobs started by Start and every cleanup run are covered.
+func (m *Metrics) JobHooks() inboxer.JobHooks {
+	return inboxer.JobHooks{
+		OnStart: func(job string, interval time.Duration) {
+			m.scheduledJobs.WithLabelValues(job).Set(1)
+			m.scheduledInterval.WithLabelValues(job).Set(interval.Seconds())
+		},
+		OnStop: func(job string) {
+			m.scheduledJobs.WithLabelValues(job).Set(0)
+		},
+		OnCleanup: func(ctx context.Context, deleted int, err error) {
+			m.expiredDeleted.Add(float64(deleted))
+		},
+	}
+}
+
+// SendMail sends a single mail and counts it by tag
+func (m *MailManager) SendMail(ctx context.Context, mail *inboxer.Mail) (id string, err error) {
+	defer m.observe("SendMail", time.Now(), &err)
+	id, err = m.next.SendMail(ctx, mail)
+	if err == nil {
+		m.metrics.recordSent(mail.Tags, 1)
+	}
+	return id, err
+}
+
+// SendBatchMail sends the same mail content to multiple recipients and records the batch size
+func (m *MailManager) SendBatchMail(ctx context.Context, mail *inboxer.Mail, recipientIDs []string) (ids []string, err error) {
+	defer m.observe("SendBatchMail", time.Now(), &err)
+	m.metrics.batchSize.Observe(float64(len(recipientIDs)))
+	ids, err = m.next.SendBatchMail(ctx, mail, recipientIDs)
+	if err == nil {
+		m.metrics.recordSent(mail.Tags, len(ids))
+	}
+	return ids, err
+}
+
+// SendSystemAnnouncement sends a system announcement and counts it by tag
+func (m *MailManager) SendSystemAnnouncement(ctx context.Context, mail *inboxer.Mail) (id string, err error) {
+	defer m.observe("SendSystemAnnouncement", time.Now(), &err)
+	id, err = m.next.SendSystemAnnouncement(ctx, mail)
+	if err == nil {
+		m.metrics.recordSent(mail.Tags, 1)
+	}
+	return id, err
+}
+
+// GetMailByID gets a mail by ID
+func (m *MailManager) GetMailByID(ctx context.Context, mailID string) (mail *inboxer.Mail, err error) {
+	defer m.observe("GetMailByID", time.Now(), &err)
+	return m.next.GetMailByID(ctx, mailID)
+}
+
+// GetMailsByRecipient gets a user's mails with pagination
+func (m *MailManager) GetMailsByRecipient(ctx context.Context, recipientID string, page, size int, order ...inboxer.SortOrder) (mails []*inboxer.Mail, total int, err error) {
+	defer m.observe("GetMailsByRecipient", time.Now(), &err)
+	return m.next.GetMailsByRecipient(ctx, recipientID, page, size, order...)
+}
+
+// QueryMails queries mails by conditions with pagination
+func (m *MailManager) QueryMails(ctx context.Context, filter *inboxer.MailFilter, page, size int) (mails []*inboxer.Mail, total int, err error) {
+	defer m.observe("QueryMails", time.Now(), &err)
+	return m.next.QueryMails(ctx, filter, page, size)
+}
+
+// ListExpiringSoon gets a user's mails expiring within the given duration
+func (m *MailManager) ListExpiringSoon(ctx context.Context, recipientID string, within time.Duration) (mails []*inboxer.Mail, err error) {
+	defer m.observe("ListExpiringSoon", time.Now(), &err)
+	return m.next.ListExpiringSoon(ctx, recipientID, within)
+}
+
+// MarkAsRead marks a mail as read
+func (m *MailManager) MarkAsRead(ctx context.Context, mailID string) (err error) {
+	defer m.observe("MarkAsRead", time.Now(), &err)
+	return m.next.MarkAsRead(ctx, mailID)
+}
+
+// GetMailsByFolder gets a user's mails in a folder with pagination
+func (m *MailManager) GetMailsByFolder(ctx context.Context, recipientID, folder string, page, size int) (mails []*inboxer.Mail, total int, err error) {
+	defer m.observe("GetMailsByFolder", time.Now(), &err)
+	return m.next.GetMailsByFolder(ctx, recipientID, folder, page, size)
+}
+
+// GetArchivedMails gets a user's archived mails with pagination
+func (m *MailManager) GetArchivedMails(ctx context.Context, recipientID string, page, size int) (mails []*inboxer.Mail, total int, err error) {
+	defer m.observe("GetArchivedMails", time.Now(), &err)
+	return m.next.GetArchivedMails(ctx, recipientID, page, size)
+}
+
+// MarkAllAsRead marks all user's mails as read
+func (m *MailManager) MarkAllAsRead(ctx context.Context, recipientID string) (err error) {
+	defer m.observe("MarkAllAsRead", time.Now(), &err)
+	return m.next.MarkAllAsRead(ctx, recipientID)
+}
+
+// MoveToFolder moves mails to a folder
+func (m *MailManager) MoveToFolder(ctx context.Context, mailIDs []string, folder string) (count int, err error) {
+	defer m.observe("MoveToFolder", time.Now(), &err)
+	return m.next.MoveToFolder(ctx, mailIDs, folder)
+}
+
+// SetStarred stars or unstars a mail
+func (m *MailManager) SetStarred(ctx context.Context, mailID string, starred bool) (err error) {
+	defer m.observe("SetStarred", time.Now(), &err)
+	return m.next.SetStarred(ctx, mailID, starred)
+}
+
+// SetPinned pins or unpins a mail
+func (m *MailManager) SetPinned(ctx context.Context, mailID string, pinned bool) (err error) {
+	defer m.observe("SetPinned", time.Now(), &err)
+	return m.next.SetPinned(ctx, mailID, pinned)
+}
+
+// SetArchived archives or unarchives a mail
+func (m *MailManager) SetArchived(ctx context.Context, mailID string, archived bool) (err error) {
+	defer m.observe("SetArchived", time.Now(), &err)
+	return m.next.SetArchived(ctx, mailID, archived)
+}
+
+// DeleteMail deletes a mail
+func (m *MailManager) DeleteMail(ctx context.Context, mailID string) (err error) {
+	defer m.observe("DeleteMail", time.Now(), &err)
+	return m.next.DeleteMail(ctx, mailID)
+}
+
+// DeleteMailsByRecipient deletes all user's mails
+func (m *MailManager) DeleteMailsByRecipient(ctx context.Context, recipientID string) (err error) {
+	defer m.observe("DeleteMailsByRecipient", time.Now(), &err)
+	return m.next.DeleteMailsByRecipient(ctx, recipientID)
+}
+
+// DeleteExpiredMails deletes all expired mails
+func (m *MailManager) DeleteExpiredMails(ctx context.Context) (count int, err error) {
+	defer m.observe("DeleteExpiredMails", time.Now(), &err)
+	return m.next.DeleteExpiredMails(ctx)
+}
+
+// CountUnreadMails counts unread mails for a recipient
+func (m *MailManager) CountUnreadMails(ctx context.Context, recipientID string) (count int, err error) {
+	defer m.observe("CountUnreadMails", time.Now(), &err)
+	return m.next.CountUnreadMails(ctx, recipientID)
+}
+
+// CountMailsWithAttachments counts mails with attachments for a recipient
+func (m *MailManager) CountMailsWithAttachments(ctx context.Context, recipientID string) (count int, err error) {
+	defer m.observe("CountMailsWithAttachments", time.Now(), &err)
+	return m.next.CountMailsWithAttachments(ctx, recipientID)
+}
+
+// CountBy counts the mails matching the filter per group
+func (m *MailManager) CountBy(ctx context.Context, filter *inboxer.MailFilter, groupBy inboxer.GroupBy) (groups []inboxer.GroupCount, err error) {
+	defer m.observe("CountBy", time.Now(), &err)
+	return m.next.CountBy(ctx, filter, groupBy)
+}
+
+// CountUnreadByFolder counts a recipient's unread mails per folder
+func (m *MailManager) CountUnreadByFolder(ctx context.Context, recipientID string) (counts map[string]int, err error) {
+	defer m.observe("CountUnreadByFolder", time.Now(), &err)
+	return m.next.CountUnreadByFolder(ctx, recipientID)
+}
+
+// ScheduleCleanup sets up automatic cleanup
+func (m *MailManager) ScheduleCleanup(ctx context.Context, duration time.Duration) (err error) {
+	defer m.observe("ScheduleCleanup", time.Now(), &err)
+	return m.next.ScheduleCleanup(ctx, duration)
+}
+
+// ScheduleExpiryNotifications sets up expiring soon notifications
+func (m *MailManager) ScheduleExpiryNotifications(ctx context.Context, interval, within time.Duration) (err error) {
+	defer m.observe("ScheduleExpiryNotifications", time.Now(), &err)
+	return m.next.ScheduleExpiryNotifications(ctx, interval, within)
+}
+
+// ExportMailLogs exports mail logs based on filter
+func (m *MailManager) ExportMailLogs(ctx context.Context, filter *inboxer.MailFilter) (logs string, err error) {
+	defer m.observe("ExportMailLogs", time.Now(), &err)
+	return m.next.ExportMailLogs(ctx, filter)
+}
+
+// ExportMails streams mails matching the filter to w in the selected format
+func (m *MailManager) ExportMails(ctx context.Context, w io.Writer, filter *inboxer.MailFilter, opts inboxer.ExportOptions) (count int, err error) {
+	defer m.observe("ExportMails", time.Now(), &err)
+	return m.next.ExportMails(ctx, w, filter, opts)
+}
+
+// ImportMails restores mails exported as JSON or NDJSON
+func (m *MailManager) ImportMails(ctx context.Context, r io.Reader, opts inboxer.ImportOptions) (report *inboxer.ImportReport, err error) {
+	defer m.observe("ImportMails", time.Now(), &err)
+	return m.next.ImportMails(ctx, r, opts)
+}
+
+// Start starts configured background workers
+func (m *MailManager) Start(ctx context.Context) (err error) {
+	defer m.observe("Start", time.Now(), &err)
+	return m.next.Start(ctx)
+}
+
+// Close stops all background workers
+func (m *MailManager) Close(ctx context.Context) (err error) {
+	defer m.observe("Close", time.Now(), &err)
+	return m.next.Close(ctx)
+}
+
+// observe records a manager call once it has returned
+func (m *MailManager) observe(method string, start time.Time, err *error) {
+	m.metrics.observeManager(method, start, *err)
+}
@@ -0,0 +1,170 @@
+// Package metrics provides Prometheus instrumentation for inboxer mail stores and managers
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "inboxer"
+
+// Tag label values for mails without tags and for tags outside the allow-list
+const (
+	untaggedLabel = "none"
+	otherTagLabel = "other"
+)
+
+// Metrics holds the Prometheus collectors shared by the instrumented store and manager
+type Metrics struct {
+	storeDuration     *prometheus.HistogramVec
+	storeErrors       *prometheus.CounterVec
+	managerDuration   *prometheus.HistogramVec
+	managerErrors     *prometheus.CounterVec
+	mailsSent         *prometheus.CounterVec
+	batchSize         prometheus.Histogram
+	expiredDeleted    prometheus.Counter
+	scheduledJobs     *prometheus.GaugeVec
+	scheduledInterval *prometheus.GaugeVec
+	tagLabels         map[string]bool // Tags counted under their own label
+}
+
+// Option configures Metrics
+type Option func(*Metrics)
+
+// WithTagLabels sets the tags that get their own label in mails_sent_total.
+// Other tags are counted as "other", which keeps the number of series bounded.
+func WithTagLabels(tags ...string) Option {
+	return func(m *Metrics) {
+		for _, tag := range tags {
+			m.tagLabels[tag] = true
+		}
+	}
+}
+
+// New creates the inboxer collectors and registers them with the given registerer
+func New(reg prometheus.Registerer, opts ...Option) (*Metrics, error) {
+	if reg == nil {
+		return nil, errors.New("prometheus registerer cannot be nil")
+	}
+
+	m := &Metrics{
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "store",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of MailStore operations.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		storeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "store",
+			Name:      "operation_errors_total",
+			Help:      "Number of failed MailStore operations.",
+		}, []string{"method"}),
+		managerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "manager",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of MailManager operations.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		managerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "manager",
+			Name:      "operation_errors_total",
+			Help:      "Number of failed MailManager operations.",
+		}, []string{"method"}),
+		mailsSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "mails_sent_total",
+			Help:      "Number of mails sent, by tag.",
+		}, []string{"tag"}),
+		batchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "batch_size",
+			Help:      "Number of recipients per batch send.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		}),
+		expiredDeleted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "expired_mails_deleted_total",
+			Help:      "Number of expired mails removed by cleanup.",
+		}),
+		scheduledJobs: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "scheduled_job_active",
+			Help:      "Whether a scheduled background job is active (1) or not (0).",
+		}, []string{"job"}),
+		scheduledInterval: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "scheduled_job_interval_seconds",
+			Help:      "Interval of scheduled background jobs.",
+		}, []string{"job"}),
+		tagLabels: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(m)
+		}
+	}
+
+	collectors := []prometheus.Collector{
+		m.storeDuration,
+		m.storeErrors,
+		m.managerDuration,
+		m.managerErrors,
+		m.mailsSent,
+		m.batchSize,
+		m.expiredDeleted,
+		m.scheduledJobs,
+		m.scheduledInterval,
+	}
+	for _, collector := range collectors {
+		if err := reg.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// observeStore records the latency and outcome of a store operation
+func (m *Metrics) observeStore(method string, start time.Time, err error) {
+	m.storeDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.storeErrors.WithLabelValues(method).Inc()
+	}
+}
+
+// observeManager records the latency and outcome of a manager operation
+func (m *Metrics) observeManager(method string, start time.Time, err error) {
+	m.managerDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.managerErrors.WithLabelValues(method).Inc()
+	}
+}
+
+// recordSent counts sent mails once per tag label
+func (m *Metrics) recordSent(tags []string, count int) {
+	if count <= 0 {
+		return
+	}
+	if len(tags) == 0 {
+		m.mailsSent.WithLabelValues(untaggedLabel).Add(float64(count))
+		return
+	}
+
+	labels := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		if !m.tagLabels[tag] {
+			tag = otherTagLabel
+		}
+		if labels[tag] {
+			continue
+		}
+		labels[tag] = true
+		m.mailsSent.WithLabelValues(tag).Add(float64(count))
+	}
+}
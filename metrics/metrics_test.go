@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weedbox/inboxer"
+	"github.com/weedbox/inboxer/inboxertest"
+)
+
+// setupMetrics creates metrics registered with a fresh registry
+func setupMetrics(t *testing.T) (*Metrics, *prometheus.Registry) {
+	reg := prometheus.NewRegistry()
+	m, err := New(reg)
+	require.NoError(t, err)
+	return m, reg
+}
+
+func TestNew(t *testing.T) {
+	// Test with nil registerer
+	_, err := New(nil)
+	assert.Error(t, err)
+
+	// Registering twice with the same registry fails
+	reg := prometheus.NewRegistry()
+	_, err = New(reg)
+	assert.NoError(t, err)
+	_, err = New(reg)
+	assert.Error(t, err)
+}
+
+func TestMailStore(t *testing.T) {
+	m, reg := setupMetrics(t)
+	store := m.WrapStore(inboxer.NewMemoryMailStore())
+	ctx := context.Background()
+
+	now := time.Now()
+	_, err := store.CreateMail(ctx, &inboxer.Mail{RecipientID: "user1", ExpireTime: now.Add(-time.Hour)})
+	assert.NoError(t, err)
+	_, err = store.CreateBatchMails(ctx, []*inboxer.Mail{
+		{RecipientID: "user2", ExpireTime: now.Add(-time.Hour)},
+		{RecipientID: "user3"},
+	})
+	assert.NoError(t, err)
+
+	// Errors are counted per method
+	_, err = store.GetMail(ctx, "missing")
+	assert.Error(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.storeErrors.WithLabelValues("GetMail")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.storeErrors.WithLabelValues("CreateMail")))
+
+	count, err := store.DeleteExpiredMails(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// Latency is observed for each method
+	histograms, err := testutil.GatherAndCount(reg, "inboxer_store_operation_duration_seconds")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, histograms)
+}
+
+func TestMailManager(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(reg, WithTagLabels("reward", "event"))
+	require.NoError(t, err)
+	manager := m.WrapManager(inboxer.NewDefaultMailManager(inboxer.NewMemoryMailStore(), inboxer.WithJobHooks(m.JobHooks())))
+	ctx := context.Background()
+
+	// Sent mails are counted by tag, with tags outside the allow-list counted together
+	_, err = manager.SendMail(ctx, &inboxer.Mail{RecipientID: "user1", Tags: []string{"reward"}})
+	assert.NoError(t, err)
+	_, err = manager.SendMail(ctx, &inboxer.Mail{RecipientID: "user1"})
+	assert.NoError(t, err)
+	_, err = manager.SendBatchMail(ctx, &inboxer.Mail{Tags: []string{"reward", "event"}}, []string{"user1", "user2", "user3"})
+	assert.NoError(t, err)
+	_, err = manager.SendMail(ctx, &inboxer.Mail{RecipientID: "user1", Tags: []string{"player-42", "player-43"}})
+	assert.NoError(t, err)
+
+	assert.Equal(t, float64(4), testutil.ToFloat64(m.mailsSent.WithLabelValues("reward")))
+	assert.Equal(t, float64(3), testutil.ToFloat64(m.mailsSent.WithLabelValues("event")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.mailsSent.WithLabelValues(untaggedLabel)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.mailsSent.WithLabelValues(otherTagLabel)))
+	assert.Equal(t, 4, testutil.CollectAndCount(m.mailsSent))
+
+	// Batch sizes are observed
+	assert.Equal(t, 1, testutil.CollectAndCount(m.batchSize))
+
+	// Errors are counted per method
+	_, err = manager.SendMail(ctx, nil)
+	assert.Error(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.managerErrors.WithLabelValues("SendMail")))
+
+	// Scheduled job state is exposed
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.scheduledJobs.WithLabelValues("cleanup")))
+	assert.NoError(t, manager.ScheduleCleanup(ctx, time.Minute))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.scheduledJobs.WithLabelValues("cleanup")))
+	assert.Equal(t, float64(60), testutil.ToFloat64(m.scheduledInterval.WithLabelValues("cleanup")))
+
+	// Closing the manager clears the scheduled job state
+	assert.NoError(t, manager.Close(ctx))
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.scheduledJobs.WithLabelValues("cleanup")))
+}
+
+func TestJobHooks(t *testing.T) {
+	m, _ := setupMetrics(t)
+	clock := inboxertest.NewFakeClock(time.Now())
+	store := inboxer.NewMemoryMailStore()
+	ctx := context.Background()
+
+	_, err := store.CreateBatchMails(ctx, []*inboxer.Mail{
+		{RecipientID: "user1", ExpireTime: clock.Now().Add(-time.Hour)},
+		{RecipientID: "user2", ExpireTime: clock.Now().Add(-time.Hour)},
+	})
+	require.NoError(t, err)
+
+	// The job started from the cleanup interval option is recorded without wrapping the manager or the store
+	manager := inboxer.NewDefaultMailManager(store,
+		inboxer.WithClock(clock),
+		inboxer.WithCleanupInterval(time.Minute),
+		inboxer.WithJobHooks(m.JobHooks()),
+	)
+	require.NoError(t, manager.Start(ctx))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.scheduledJobs.WithLabelValues("cleanup")))
+	assert.Equal(t, float64(60), testutil.ToFloat64(m.scheduledInterval.WithLabelValues("cleanup")))
+
+	// Deletions of the background cleanup are counted
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	clock.BlockUntil(1)
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.expiredDeleted))
+
+	// Manual cleanups are counted as well
+	_, err = store.CreateMail(ctx, &inboxer.Mail{RecipientID: "user3", ExpireTime: clock.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+	_, err = manager.DeleteExpiredMails(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, float64(3), testutil.ToFloat64(m.expiredDeleted))
+
+	require.NoError(t, manager.Close(ctx))
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.scheduledJobs.WithLabelValues("cleanup")))
+}
@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/weedbox/inboxer"
+)
+
+// MailStore wraps an inboxer.MailStore and records metrics for every call
+type MailStore struct {
+	next    inboxer.MailStore
+	metrics *Metrics
+}
+
+var _ inboxer.MailStore = (*MailStore)(nil)
+
+// WrapStore returns an instrumented MailStore that delegates to next
+func (m *Metrics) WrapStore(next inboxer.MailStore) *MailStore {
+	return &MailStore{
+		next:    next,
+		metrics: m,
+	}
+}
+
+// CreateMail creates a new mail and returns the mail ID
+func (s *MailStore) CreateMail(ctx context.Context, mail *inboxer.Mail) (id string, err error) {
+	defer s.observe("CreateMail", time.Now(), &err)
+	return s.next.CreateMail(ctx, mail)
+}
+
+// GetMail retrieves a mail by ID
+func (s *MailStore) GetMail(ctx context.Context, mailID string) (mail *inboxer.Mail, err error) {
+	defer s.observe("GetMail", time.Now(), &err)
+	return s.next.GetMail(ctx, mailID)
+}
+
+// UpdateMail updates an existing mail
+func (s *MailStore) UpdateMail(ctx context.Context, mail *inboxer.Mail) (err error) {
+	defer s.observe("UpdateMail", time.Now(), &err)
+	return s.next.UpdateMail(ctx, mail)
+}
+
+// DeleteMail deletes a mail by ID
+func (s *MailStore) DeleteMail(ctx context.Context, mailID string) (err error) {
+	defer s.observe("DeleteMail", time.Now(), &err)
+	return s.next.DeleteMail(ctx, mailID)
+}
+
+// CreateBatchMails creates multiple mails in batch
+func (s *MailStore) CreateBatchMails(ctx context.Context, mails []*inboxer.Mail) (ids []string, err error) {
+	defer s.observe("CreateBatchMails", time.Now(), &err)
+	return s.next.CreateBatchMails(ctx, mails)
+}
+
+// DeleteMailsByRecipient deletes all mails for a specific recipient
+func (s *MailStore) DeleteMailsByRecipient(ctx context.Context, recipientID string) (err error) {
+	defer s.observe("DeleteMailsByRecipient", time.Now(), &err)
+	return s.next.DeleteMailsByRecipient(ctx, recipientID)
+}
+
+// DeleteExpiredMails deletes all expired mails
+func (s *MailStore) DeleteExpiredMails(ctx context.Context, beforeTime time.Time) (count int, err error) {
+	defer s.observe("DeleteExpiredMails", time.Now(), &err)
+	return s.next.DeleteExpiredMails(ctx, beforeTime)
+}
+
+// DeleteExpiredMailsBatched deletes expired mails in batches
+func (s *MailStore) DeleteExpiredMailsBatched(ctx context.Context, beforeTime time.Time, opts inboxer.ExpiredDeleteOptions) (count int, err error) {
+	defer s.observe("DeleteExpiredMailsBatched", time.Now(), &err)
+	return s.next.DeleteExpiredMailsBatched(ctx, beforeTime, opts)
+}
+
+// UpdateByFilter applies a patch to all mails matching the filter
+func (s *MailStore) UpdateByFilter(ctx context.Context, filter *inboxer.MailFilter, patch *inboxer.MailPatch) (count int, err error) {
+	defer s.observe("UpdateByFilter", time.Now(), &err)
+	return s.next.UpdateByFilter(ctx, filter, patch)
+}
+
+// DeleteByFilter deletes all mails matching the filter
+func (s *MailStore) DeleteByFilter(ctx context.Context, filter *inboxer.MailFilter) (count int, err error) {
+	defer s.observe("DeleteByFilter", time.Now(), &err)
+	return s.next.DeleteByFilter(ctx, filter)
+}
+
+// GetMailsByRecipient retrieves mails for a specific recipient with pagination
+func (s *MailStore) GetMailsByRecipient(ctx context.Context, recipientID string, page, size int, order ...inboxer.SortOrder) (mails []*inboxer.Mail, total int, err error) {
+	defer s.observe("GetMailsByRecipient", time.Now(), &err)
+	return s.next.GetMailsByRecipient(ctx, recipientID, page, size, order...)
+}
+
+// QueryMails queries mails by filter conditions with pagination
+func (s *MailStore) QueryMails(ctx context.Context, filter *inboxer.MailFilter, page, size int) (mails []*inboxer.Mail, total int, err error) {
+	defer s.observe("QueryMails", time.Now(), &err)
+	return s.next.QueryMails(ctx, filter, page, size)
+}
+
+// ListExpiringBetween lists mails expiring in [from, to)
+func (s *MailStore) ListExpiringBetween(ctx context.Context, recipientID string, from, to time.Time) (mails []*inboxer.Mail, err error) {
+	defer s.observe("ListExpiringBetween", time.Now(), &err)
+	return s.next.ListExpiringBetween(ctx, recipientID, from, to)
+}
+
+// ScanMails calls fn with pages of mails matching the filter in ID order
+func (s *MailStore) ScanMails(ctx context.Context, filter *inboxer.MailFilter, opts inboxer.ScanOptions, fn func(mails []*inboxer.Mail) error) (err error) {
+	defer s.observe("ScanMails", time.Now(), &err)
+	return s.next.ScanMails(ctx, filter, opts, fn)
+}
+
+// CountUnreadMails counts the number of unread mails for a specific recipient
+func (s *MailStore) CountUnreadMails(ctx context.Context, recipientID string) (count int, err error) {
+	defer s.observe("CountUnreadMails", time.Now(), &err)
+	return s.next.CountUnreadMails(ctx, recipientID)
+}
+
+// CountMailsWithAttachments counts the number of mails with attachments for a specific recipient
+func (s *MailStore) CountMailsWithAttachments(ctx context.Context, recipientID string) (count int, err error) {
+	defer s.observe("CountMailsWithAttachments", time.Now(), &err)
+	return s.next.CountMailsWithAttachments(ctx, recipientID)
+}
+
+// CountBy counts the mails matching the filter per group
+func (s *MailStore) CountBy(ctx context.Context, filter *inboxer.MailFilter, groupBy inboxer.GroupBy) (groups []inboxer.GroupCount, err error) {
+	defer s.observe("CountBy", time.Now(), &err)
+	return s.next.CountBy(ctx, filter, groupBy)
+}
+
+// ExportMailLogs exports mail logs based on filter
+func (s *MailStore) ExportMailLogs(ctx context.Context, filter *inboxer.MailFilter) (logs string, err error) {
+	defer s.observe("ExportMailLogs", time.Now(), &err)
+	return s.next.ExportMailLogs(ctx, filter)
+}
+
+// observe records a store call once it has returned
+func (s *MailStore) observe(method string, start time.Time, err *error) {
+	s.metrics.observeStore(method, start, *err)
+}
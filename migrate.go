@@ -0,0 +1,228 @@
+package inboxer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxReportedMismatches is the number of mismatches listed in a VerifyReport, further ones are only counted
+const MaxReportedMismatches = 100
+
+// CopyOptions controls copying mails between stores
+type CopyOptions struct {
+	Filter       *MailFilter                                     // Mails to copy, nil copies every mail
+	BatchSize    int                                             // Mails per batch, 0 uses DefaultScanBatchSize
+	AfterID      string                                          // Resume after the LastID of a previous checkpoint
+	Overwrite    bool                                            // Replace mails that already exist in the destination instead of skipping them
+	Verify       bool                                            // Compare every source mail with the destination once copied
+	OnCheckpoint func(ctx context.Context, p CopyProgress) error // Called after every written batch, returning an error stops the copy
+}
+
+// CopyProgress reports the progress of a copy after a batch was written
+type CopyProgress struct {
+	Batch       int    // Batch number, starting at 1
+	Copied      int    // Mails created so far
+	Overwritten int    // Mails replaced so far
+	Skipped     int    // Mails skipped so far because they already exist
+	LastID      string // ID of the last written mail, pass as AfterID to resume
+}
+
+// CopyReport summarizes a copy
+type CopyReport struct {
+	CopyProgress
+	Verification *VerifyReport // Set when CopyOptions.Verify is enabled
+}
+
+// VerifyOptions controls verification of copied mails
+type VerifyOptions struct {
+	Filter    *MailFilter // Mails to verify, nil verifies every mail
+	BatchSize int         // Mails per batch, 0 uses DefaultScanBatchSize
+}
+
+// VerifyReport lists the source mails that are missing or different in the destination
+type VerifyReport struct {
+	Checked    int            // Source mails checked
+	Missing    int            // Mails missing from the destination
+	Mismatched int            // Mails whose fields differ
+	Mismatches []MailMismatch // The first MaxReportedMismatches missing or different mails
+}
+
+// OK reports whether every checked mail was found unchanged
+func (r *VerifyReport) OK() bool {
+	return r.Missing == 0 && r.Mismatched == 0
+}
+
+// MailMismatch describes a mail that differs between two stores
+type MailMismatch struct {
+	MailID  string
+	Missing bool     // The mail does not exist in the destination
+	Fields  []string // Names of the differing fields
+}
+
+// CopyMails copies every mail matching the filter from src to dst in ID order, keeping IDs, timestamps and read state.
+// Mails are written in batches, and OnCheckpoint can persist the last written ID so an interrupted copy can resume.
+// On error, the report covers the batches written so far.
+func CopyMails(ctx context.Context, src, dst MailStore, opts CopyOptions) (*CopyReport, error) {
+	policy := ImportSkip
+	if opts.Overwrite {
+		policy = ImportOverwrite
+	}
+	imp := &importer{
+		store:  dst,
+		opts:   ImportOptions{OnConflict: policy},
+		report: &ImportReport{},
+		seen:   make(map[string]bool),
+	}
+
+	report := &CopyReport{CopyProgress: CopyProgress{LastID: opts.AfterID}}
+	err := src.ScanMails(ctx, opts.Filter, ScanOptions{AfterID: opts.AfterID, BatchSize: opts.BatchSize}, func(mails []*Mail) error {
+		lastID := mails[len(mails)-1].ID
+		if err := imp.writeChunk(ctx, mails); err != nil {
+			return err
+		}
+
+		report.Batch = imp.report.Chunks
+		report.Copied = imp.report.Created
+		report.Overwritten = imp.report.Overwritten
+		report.Skipped = imp.report.Skipped
+		report.LastID = lastID
+		if opts.OnCheckpoint != nil {
+			if err := opts.OnCheckpoint(ctx, report.CopyProgress); err != nil {
+				return fmt.Errorf("checkpoint failed: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to copy mails after %q: %w", report.LastID, err)
+	}
+
+	if opts.Verify {
+		report.Verification, err = VerifyMails(ctx, src, dst, VerifyOptions{Filter: opts.Filter, BatchSize: opts.BatchSize})
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// VerifyMails checks that every mail matching the filter in src exists in dst with the same content.
+// Versions are not compared, as each store keeps its own.
+func VerifyMails(ctx context.Context, src, dst MailStore, opts VerifyOptions) (*VerifyReport, error) {
+	report := &VerifyReport{}
+	err := src.ScanMails(ctx, opts.Filter, ScanOptions{BatchSize: opts.BatchSize}, func(mails []*Mail) error {
+		for _, mail := range mails {
+			report.Checked++
+
+			copied, err := dst.GetMail(ctx, mail.ID)
+			if errors.Is(err, ErrMailNotFound) {
+				report.Missing++
+				report.addMismatch(MailMismatch{MailID: mail.ID, Missing: true})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if fields := diffMails(mail, copied); len(fields) > 0 {
+				report.Mismatched++
+				report.addMismatch(MailMismatch{MailID: mail.ID, Fields: fields})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to verify mails: %w", err)
+	}
+	return report, nil
+}
+
+// addMismatch lists a mismatch until MaxReportedMismatches is reached
+func (r *VerifyReport) addMismatch(mismatch MailMismatch) {
+	if len(r.Mismatches) < MaxReportedMismatches {
+		r.Mismatches = append(r.Mismatches, mismatch)
+	}
+}
+
+// diffMails returns the names of the fields that differ between two copies of a mail, ignoring the version.
+// Times are compared at microsecond precision and attachments by their JSON encoding, as stores may not keep more.
+func diffMails(a, b *Mail) []string {
+	var fields []string
+	if a.SenderID != b.SenderID {
+		fields = append(fields, "SenderID")
+	}
+	if a.RecipientID != b.RecipientID {
+		fields = append(fields, "RecipientID")
+	}
+	if a.Title != b.Title {
+		fields = append(fields, "Title")
+	}
+	if a.Content != b.Content {
+		fields = append(fields, "Content")
+	}
+	if !attachmentsEqual(a.Attachments, b.Attachments) {
+		fields = append(fields, "Attachments")
+	}
+	if a.ReadStatus != b.ReadStatus {
+		fields = append(fields, "ReadStatus")
+	}
+	if !timesEqual(a.ReadTime, b.ReadTime) {
+		fields = append(fields, "ReadTime")
+	}
+	if !timesEqual(a.CreateTime, b.CreateTime) {
+		fields = append(fields, "CreateTime")
+	}
+	if !timesEqual(a.ExpireTime, b.ExpireTime) {
+		fields = append(fields, "ExpireTime")
+	}
+	if !tagsEqual(a.Tags, b.Tags) {
+		fields = append(fields, "Tags")
+	}
+	if a.Priority != b.Priority {
+		fields = append(fields, "Priority")
+	}
+	if folderOrDefault(a.Folder) != folderOrDefault(b.Folder) {
+		fields = append(fields, "Folder")
+	}
+	if a.Starred != b.Starred {
+		fields = append(fields, "Starred")
+	}
+	if a.Pinned != b.Pinned {
+		fields = append(fields, "Pinned")
+	}
+	if a.Archived != b.Archived {
+		fields = append(fields, "Archived")
+	}
+	return fields
+}
+
+// timesEqual compares two times at microsecond precision
+func timesEqual(a, b time.Time) bool {
+	return a.Truncate(time.Microsecond).Equal(b.Truncate(time.Microsecond))
+}
+
+// attachmentsEqual compares attachments by their JSON encoding, treating nil and empty as equal
+func attachmentsEqual(a, b map[string]interface{}) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	dataA, errA := json.Marshal(a)
+	dataB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(dataA) == string(dataB)
+}
+
+// tagsEqual compares tags in order, treating nil and empty as equal
+func tagsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package inboxer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyMails(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemoryMailStore()
+	ids := createExportMails(t, src, 5)
+	mail, err := src.GetMail(ctx, ids[0])
+	require.NoError(t, err)
+	mail.ReadStatus = true
+	mail.ReadTime = time.Now()
+	require.NoError(t, src.UpdateMail(ctx, mail))
+
+	// Every mail is copied to the destination and verified
+	dst := setupGormMailStore(t)
+	var checkpoints []CopyProgress
+	report, err := CopyMails(ctx, src, dst, CopyOptions{
+		BatchSize: 2,
+		Verify:    true,
+		OnCheckpoint: func(ctx context.Context, p CopyProgress) error {
+			checkpoints = append(checkpoints, p)
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 5, report.Copied)
+	assert.Equal(t, ids[4], report.LastID)
+	require.Len(t, checkpoints, 3)
+	assert.Equal(t, CopyProgress{Batch: 1, Copied: 2, LastID: ids[1]}, checkpoints[0])
+	require.NotNil(t, report.Verification)
+	assert.True(t, report.Verification.OK())
+	assert.Equal(t, 5, report.Verification.Checked)
+
+	copied, err := dst.GetMail(ctx, ids[0])
+	require.NoError(t, err)
+	assert.True(t, copied.ReadStatus)
+
+	// Copying again skips the existing mails
+	report, err = CopyMails(ctx, src, dst, CopyOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Copied)
+	assert.Equal(t, 5, report.Skipped)
+}
+
+func TestCopyMailsResume(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemoryMailStore()
+	ids := createExportMails(t, src, 5)
+	dst := NewMemoryMailStore()
+
+	// The copy stops when a checkpoint fails, after the batch was written
+	stop := errors.New("disk full")
+	report, err := CopyMails(ctx, src, dst, CopyOptions{
+		BatchSize: 2,
+		OnCheckpoint: func(ctx context.Context, p CopyProgress) error {
+			if p.Batch == 2 {
+				return stop
+			}
+			return nil
+		},
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, ids[3], report.LastID)
+
+	// Resuming from the checkpoint copies the rest
+	report, err = CopyMails(ctx, src, dst, CopyOptions{AfterID: ids[1], Verify: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Copied)
+	assert.Equal(t, 2, report.Skipped)
+	assert.True(t, report.Verification.OK())
+}
+
+func TestCopyMailsOverwrite(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemoryMailStore()
+	ids := createExportMails(t, src, 2)
+
+	dst := NewMemoryMailStore()
+	_, err := dst.CreateMail(ctx, &Mail{ID: ids[0], RecipientID: "user1", Title: "Stale"})
+	require.NoError(t, err)
+
+	report, err := CopyMails(ctx, src, dst, CopyOptions{Overwrite: true, Verify: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Copied)
+	assert.Equal(t, 1, report.Overwritten)
+	assert.True(t, report.Verification.OK())
+}
+
+func TestVerifyMails(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemoryMailStore()
+	ids := createExportMails(t, src, 3)
+	dst := NewMemoryMailStore()
+
+	_, err := CopyMails(ctx, src, dst, CopyOptions{})
+	require.NoError(t, err)
+
+	// Changed and deleted mails are reported
+	mail, err := dst.GetMail(ctx, ids[0])
+	require.NoError(t, err)
+	mail.Title = "Changed"
+	mail.Tags = nil
+	require.NoError(t, dst.UpdateMail(ctx, mail))
+	require.NoError(t, dst.DeleteMail(ctx, ids[1]))
+
+	report, err := VerifyMails(ctx, src, dst, VerifyOptions{})
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, 3, report.Checked)
+	assert.Equal(t, 1, report.Missing)
+	assert.Equal(t, 1, report.Mismatched)
+	assert.Equal(t, []MailMismatch{
+		{MailID: ids[0], Fields: []string{"Title", "Tags"}},
+		{MailID: ids[1], Missing: true},
+	}, report.Mismatches)
+}
+
+func TestDiffMails(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 1000, time.Local)
+	a := &Mail{
+		Attachments: map[string]interface{}{"coins": 100},
+		CreateTime:  now,
+		Tags:        []string{},
+		Version:     3,
+	}
+
+	// Store representations of the same values are equal
+	b := &Mail{
+		Attachments: map[string]interface{}{"coins": float64(100)},
+		CreateTime:  now.Add(100 * time.Nanosecond).UTC(),
+		Version:     1,
+	}
+	assert.Empty(t, diffMails(a, b))
+
+	b.CreateTime = now.Add(time.Millisecond)
+	b.Attachments = nil
+	assert.Equal(t, []string{"Attachments", "CreateTime"}, diffMails(a, b))
+}
@@ -5,42 +5,34 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
 // MemoryMailStore implements the MailStore interface using memory as the storage medium
 type MemoryMailStore struct {
-	mu    sync.RWMutex
-	mails map[string]*Mail
-	idGen IDGenerator
-}
-
-// IDGenerator defines the interface for generating unique IDs
-type IDGenerator interface {
-	GenerateID() string
-}
-
-// SimpleIDGenerator is a simple implementation of the ID generator
-type SimpleIDGenerator struct {
-	counter int
-	mu      sync.Mutex
-}
-
-// GenerateID generates a simple unique ID
-func (g *SimpleIDGenerator) GenerateID() string {
-	g.mu.Lock()
-	defer g.mu.Unlock()
-	g.counter++
-	return fmt.Sprintf("mail_%d_%d", time.Now().UnixNano(), g.counter)
+	mu      sync.RWMutex
+	mails   map[string]*Mail
+	index   *searchIndex
+	idGen   IDGenerator
+	options storeOptions
 }
 
 // NewMemoryMailStore creates a new memory-based mail storage
-func NewMemoryMailStore() *MemoryMailStore {
-	return &MemoryMailStore{
-		mails: make(map[string]*Mail),
+func NewMemoryMailStore(opts ...StoreOption) *MemoryMailStore {
+	options := newStoreOptions(storeOptions{
 		idGen: &SimpleIDGenerator{},
+		clock: SystemClock{},
+	}, opts...)
+
+	return &MemoryMailStore{
+		mails:   make(map[string]*Mail),
+		index:   newSearchIndex(),
+		idGen:   options.idGen,
+		options: options,
 	}
 }
 
@@ -57,10 +49,10 @@ func (s *MemoryMailStore) CreateMail(ctx context.Context, mail *Mail) (string, e
 	if mail.ID == "" {
 		mail.ID = s.idGen.GenerateID()
 	}
+	mail.Version = 1
 
 	// Deep copy the mail object to avoid reference issues
-	mailCopy := copyMail(mail)
-	s.mails[mail.ID] = mailCopy
+	s.put(copyMail(mail))
 
 	return mail.ID, nil
 }
@@ -72,7 +64,7 @@ func (s *MemoryMailStore) GetMail(ctx context.Context, mailID string) (*Mail, er
 
 	mail, exists := s.mails[mailID]
 	if !exists {
-		return nil, fmt.Errorf("mail with ID %s not found", mailID)
+		return nil, fmt.Errorf("%w: %s", ErrMailNotFound, mailID)
 	}
 
 	return copyMail(mail), nil
@@ -87,11 +79,18 @@ func (s *MemoryMailStore) UpdateMail(ctx context.Context, mail *Mail) error {
 		return errors.New("mail cannot be nil and must have an ID")
 	}
 
-	if _, exists := s.mails[mail.ID]; !exists {
-		return fmt.Errorf("mail with ID %s not found", mail.ID)
+	existing, exists := s.mails[mail.ID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrMailNotFound, mail.ID)
+	}
+
+	// Reject the update if the mail was modified since it was read
+	if existing.Version != mail.Version {
+		return fmt.Errorf("%w: mail %s is at version %d, got %d", ErrVersionConflict, mail.ID, existing.Version, mail.Version)
 	}
 
-	s.mails[mail.ID] = copyMail(mail)
+	mail.Version++
+	s.put(copyMail(mail))
 	return nil
 }
 
@@ -101,10 +100,10 @@ func (s *MemoryMailStore) DeleteMail(ctx context.Context, mailID string) error {
 	defer s.mu.Unlock()
 
 	if _, exists := s.mails[mailID]; !exists {
-		return fmt.Errorf("mail with ID %s not found", mailID)
+		return fmt.Errorf("%w: %s", ErrMailNotFound, mailID)
 	}
 
-	delete(s.mails, mailID)
+	s.remove(mailID)
 	return nil
 }
 
@@ -126,9 +125,9 @@ func (s *MemoryMailStore) CreateBatchMails(ctx context.Context, mails []*Mail) (
 		if mail.ID == "" {
 			mail.ID = s.idGen.GenerateID()
 		}
+		mail.Version = 1
 
-		mailCopy := copyMail(mail)
-		s.mails[mail.ID] = mailCopy
+		s.put(copyMail(mail))
 		ids = append(ids, mail.ID)
 	}
 
@@ -152,7 +151,7 @@ func (s *MemoryMailStore) DeleteMailsByRecipient(ctx context.Context, recipientI
 	}
 
 	for _, id := range toDelete {
-		delete(s.mails, id)
+		s.remove(id)
 	}
 
 	return nil
@@ -160,25 +159,125 @@ func (s *MemoryMailStore) DeleteMailsByRecipient(ctx context.Context, recipientI
 
 // DeleteExpiredMails deletes all expired mails
 func (s *MemoryMailStore) DeleteExpiredMails(ctx context.Context, beforeTime time.Time) (int, error) {
+	return s.DeleteExpiredMailsBatched(ctx, beforeTime, ExpiredDeleteOptions{})
+}
+
+// DeleteExpiredMailsBatched deletes expired mails in batches ordered by ID, pausing between batches
+func (s *MemoryMailStore) DeleteExpiredMailsBatched(ctx context.Context, beforeTime time.Time, opts ExpiredDeleteOptions) (int, error) {
+	opts = s.options.expiredDeleteOptions(opts)
+
+	s.mu.RLock()
+	expired := []string{}
+	for id, mail := range s.mails {
+		if isExpiredBefore(mail, beforeTime) {
+			expired = append(expired, id)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Strings(expired)
+
+	total := 0
+	var hookErrs []error
+	for batch := 1; len(expired) > 0; batch++ {
+		if err := ctx.Err(); err != nil {
+			return total, errors.Join(append(hookErrs, err)...)
+		}
+
+		size := min(opts.BatchSize, len(expired))
+		ids := expired[:size]
+		expired = expired[size:]
+
+		// Mails may have been changed or deleted since they were collected
+		s.mu.RLock()
+		mails := make([]*Mail, 0, len(ids))
+		for _, id := range ids {
+			if mail, ok := s.mails[id]; ok && isExpiredBefore(mail, beforeTime) {
+				mails = append(mails, copyMail(mail))
+			}
+		}
+		s.mu.RUnlock()
+
+		// The hook runs without holding the lock so it can use the store
+		deleted, skipped := 0, 0
+		if err := runExpiryHook(ctx, opts.BeforeDelete, batch, mails); err != nil {
+			hookErrs = append(hookErrs, err)
+			skipped = len(mails)
+		} else {
+			s.mu.Lock()
+			for _, mail := range mails {
+				if current, ok := s.mails[mail.ID]; ok && isExpiredBefore(current, beforeTime) {
+					s.remove(mail.ID)
+					deleted++
+				}
+			}
+			s.mu.Unlock()
+		}
+
+		total += deleted
+		if opts.OnProgress != nil {
+			opts.OnProgress(ExpiredDeleteProgress{Batch: batch, Deleted: deleted, Skipped: skipped, Total: total})
+		}
+
+		if len(expired) > 0 {
+			if err := sleepContext(ctx, s.options.clock, opts.Pause); err != nil {
+				return total, errors.Join(append(hookErrs, err)...)
+			}
+		}
+	}
+
+	return total, errors.Join(hookErrs...)
+}
+
+// UpdateByFilter applies a patch to all mails matching the filter and returns the number of updated mails
+func (s *MemoryMailStore) UpdateByFilter(ctx context.Context, filter *MailFilter, patch *MailPatch) (int, error) {
+	if filter == nil {
+		return 0, errors.New("filter cannot be nil")
+	}
+	if patch.isEmpty() {
+		return 0, errors.New("patch cannot be empty")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, mail := range s.mailsMatching(filter) {
+		applyPatch(mail, patch)
+		mail.Version++
+		count++
+	}
+
+	return count, nil
+}
+
+// DeleteByFilter deletes all mails matching the filter and returns the number of deleted mails
+func (s *MemoryMailStore) DeleteByFilter(ctx context.Context, filter *MailFilter) (int, error) {
+	if filter == nil {
+		return 0, errors.New("filter cannot be nil")
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	toDelete := []string{}
-	for id, mail := range s.mails {
-		if !mail.ExpireTime.IsZero() && mail.ExpireTime.Before(beforeTime) {
-			toDelete = append(toDelete, id)
-		}
+	for _, mail := range s.mailsMatching(filter) {
+		toDelete = append(toDelete, mail.ID)
 	}
 
 	for _, id := range toDelete {
-		delete(s.mails, id)
+		s.remove(id)
 	}
 
 	return len(toDelete), nil
 }
 
-// GetMailsByRecipient retrieves mails for a specific recipient with pagination
-func (s *MemoryMailStore) GetMailsByRecipient(ctx context.Context, recipientID string, page, size int) ([]*Mail, int, error) {
+// GetMailsByRecipient retrieves the mails of a recipient that are not archived with pagination,
+// pinned mails first and then in the given order, newest first by default
+func (s *MemoryMailStore) GetMailsByRecipient(ctx context.Context, recipientID string, page, size int, order ...SortOrder) ([]*Mail, int, error) {
+	sortOrder, err := optionalSort(order)
+	if err != nil {
+		return nil, 0, err
+	}
 	if page <= 0 {
 		page = 1
 	}
@@ -192,14 +291,14 @@ func (s *MemoryMailStore) GetMailsByRecipient(ctx context.Context, recipientID s
 	// Collect all matching mails
 	matchedMails := []*Mail{}
 	for _, mail := range s.mails {
-		if mail.RecipientID == recipientID {
+		if mail.RecipientID == recipientID && !mail.Archived {
 			matchedMails = append(matchedMails, copyMail(mail))
 		}
 	}
 
-	// Sort by creation time (newest first)
+	// Sort pinned mails first, then in the requested order
 	sort.Slice(matchedMails, func(i, j int) bool {
-		return matchedMails[i].CreateTime.After(matchedMails[j].CreateTime)
+		return compareMails(sortOrder, matchedMails[i], matchedMails[j]) < 0
 	})
 
 	// Calculate total and pagination
@@ -225,24 +324,37 @@ func (s *MemoryMailStore) QueryMails(ctx context.Context, filter *MailFilter, pa
 	if size <= 0 {
 		size = 10
 	}
+	if err := filterSort(filter).validate(); err != nil {
+		return nil, 0, err
+	}
 
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	matchedMails := []*Mail{}
-	now := time.Now()
-
-	for _, mail := range s.mails {
-		if !matchMail(mail, filter, now) {
-			continue
-		}
+	mails, scores := s.matchingMails(filter)
+	for _, mail := range mails {
 		matchedMails = append(matchedMails, copyMail(mail))
 	}
 
-	// Sort by creation time (newest first)
-	sort.Slice(matchedMails, func(i, j int) bool {
-		return matchedMails[i].CreateTime.After(matchedMails[j].CreateTime)
-	})
+	order := filterSort(filter)
+	if scores != nil && order == "" {
+		// Rank pinned mails first, then by relevance, then newest first
+		sort.Slice(matchedMails, func(i, j int) bool {
+			a, b := matchedMails[i], matchedMails[j]
+			if c := comparePinned(a, b); c != 0 {
+				return c < 0
+			}
+			if scores[a.ID] != scores[b.ID] {
+				return scores[a.ID] > scores[b.ID]
+			}
+			return compareMails(SortNewest, a, b) < 0
+		})
+	} else {
+		sort.Slice(matchedMails, func(i, j int) bool {
+			return compareMails(order, matchedMails[i], matchedMails[j]) < 0
+		})
+	}
 
 	// Calculate total and pagination
 	total := len(matchedMails)
@@ -259,14 +371,74 @@ func (s *MemoryMailStore) QueryMails(ctx context.Context, filter *MailFilter, pa
 	return matchedMails[start:end], total, nil
 }
 
-// CountUnreadMails counts the number of unread mails for a specific recipient
+// ScanMails calls fn with pages of mails matching the filter in ID order
+func (s *MemoryMailStore) ScanMails(ctx context.Context, filter *MailFilter, opts ScanOptions, fn func(mails []*Mail) error) error {
+	opts = opts.withDefaults()
+
+	// Snapshot the matching mails, so fn runs without holding the lock
+	s.mu.RLock()
+	matchedMails := []*Mail{}
+	for _, mail := range s.mailsMatching(filter) {
+		if mail.ID > opts.AfterID {
+			matchedMails = append(matchedMails, copyMail(mail))
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(matchedMails, func(i, j int) bool {
+		return matchedMails[i].ID < matchedMails[j].ID
+	})
+
+	for start := 0; start < len(matchedMails); start += opts.BatchSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := start + opts.BatchSize
+		if end > len(matchedMails) {
+			end = len(matchedMails)
+		}
+		if err := fn(matchedMails[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListExpiringBetween lists mails expiring in [from, to), soonest first
+func (s *MemoryMailStore) ListExpiringBetween(ctx context.Context, recipientID string, from, to time.Time) ([]*Mail, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	mails := []*Mail{}
+	for _, mail := range s.mails {
+		if recipientID != "" && mail.RecipientID != recipientID {
+			continue
+		}
+		if mail.Starred || mail.ExpireTime.IsZero() || mail.ExpireTime.Before(from) || !mail.ExpireTime.Before(to) {
+			continue
+		}
+		mails = append(mails, copyMail(mail))
+	}
+
+	sort.Slice(mails, func(i, j int) bool {
+		if !mails[i].ExpireTime.Equal(mails[j].ExpireTime) {
+			return mails[i].ExpireTime.Before(mails[j].ExpireTime)
+		}
+		return mails[i].ID < mails[j].ID
+	})
+
+	return mails, nil
+}
+
+// CountUnreadMails counts the number of unread mails for a specific recipient, archived mails excluded
 func (s *MemoryMailStore) CountUnreadMails(ctx context.Context, recipientID string) (int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	count := 0
 	for _, mail := range s.mails {
-		if mail.RecipientID == recipientID && !mail.ReadStatus {
+		if mail.RecipientID == recipientID && !mail.ReadStatus && !mail.Archived {
 			count++
 		}
 	}
@@ -289,18 +461,32 @@ func (s *MemoryMailStore) CountMailsWithAttachments(ctx context.Context, recipie
 	return count, nil
 }
 
+// CountBy counts the mails matching the filter per group, ordered by group key
+func (s *MemoryMailStore) CountBy(ctx context.Context, filter *MailFilter, groupBy GroupBy) ([]GroupCount, error) {
+	if err := groupBy.validate(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, mail := range s.mailsMatching(filter) {
+		for _, key := range groupKeys(mail, groupBy) {
+			counts[key]++
+		}
+	}
+
+	return sortedGroupCounts(counts), nil
+}
+
 // ExportMailLogs exports mail logs based on filter
 func (s *MemoryMailStore) ExportMailLogs(ctx context.Context, filter *MailFilter) (string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	matchedMails := []*Mail{}
-	now := time.Now()
-
-	for _, mail := range s.mails {
-		if !matchMail(mail, filter, now) {
-			continue
-		}
+	for _, mail := range s.mailsMatching(filter) {
 		matchedMails = append(matchedMails, copyMail(mail))
 	}
 
@@ -318,6 +504,62 @@ func (s *MemoryMailStore) ExportMailLogs(ctx context.Context, filter *MailFilter
 	return string(data), nil
 }
 
+// put stores a mail and reindexes it, the caller must hold the write lock
+func (s *MemoryMailStore) put(mail *Mail) {
+	if existing, ok := s.mails[mail.ID]; ok {
+		s.index.remove(existing)
+	}
+	mail.Folder = folderOrDefault(mail.Folder)
+	s.mails[mail.ID] = mail
+	s.index.add(mail)
+}
+
+// remove deletes a mail and drops it from the index, the caller must hold the write lock
+func (s *MemoryMailStore) remove(mailID string) {
+	if existing, ok := s.mails[mailID]; ok {
+		s.index.remove(existing)
+		delete(s.mails, mailID)
+	}
+}
+
+// searchScores returns the relevance of every mail matching the filter text, or nil without a text query
+func (s *MemoryMailStore) searchScores(filter *MailFilter) map[string]float64 {
+	words := filterWords(filter)
+	if len(words) == 0 {
+		return nil
+	}
+	return s.index.search(words)
+}
+
+// mailsMatching returns the stored mails matching the filter, the caller must hold the lock
+func (s *MemoryMailStore) mailsMatching(filter *MailFilter) []*Mail {
+	mails, _ := s.matchingMails(filter)
+	return mails
+}
+
+// matchingMails returns the stored mails matching the filter and, for text queries, their relevance scores
+func (s *MemoryMailStore) matchingMails(filter *MailFilter) ([]*Mail, map[string]float64) {
+	now := s.options.clock.Now()
+	matched := []*Mail{}
+
+	// A text query narrows the candidates to the mails found in the index
+	if scores := s.searchScores(filter); scores != nil {
+		for id := range scores {
+			if mail := s.mails[id]; matchMail(mail, filter, now) {
+				matched = append(matched, mail)
+			}
+		}
+		return matched, scores
+	}
+
+	for _, mail := range s.mails {
+		if matchMail(mail, filter, now) {
+			matched = append(matched, mail)
+		}
+	}
+	return matched, nil
+}
+
 // Helper function: Deep copy a mail object
 func copyMail(mail *Mail) *Mail {
 	if mail == nil {
@@ -331,8 +573,15 @@ func copyMail(mail *Mail) *Mail {
 		Title:       mail.Title,
 		Content:     mail.Content,
 		ReadStatus:  mail.ReadStatus,
+		ReadTime:    mail.ReadTime,
 		CreateTime:  mail.CreateTime,
 		ExpireTime:  mail.ExpireTime,
+		Priority:    mail.Priority,
+		Folder:      mail.Folder,
+		Starred:     mail.Starred,
+		Pinned:      mail.Pinned,
+		Archived:    mail.Archived,
+		Version:     mail.Version,
 	}
 
 	// Copy tags
@@ -352,6 +601,60 @@ func copyMail(mail *Mail) *Mail {
 	return mailCopy
 }
 
+// Helper function: Apply a patch to a mail in place.
+// Tags listed in RemoveTags are removed first, then AddTags that the mail did not have are appended.
+func applyPatch(mail *Mail, patch *MailPatch) {
+	if patch.ReadStatus != nil {
+		mail.ReadStatus = *patch.ReadStatus
+	}
+	if patch.ReadTime != nil {
+		mail.ReadTime = *patch.ReadTime
+	}
+	if patch.ExpireTime != nil {
+		mail.ExpireTime = *patch.ExpireTime
+	}
+	if patch.Folder != nil {
+		mail.Folder = folderOrDefault(*patch.Folder)
+	}
+	if patch.Starred != nil {
+		mail.Starred = *patch.Starred
+	}
+	if patch.Pinned != nil {
+		mail.Pinned = *patch.Pinned
+	}
+	if patch.Archived != nil {
+		mail.Archived = *patch.Archived
+	}
+
+	if len(patch.AddTags) == 0 && len(patch.RemoveTags) == 0 {
+		return
+	}
+
+	original := make(map[string]bool, len(mail.Tags))
+	for _, tag := range mail.Tags {
+		original[tag] = true
+	}
+	removed := make(map[string]bool, len(patch.RemoveTags))
+	for _, tag := range patch.RemoveTags {
+		removed[tag] = true
+	}
+
+	tags := make([]string, 0, len(mail.Tags)+len(patch.AddTags))
+	for _, tag := range mail.Tags {
+		if !removed[tag] {
+			tags = append(tags, tag)
+		}
+	}
+	for _, tag := range patch.AddTags {
+		if !original[tag] {
+			tags = append(tags, tag)
+			original[tag] = true
+		}
+	}
+
+	mail.Tags = tags
+}
+
 // Helper function: Check if a mail matches the filter conditions
 func matchMail(mail *Mail, filter *MailFilter, now time.Time) bool {
 	if filter == nil {
@@ -405,5 +708,76 @@ func matchMail(mail *Mail, filter *MailFilter, now time.Time) bool {
 		}
 	}
 
+	// Filter by expiration time range, mails that never expire have no expiration time to match
+	if filter.ExpireStart != nil && (mail.ExpireTime.IsZero() || mail.ExpireTime.Before(*filter.ExpireStart)) {
+		return false
+	}
+	if filter.ExpireEnd != nil && (mail.ExpireTime.IsZero() || mail.ExpireTime.After(*filter.ExpireEnd)) {
+		return false
+	}
+
+	// Filter by attachments
+	if filter.HasAttachments != nil && (len(mail.Attachments) > 0) != *filter.HasAttachments {
+		return false
+	}
+	for _, key := range filter.AttachmentKeys {
+		if _, ok := mail.Attachments[key]; !ok {
+			return false
+		}
+	}
+
+	// Filter by ID lists
+	if len(filter.SenderIDs) > 0 && !slices.Contains(filter.SenderIDs, mail.SenderID) {
+		return false
+	}
+	if len(filter.RecipientIDs) > 0 && !slices.Contains(filter.RecipientIDs, mail.RecipientID) {
+		return false
+	}
+	if len(filter.IDs) > 0 && !slices.Contains(filter.IDs, mail.ID) {
+		return false
+	}
+
+	// Filter by folder
+	if filter.Folder != "" && folderOrDefault(mail.Folder) != filter.Folder {
+		return false
+	}
+
+	// Filter by flags
+	if filter.Starred != nil && mail.Starred != *filter.Starred {
+		return false
+	}
+	if filter.Pinned != nil && mail.Pinned != *filter.Pinned {
+		return false
+	}
+	if filter.Archived != nil && mail.Archived != *filter.Archived {
+		return false
+	}
+
+	// Filter by title prefix
+	if filter.TitlePrefix != "" && !strings.HasPrefix(mail.Title, filter.TitlePrefix) {
+		return false
+	}
+
+	// Filter by query expression
+	if filter.Expr != nil && !filter.Expr.match(mail, now) {
+		return false
+	}
+
 	return true
 }
+
+// Helper function: Check if a mail has an expiration time before the given time and can be deleted, starred mails are kept
+func isExpiredBefore(mail *Mail, beforeTime time.Time) bool {
+	return !mail.Starred && !mail.ExpireTime.IsZero() && mail.ExpireTime.Before(beforeTime)
+}
+
+// Helper function: Run an expiry hook on a batch of mails, wrapping its error with the batch number
+func runExpiryHook(ctx context.Context, hook ExpiryHook, batch int, mails []*Mail) error {
+	if hook == nil || len(mails) == 0 {
+		return nil
+	}
+	if err := hook(ctx, mails); err != nil {
+		return fmt.Errorf("expiry hook failed for batch %d: %w", batch, err)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package inboxer
+
+import (
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StoreOption configures a MailStore implementation
+type StoreOption func(*storeOptions)
+
+// storeOptions holds the settings shared by all MailStore implementations
+type storeOptions struct {
+	idGen           IDGenerator
+	autoMigrate     bool
+	logger          *slog.Logger
+	deleteBatchSize int
+	deletePause     time.Duration
+	clock           Clock
+}
+
+// newStoreOptions applies the given options on top of the provided defaults
+func newStoreOptions(defaults storeOptions, opts ...StoreOption) storeOptions {
+	o := defaults
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+	return o
+}
+
+// expiredDeleteOptions fills unset fields of opts with the store defaults
+func (o storeOptions) expiredDeleteOptions(opts ExpiredDeleteOptions) ExpiredDeleteOptions {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = o.deleteBatchSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultDeleteBatchSize
+	}
+	if opts.Pause <= 0 {
+		opts.Pause = o.deletePause
+	}
+	return opts
+}
+
+// WithIDGenerator sets the generator used to assign IDs to mails created without one
+func WithIDGenerator(gen IDGenerator) StoreOption {
+	return func(o *storeOptions) {
+		if gen != nil {
+			o.idGen = gen
+		}
+	}
+}
+
+// WithAutoMigrate controls whether GormMailStore and GormLeaseManager apply pending schema migrations on creation.
+// Disable it in production and run migrations explicitly with a Migrator instead.
+func WithAutoMigrate(enabled bool) StoreOption {
+	return func(o *storeOptions) {
+		o.autoMigrate = enabled
+	}
+}
+
+// WithStoreLogger sets the logger used by GormMailStore to report database errors
+func WithStoreLogger(logger *slog.Logger) StoreOption {
+	return func(o *storeOptions) {
+		if logger != nil {
+			o.logger = logger
+		}
+	}
+}
+
+// WithDeleteBatchSize sets how many expired mails are deleted per batch
+func WithDeleteBatchSize(size int) StoreOption {
+	return func(o *storeOptions) {
+		if size > 0 {
+			o.deleteBatchSize = size
+		}
+	}
+}
+
+// WithDeleteBatchPause sets the pause between batches of expired mail deletion, limiting the load on the database
+func WithDeleteBatchPause(pause time.Duration) StoreOption {
+	return func(o *storeOptions) {
+		if pause >= 0 {
+			o.deletePause = pause
+		}
+	}
+}
+
+// WithStoreClock sets the clock used to decide which mails have expired
+func WithStoreClock(clock Clock) StoreOption {
+	return func(o *storeOptions) {
+		if clock != nil {
+			o.clock = clock
+		}
+	}
+}
+
+// ManagerOption configures a DefaultMailManager
+type ManagerOption func(*DefaultMailManager)
+
+// WithMaxUpdateRetries sets how many times read-modify-write operations are retried on version conflicts
+func WithMaxUpdateRetries(retries int) ManagerOption {
+	return func(m *DefaultMailManager) {
+		if retries >= 0 {
+			m.maxUpdateRetries = retries
+		}
+	}
+}
+
+// WithLogger sets the logger used for structured mail events and errors
+func WithLogger(logger *slog.Logger) ManagerOption {
+	return func(m *DefaultMailManager) {
+		if logger != nil {
+			m.logger = logger
+		}
+	}
+}
+
+// WithTracerProvider enables OpenTelemetry tracing of manager methods and the store calls they make
+func WithTracerProvider(tp trace.TracerProvider) ManagerOption {
+	return func(m *DefaultMailManager) {
+		m.tracerProvider = tp
+	}
+}
+
+// WithCleanupInterval schedules automatic cleanup of expired mails when the manager is started
+func WithCleanupInterval(interval time.Duration) ManagerOption {
+	return func(m *DefaultMailManager) {
+		if interval > 0 {
+			m.cleanupInterval = interval
+		}
+	}
+}
+
+// WithLeaseManager makes periodic jobs such as cleanup run on only one instance at a time.
+// Instances sharing a store must use the same lease backend and distinct holder IDs; an empty holder ID is generated.
+func WithLeaseManager(leases LeaseManager, holderID string) ManagerOption {
+	return func(m *DefaultMailManager) {
+		m.leases = leases
+		m.leaseHolder = holderID
+	}
+}
+
+// WithExpiryHook registers a hook that is called with every batch of expired mails before it is deleted,
+// e.g. to grant unclaimed attachments or archive mails. Hooks run in the order they are registered;
+// if one returns an error, the batch is kept and the error is returned by DeleteExpiredMails.
+func WithExpiryHook(hook ExpiryHook) ManagerOption {
+	return func(m *DefaultMailManager) {
+		if hook != nil {
+			m.expiryHooks = append(m.expiryHooks, hook)
+		}
+	}
+}
+
+// WithExpiringSoonHandler sets the handler that receives expiring soon events scheduled with ScheduleExpiryNotifications
+func WithExpiringSoonHandler(handler ExpiringSoonHandler) ManagerOption {
+	return func(m *DefaultMailManager) {
+		m.expiringSoonHandler = handler
+	}
+}
+
+// WithRetentionPolicy limits how long mails are kept. The policy is applied when mails are sent and read and
+// to every stored mail before a cleanup, by bringing their expiration time forward, so the cleanup deletes them
+// once the retention period has passed.
+func WithRetentionPolicy(policy *RetentionPolicy) ManagerOption {
+	return func(m *DefaultMailManager) {
+		m.retention = policy
+	}
+}
+
+// WithJobHooks sets the hooks that observe periodic jobs and expired mail cleanups
+func WithJobHooks(hooks JobHooks) ManagerOption {
+	return func(m *DefaultMailManager) {
+		m.jobHooks = hooks
+	}
+}
+
+// WithClock sets the clock used for mail timestamps, expiry and the schedule of background jobs
+func WithClock(clock Clock) ManagerOption {
+	return func(m *DefaultMailManager) {
+		if clock != nil {
+			m.clock = clock
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package inboxer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidQuery is returned for query expressions that can't be parsed or evaluated
+var ErrInvalidQuery = errors.New("invalid query")
+
+// ParseQuery parses a query string into an expression, returning nil for an empty query.
+//
+// Terms are field:value pairs, keywords or words searched in the title and content:
+//
+//	sender:ID  recipient:ID  id:ID  tag:TAG  folder:FOLDER  title:PREFIX  attachment:KEY
+//	since:TIME  until:TIME  expires-after:TIME  expires-before:TIME
+//	is:read  is:unread  is:expired  is:starred  is:pinned  is:archived  has:attachments  read  unread  expired  *
+//
+// Times are RFC 3339 or YYYY-MM-DD dates in local time, and values with spaces are written in double quotes.
+// Terms are combined with NOT, AND and OR in that order of precedence, and parentheses.
+// Adjacent terms are combined with AND, so (tag:event OR sender:system) NOT read is a valid query.
+func ParseQuery(query string) (*Expr, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	p := &queryParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.errorf(tok, "unexpected %q", tok.text)
+	}
+	return expr, nil
+}
+
+// queryTokenKind is the kind of a query token
+type queryTokenKind int
+
+const (
+	tokenEOF queryTokenKind = iota
+	tokenOpen
+	tokenClose
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenTerm
+)
+
+// queryToken is a token of a query string
+type queryToken struct {
+	kind   queryTokenKind
+	text   string // Text as written
+	field  string // Field of a field:value term
+	value  string // Unquoted value of a term
+	quoted bool   // Whether a term without a field was quoted
+	pos    int    // Byte offset in the query
+}
+
+// lexQuery splits a query string into tokens
+func lexQuery(query string) ([]queryToken, error) {
+	var tokens []queryToken
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(':
+			tokens = append(tokens, queryToken{kind: tokenOpen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, queryToken{kind: tokenClose, text: ")", pos: i})
+			i++
+		default:
+			tok, end, err := lexTerm(query, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tok)
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// lexTerm reads the term or operator starting at start and returns it with the offset after it
+func lexTerm(query string, start int) (queryToken, int, error) {
+	tok := queryToken{kind: tokenTerm, pos: start}
+
+	// A quoted term is a text search
+	if query[start] == '"' {
+		value, end, err := lexQuoted(query, start)
+		if err != nil {
+			return tok, 0, err
+		}
+		tok.text, tok.value, tok.quoted = query[start:end], value, true
+		return tok, end, nil
+	}
+
+	end := start
+	for end < len(query) && !isQueryDelimiter(query[end]) {
+		if query[end] == ':' {
+			tok.field = strings.ToLower(query[start:end])
+			end++
+			if end < len(query) && query[end] == '"' {
+				value, quotedEnd, err := lexQuoted(query, end)
+				if err != nil {
+					return tok, 0, err
+				}
+				tok.text, tok.value = query[start:quotedEnd], value
+				return tok, quotedEnd, nil
+			}
+			valueStart := end
+			for end < len(query) && !isQueryDelimiter(query[end]) {
+				end++
+			}
+			tok.text, tok.value = query[start:end], query[valueStart:end]
+			return tok, end, nil
+		}
+		end++
+	}
+
+	tok.text, tok.value = query[start:end], query[start:end]
+	switch tok.text {
+	case "AND":
+		tok.kind = tokenAnd
+	case "OR":
+		tok.kind = tokenOr
+	case "NOT":
+		tok.kind = tokenNot
+	}
+	return tok, end, nil
+}
+
+// lexQuoted reads the double quoted string starting at start and returns its value with the offset after it
+func lexQuoted(query string, start int) (string, int, error) {
+	for end := start + 1; end < len(query); end++ {
+		switch query[end] {
+		case '\\':
+			end++
+		case '"':
+			value, err := strconv.Unquote(query[start : end+1])
+			if err != nil {
+				return "", 0, fmt.Errorf("%w: bad quoted string at position %d", ErrInvalidQuery, start)
+			}
+			return value, end + 1, nil
+		}
+	}
+	return "", 0, fmt.Errorf("%w: unterminated quoted string at position %d", ErrInvalidQuery, start)
+}
+
+// isQueryDelimiter reports whether a byte ends a term
+func isQueryDelimiter(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '(' || c == ')'
+}
+
+// queryParser is a recursive descent parser over query tokens
+type queryParser struct {
+	tokens []queryToken
+	next   int
+}
+
+// peek returns the next token without consuming it
+func (p *queryParser) peek() queryToken {
+	if p.next >= len(p.tokens) {
+		return queryToken{kind: tokenEOF, text: "end of query", pos: -1}
+	}
+	return p.tokens[p.next]
+}
+
+// errorf returns an ErrInvalidQuery error at the position of a token
+func (p *queryParser) errorf(tok queryToken, format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if tok.pos < 0 {
+		return fmt.Errorf("%w: %s at end of query", ErrInvalidQuery, msg)
+	}
+	return fmt.Errorf("%w: %s at position %d", ErrInvalidQuery, msg, tok.pos)
+}
+
+// parseOr parses terms joined by OR
+func (p *queryParser) parseOr() (*Expr, error) {
+	args := []*Expr{}
+	for {
+		arg, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.peek().kind != tokenOr {
+			break
+		}
+		p.next++
+	}
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	return Or(args...), nil
+}
+
+// parseAnd parses terms joined by AND or written next to each other
+func (p *queryParser) parseAnd() (*Expr, error) {
+	args := []*Expr{}
+	for {
+		arg, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		tok := p.peek()
+		if tok.kind == tokenAnd {
+			p.next++
+			continue
+		}
+		if tok.kind == tokenEOF || tok.kind == tokenOr || tok.kind == tokenClose {
+			break
+		}
+	}
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	return And(args...), nil
+}
+
+// parseNot parses a term with any number of NOTs before it
+func (p *queryParser) parseNot() (*Expr, error) {
+	if p.peek().kind == tokenNot {
+		p.next++
+		arg, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return Not(arg), nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary parses a term or an expression in parentheses
+func (p *queryParser) parsePrimary() (*Expr, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokenOpen:
+		p.next++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.peek(); closing.kind != tokenClose {
+			return nil, p.errorf(closing, "expected ) instead of %q", closing.text)
+		}
+		p.next++
+		return expr, nil
+	case tokenTerm:
+		p.next++
+		return p.parseTerm(tok)
+	}
+	return nil, p.errorf(tok, "expected a term instead of %q", tok.text)
+}
+
+// parseTerm converts a term into a match expression
+func (p *queryParser) parseTerm(tok queryToken) (*Expr, error) {
+	var filter MailFilter
+	read, unread, set := true, false, true
+
+	if tok.field == "" {
+		switch {
+		case tok.quoted:
+			filter.Text = tok.value
+		case tok.value == "*":
+		case tok.value == "read":
+			filter.ReadStatus = &read
+		case tok.value == "unread":
+			filter.ReadStatus = &unread
+		case tok.value == "expired":
+			filter.ExpiredOnly = true
+		default:
+			filter.Text = tok.value
+		}
+		if filter.Text != "" && len(searchWords(filter.Text)) == 0 {
+			return nil, p.errorf(tok, "no words to search in %q", tok.text)
+		}
+		return Match(filter), nil
+	}
+
+	if tok.value == "" {
+		return nil, p.errorf(tok, "missing value for %s", tok.field)
+	}
+	value := tok.value
+	switch tok.field {
+	case "sender":
+		filter.SenderID = value
+	case "recipient":
+		filter.RecipientID = value
+	case "id":
+		filter.IDs = []string{value}
+	case "tag":
+		filter.Tags = []string{value}
+	case "folder":
+		filter.Folder = value
+	case "title":
+		filter.TitlePrefix = value
+	case "attachment":
+		filter.AttachmentKeys = []string{value}
+	case "since", "until", "expires-after", "expires-before":
+		t, err := parseQueryTime(value)
+		if err != nil {
+			return nil, p.errorf(tok, "invalid time %q for %s", value, tok.field)
+		}
+		switch tok.field {
+		case "since":
+			filter.StartTime = &t
+		case "until":
+			filter.EndTime = &t
+		case "expires-after":
+			filter.ExpireStart = &t
+		case "expires-before":
+			filter.ExpireEnd = &t
+		}
+	case "is":
+		switch value {
+		case "read":
+			filter.ReadStatus = &read
+		case "unread":
+			filter.ReadStatus = &unread
+		case "expired":
+			filter.ExpiredOnly = true
+		case "starred":
+			filter.Starred = &set
+		case "pinned":
+			filter.Pinned = &set
+		case "archived":
+			filter.Archived = &set
+		default:
+			return nil, p.errorf(tok, "unknown value %q for is", value)
+		}
+	case "has":
+		if value != "attachments" {
+			return nil, p.errorf(tok, "unknown value %q for has", value)
+		}
+		filter.HasAttachments = &set
+	default:
+		return nil, p.errorf(tok, "unknown field %q", tok.field)
+	}
+	return Match(filter), nil
+}
+
+// parseQueryTime parses an RFC 3339 time or a date in local time
+func parseQueryTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, value, time.Local)
+}
@@ -0,0 +1,121 @@
+package inboxer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuery(t *testing.T) {
+	read, unread, has := true, false, true
+
+	tests := []struct {
+		query string
+		want  *Expr
+	}{
+		{"sender:system", Match(MailFilter{SenderID: "system"})},
+		{"recipient:player1 tag:event", And(Match(MailFilter{RecipientID: "player1"}), Match(MailFilter{Tags: []string{"event"}}))},
+		{"id:01ABC OR title:\"Season Pass\"", Or(Match(MailFilter{IDs: []string{"01ABC"}}), Match(MailFilter{TitlePrefix: "Season Pass"}))},
+		{"NOT read", Not(Match(MailFilter{ReadStatus: &read}))},
+		{"folder:rewards unread", And(Match(MailFilter{Folder: FolderRewards}), Match(MailFilter{ReadStatus: &unread}))},
+		{"is:starred OR is:pinned NOT is:archived", Or(
+			Match(MailFilter{Starred: &read}),
+			And(Match(MailFilter{Pinned: &read}), Not(Match(MailFilter{Archived: &read}))),
+		)},
+		{"is:unread has:attachments attachment:coins", And(
+			Match(MailFilter{ReadStatus: &unread}),
+			Match(MailFilter{HasAttachments: &has}),
+			Match(MailFilter{AttachmentKeys: []string{"coins"}}),
+		)},
+		{"expired OR is:expired", Or(Match(MailFilter{ExpiredOnly: true}), Match(MailFilter{ExpiredOnly: true}))},
+		{`gold "season rewards"`, And(Match(MailFilter{Text: "gold"}), Match(MailFilter{Text: "season rewards"}))},
+		{"*", Match(MailFilter{})},
+		// NOT binds tighter than AND, which binds tighter than OR
+		{"(tag:event OR sender:system) AND NOT read", And(
+			Or(Match(MailFilter{Tags: []string{"event"}}), Match(MailFilter{SenderID: "system"})),
+			Not(Match(MailFilter{ReadStatus: &read})),
+		)},
+		{"tag:a OR tag:b tag:c", Or(
+			Match(MailFilter{Tags: []string{"a"}}),
+			And(Match(MailFilter{Tags: []string{"b"}}), Match(MailFilter{Tags: []string{"c"}})),
+		)},
+		{"NOT NOT (unread)", Not(Not(Match(MailFilter{ReadStatus: &unread})))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := ParseQuery(tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// Empty queries have no expression
+	expr, err := ParseQuery("  ")
+	assert.NoError(t, err)
+	assert.Nil(t, expr)
+}
+
+func TestParseQueryTimes(t *testing.T) {
+	expr, err := ParseQuery("since:2026-01-02 until:2026-01-03T10:00:00Z expires-after:2026-02-01 expires-before:2026-03-01")
+	require.NoError(t, err)
+	require.Len(t, expr.Args, 4)
+	assert.Equal(t, time.Date(2026, 1, 2, 0, 0, 0, 0, time.Local), *expr.Args[0].Filter.StartTime)
+	assert.Equal(t, time.Date(2026, 1, 3, 10, 0, 0, 0, time.UTC), expr.Args[1].Filter.EndTime.UTC())
+	assert.Equal(t, time.Date(2026, 2, 1, 0, 0, 0, 0, time.Local), *expr.Args[2].Filter.ExpireStart)
+	assert.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local), *expr.Args[3].Filter.ExpireEnd)
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		err   string
+	}{
+		{"tag:a OR", "expected a term instead of \"end of query\" at end of query"},
+		{"(tag:a", "expected ) instead of \"end of query\" at end of query"},
+		{"tag:a)", "unexpected \")\" at position 5"},
+		{"AND tag:a", "expected a term instead of \"AND\" at position 0"},
+		{"color:red", "unknown field \"color\" at position 0"},
+		{"tag:", "missing value for tag at position 0"},
+		{"is:muted", "unknown value \"muted\" for is at position 0"},
+		{"since:yesterday", "invalid time \"yesterday\" for since at position 0"},
+		{`"unterminated`, "unterminated quoted string at position 0"},
+		{`"?!"`, "no words to search in"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := ParseQuery(tt.query)
+			assert.ErrorIs(t, err, ErrInvalidQuery)
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestExprString(t *testing.T) {
+	// Formatted queries parse back to the same expression
+	for _, query := range []string{
+		"(tag:event OR sender:system) AND NOT is:read",
+		`title:"Season Pass" AND "gold coins" AND attachment:coins`,
+		"NOT (is:expired OR has:attachments)",
+		"folder:guild AND is:pinned AND NOT is:archived",
+		"since:2026-01-02T00:00:00Z AND expires-before:2026-03-01T10:00:00.5Z",
+	} {
+		expr, err := ParseQuery(query)
+		require.NoError(t, err)
+		assert.Equal(t, query, expr.String())
+	}
+
+	// Filters with several conditions are written as adjacent terms
+	read := false
+	expr := Or(Match(MailFilter{SenderIDs: []string{"a", "b"}, ReadStatus: &read}), Match(MailFilter{}))
+	assert.Equal(t, "(is:unread (sender:a OR sender:b)) OR *", expr.String())
+	reparsed, err := ParseQuery(expr.String())
+	require.NoError(t, err)
+	assert.Equal(t, "(is:unread AND (sender:a OR sender:b)) OR *", reparsed.String())
+
+	assert.Equal(t, "*", And().String())
+	assert.Equal(t, "NOT *", Or().String())
+}
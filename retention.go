@@ -0,0 +1,149 @@
+package inboxer
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SystemSenderID is the sender ID of mails sent by the system, such as system announcements
+const SystemSenderID = "system"
+
+// SystemAnnouncementTag is added to every system announcement
+const SystemAnnouncementTag = "system_announcement"
+
+// RetentionRule limits how long matching mails are kept.
+// Every set condition must match; a rule without conditions matches every mail.
+type RetentionRule struct {
+	Name     string // Name used in logs
+	Priority int    // Rules with a higher priority come first in Rules and Match
+
+	// Conditions
+	Tag                string // Mail has this tag
+	SenderID           string // Mail was sent by this sender
+	PlayerMail         bool   // Mail was sent by a player rather than the system
+	WithoutAttachments bool   // Mail has no attachments
+
+	// Limits, at least one must be set
+	Keep      time.Duration // Mail expires this long after it was created
+	AfterRead time.Duration // Mail expires this long after it was read
+}
+
+// Matches reports whether the rule applies to a mail
+func (r RetentionRule) Matches(mail *Mail) bool {
+	if r.Tag != "" && !containsString(mail.Tags, r.Tag) {
+		return false
+	}
+	if r.SenderID != "" && mail.SenderID != r.SenderID {
+		return false
+	}
+	if r.PlayerMail && (mail.SenderID == "" || mail.SenderID == SystemSenderID) {
+		return false
+	}
+	if r.WithoutAttachments && len(mail.Attachments) > 0 {
+		return false
+	}
+	return true
+}
+
+// RetentionPolicy is a set of retention rules ordered by priority.
+// Every matching rule limits the lifetime of a mail, so the tightest limit applies.
+type RetentionPolicy struct {
+	rules []RetentionRule
+}
+
+// NewRetentionPolicy validates the rules and orders them by descending priority.
+// Rules with the same priority keep the order in which they were given.
+func NewRetentionPolicy(rules ...RetentionRule) (*RetentionPolicy, error) {
+	sorted := make([]RetentionRule, len(rules))
+	copy(sorted, rules)
+
+	for i, rule := range sorted {
+		if rule.Keep < 0 || rule.AfterRead < 0 {
+			return nil, fmt.Errorf("retention rule %d (%s): durations cannot be negative", i, rule.Name)
+		}
+		if rule.Keep == 0 && rule.AfterRead == 0 {
+			return nil, fmt.Errorf("retention rule %d (%s): keep or after read duration is required", i, rule.Name)
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority > sorted[j].Priority
+	})
+
+	return &RetentionPolicy{rules: sorted}, nil
+}
+
+// Rules returns the rules in evaluation order
+func (p *RetentionPolicy) Rules() []RetentionRule {
+	rules := make([]RetentionRule, len(p.rules))
+	copy(rules, p.rules)
+	return rules
+}
+
+// Match returns the first rule that applies to a mail
+func (p *RetentionPolicy) Match(mail *Mail) (RetentionRule, bool) {
+	if p != nil && mail != nil {
+		for _, rule := range p.rules {
+			if rule.Matches(mail) {
+				return rule, true
+			}
+		}
+	}
+	return RetentionRule{}, false
+}
+
+// ExpireTime returns the expiration time of a mail under the policy.
+// Rules can only shorten the lifetime of a mail: the earliest of the mail's own expiration time and,
+// for every matching rule, its creation time plus Keep and, once read, its read time plus AfterRead is used.
+func (p *RetentionPolicy) ExpireTime(mail *Mail) time.Time {
+	expireTime := mail.ExpireTime
+	if p == nil || mail == nil {
+		return expireTime
+	}
+
+	for _, rule := range p.rules {
+		if !rule.Matches(mail) {
+			continue
+		}
+		if rule.Keep > 0 && !mail.CreateTime.IsZero() {
+			expireTime = earliestExpireTime(expireTime, mail.CreateTime.Add(rule.Keep))
+		}
+		if rule.AfterRead > 0 && mail.ReadStatus && !mail.ReadTime.IsZero() {
+			expireTime = earliestExpireTime(expireTime, mail.ReadTime.Add(rule.AfterRead))
+		}
+	}
+
+	return expireTime
+}
+
+// hasAfterRead reports whether any rule depends on the read time of mails
+func (p *RetentionPolicy) hasAfterRead() bool {
+	if p == nil {
+		return false
+	}
+	for _, rule := range p.rules {
+		if rule.AfterRead > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// earliestExpireTime returns the earlier of two expiration times, where zero means never
+func earliestExpireTime(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package inboxer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRetentionRules are the example rules used throughout the retention tests
+func testRetentionRules() []RetentionRule {
+	return []RetentionRule{
+		{Name: "player", PlayerMail: true, Keep: 90 * 24 * time.Hour},
+		{Name: "announcement", Priority: 10, Tag: SystemAnnouncementTag, Keep: 30 * 24 * time.Hour},
+		{Name: "read", Priority: 20, WithoutAttachments: true, AfterRead: 7 * 24 * time.Hour},
+	}
+}
+
+func TestNewRetentionPolicy(t *testing.T) {
+	// Rules are ordered by priority, keeping the given order for equal priorities
+	policy, err := NewRetentionPolicy(append(testRetentionRules(),
+		RetentionRule{Name: "fallback", Keep: time.Hour},
+	)...)
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, rule := range policy.Rules() {
+		names = append(names, rule.Name)
+	}
+	assert.Equal(t, []string{"read", "announcement", "player", "fallback"}, names)
+
+	// Test invalid rules
+	_, err = NewRetentionPolicy(RetentionRule{Name: "empty"})
+	assert.Error(t, err)
+	_, err = NewRetentionPolicy(RetentionRule{Name: "negative", Keep: -time.Hour})
+	assert.Error(t, err)
+}
+
+func TestRetentionRuleMatches(t *testing.T) {
+	rule := RetentionRule{Tag: "event", SenderID: "npc1", WithoutAttachments: true, Keep: time.Hour}
+	assert.True(t, rule.Matches(&Mail{SenderID: "npc1", Tags: []string{"event"}}))
+	assert.False(t, rule.Matches(&Mail{SenderID: "npc2", Tags: []string{"event"}}))
+	assert.False(t, rule.Matches(&Mail{SenderID: "npc1"}))
+	assert.False(t, rule.Matches(&Mail{SenderID: "npc1", Tags: []string{"event"}, Attachments: map[string]interface{}{"coins": 1}}))
+
+	player := RetentionRule{PlayerMail: true, Keep: time.Hour}
+	assert.True(t, player.Matches(&Mail{SenderID: "player1"}))
+	assert.False(t, player.Matches(&Mail{SenderID: SystemSenderID}))
+	assert.False(t, player.Matches(&Mail{}))
+}
+
+func TestRetentionPolicyExpireTime(t *testing.T) {
+	policy, err := NewRetentionPolicy(testRetentionRules()...)
+	require.NoError(t, err)
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	attachments := map[string]interface{}{"coins": 100}
+
+	tests := []struct {
+		name string
+		mail *Mail
+		want time.Time
+	}{
+		{
+			name: "player mail is kept 90 days",
+			mail: &Mail{SenderID: "player1", CreateTime: now, Attachments: attachments},
+			want: now.Add(90 * day),
+		},
+		{
+			name: "announcement is kept 30 days",
+			mail: &Mail{SenderID: SystemSenderID, CreateTime: now, Tags: []string{SystemAnnouncementTag}, Attachments: attachments},
+			want: now.Add(30 * day),
+		},
+		{
+			name: "shorter expire time set by the sender is kept",
+			mail: &Mail{SenderID: "player1", CreateTime: now, ExpireTime: now.Add(day), Attachments: attachments},
+			want: now.Add(day),
+		},
+		{
+			name: "unread player mail without attachments is kept 90 days",
+			mail: &Mail{SenderID: "player1", CreateTime: now},
+			want: now.Add(90 * day),
+		},
+		{
+			name: "unread system mail without attachments does not expire yet",
+			mail: &Mail{SenderID: SystemSenderID, CreateTime: now},
+			want: time.Time{},
+		},
+		{
+			name: "read mail without attachments expires 7 days after read",
+			mail: &Mail{SenderID: "player1", CreateTime: now, ReadStatus: true, ReadTime: now.Add(2 * day)},
+			want: now.Add(9 * day),
+		},
+		{
+			name: "system mail matching no rule is unchanged",
+			mail: &Mail{SenderID: SystemSenderID, CreateTime: now, Attachments: attachments},
+			want: time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, policy.ExpireTime(tt.mail))
+		})
+	}
+
+	// Overlapping rules all apply and the tightest limit wins
+	announcement := &Mail{SenderID: SystemSenderID, CreateTime: now, Tags: []string{SystemAnnouncementTag}}
+	assert.Equal(t, now.Add(30*day), policy.ExpireTime(announcement))
+	announcement.ReadStatus = true
+	announcement.ReadTime = now.Add(day)
+	assert.Equal(t, now.Add(8*day), policy.ExpireTime(announcement))
+	announcement.ReadTime = now.Add(29 * day)
+	assert.Equal(t, now.Add(30*day), policy.ExpireTime(announcement))
+
+	// A nil policy keeps the mail's own expire time
+	var none *RetentionPolicy
+	assert.Equal(t, now, none.ExpireTime(&Mail{ExpireTime: now}))
+}
+
+func TestManagerRetentionPolicy(t *testing.T) {
+	ctx := context.Background()
+	policy, err := NewRetentionPolicy(testRetentionRules()...)
+	require.NoError(t, err)
+	manager := NewDefaultMailManager(NewMemoryMailStore(), WithRetentionPolicy(policy))
+
+	// Sending applies the retention period
+	id, err := manager.SendMail(ctx, &Mail{SenderID: "player1", RecipientID: "user1", Attachments: map[string]interface{}{"gift": 1}})
+	require.NoError(t, err)
+	mail, err := manager.GetMailByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, mail.CreateTime.Add(90*24*time.Hour), mail.ExpireTime)
+
+	id, err = manager.SendSystemAnnouncement(ctx, &Mail{Title: "Maintenance", Attachments: map[string]interface{}{"coins": 10}})
+	require.NoError(t, err)
+	mail, err = manager.GetMailByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, mail.CreateTime.Add(30*24*time.Hour), mail.ExpireTime)
+
+	// Reading applies the after read period
+	id, err = manager.SendMail(ctx, &Mail{SenderID: "player1", RecipientID: "user1"})
+	require.NoError(t, err)
+	require.NoError(t, manager.MarkAsRead(ctx, id))
+	mail, err = manager.GetMailByID(ctx, id)
+	require.NoError(t, err)
+	assert.False(t, mail.ReadTime.IsZero())
+	assert.Equal(t, mail.ReadTime.Add(7*24*time.Hour), mail.ExpireTime)
+
+	// Marking everything as read applies the policy to every mail
+	_, err = manager.SendBatchMail(ctx, &Mail{SenderID: "player2"}, []string{"user2", "user2", "user2"})
+	require.NoError(t, err)
+	require.NoError(t, manager.MarkAllAsRead(ctx, "user2"))
+	mails, _, err := manager.GetMailsByRecipient(ctx, "user2", 1, 10)
+	require.NoError(t, err)
+	require.Len(t, mails, 3)
+	for _, mail := range mails {
+		assert.True(t, mail.ReadStatus)
+		assert.Equal(t, mail.ReadTime.Add(7*24*time.Hour), mail.ExpireTime)
+	}
+}
+
+func TestManagerCleanupAppliesRetentionPolicy(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	day := 24 * time.Hour
+	attachments := map[string]interface{}{"coins": 100}
+	store := NewMemoryMailStore()
+
+	// Mails stored without the policy, as before it was configured or by an import
+	ids, err := store.CreateBatchMails(ctx, []*Mail{
+		{SenderID: "player1", RecipientID: "user1", CreateTime: now.Add(-100 * day), Attachments: attachments},
+		{SenderID: "player1", RecipientID: "user1", CreateTime: now.Add(-10 * day), Attachments: attachments},
+		{SenderID: SystemSenderID, RecipientID: "user1", CreateTime: now.Add(-100 * day), Attachments: attachments},
+	})
+	require.NoError(t, err)
+
+	policy, err := NewRetentionPolicy(testRetentionRules()...)
+	require.NoError(t, err)
+	manager := NewDefaultMailManager(store, WithRetentionPolicy(policy))
+
+	// The cleanup deletes mails past the retention deadline and brings the others forward
+	count, err := manager.DeleteExpiredMails(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	_, err = store.GetMail(ctx, ids[0])
+	assert.ErrorIs(t, err, ErrMailNotFound)
+	mail, err := store.GetMail(ctx, ids[1])
+	require.NoError(t, err)
+	assert.Equal(t, mail.CreateTime.Add(90*day), mail.ExpireTime)
+	mail, err = store.GetMail(ctx, ids[2])
+	require.NoError(t, err)
+	assert.True(t, mail.ExpireTime.IsZero())
+
+	// A tighter policy applies to the stored mails on the next run
+	policy, err = NewRetentionPolicy(RetentionRule{Name: "short", Keep: 5 * day})
+	require.NoError(t, err)
+	manager = NewDefaultMailManager(store, WithRetentionPolicy(policy))
+	count, err = manager.DeleteExpiredMails(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
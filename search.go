@@ -0,0 +1,150 @@
+package inboxer
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// titleWeight is how much more a word in the title counts than a word in the content
+const titleWeight = 2
+
+// BM25 parameters for ranking search results
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// tokenize splits text into lowercase words of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// searchWords returns the distinct words of a text query in order
+func searchWords(text string) []string {
+	words := []string{}
+	seen := make(map[string]bool)
+	for _, word := range tokenize(text) {
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// filterWords returns the words of the filter's text query, if any
+func filterWords(filter *MailFilter) []string {
+	if filter == nil {
+		return nil
+	}
+	return searchWords(filter.Text)
+}
+
+// mailTerms returns the weighted frequency of every word of a mail and the weighted word count
+func mailTerms(mail *Mail) (map[string]int, int) {
+	terms := make(map[string]int)
+	length := 0
+	for _, word := range tokenize(mail.Title) {
+		terms[word] += titleWeight
+		length += titleWeight
+	}
+	for _, word := range tokenize(mail.Content) {
+		terms[word]++
+		length++
+	}
+	return terms, length
+}
+
+// searchIndex is an inverted index over mail titles and contents
+type searchIndex struct {
+	postings map[string]map[string]int // Word to mail ID to weighted frequency
+	lengths  map[string]int            // Mail ID to weighted word count
+	total    int                       // Sum of all lengths
+}
+
+// newSearchIndex creates an empty search index
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[string]int),
+		lengths:  make(map[string]int),
+	}
+}
+
+// add indexes a mail
+func (idx *searchIndex) add(mail *Mail) {
+	terms, length := mailTerms(mail)
+	for word, freq := range terms {
+		posting, ok := idx.postings[word]
+		if !ok {
+			posting = make(map[string]int)
+			idx.postings[word] = posting
+		}
+		posting[mail.ID] = freq
+	}
+	idx.lengths[mail.ID] = length
+	idx.total += length
+}
+
+// remove drops a mail from the index, it must be the same content that was added
+func (idx *searchIndex) remove(mail *Mail) {
+	length, ok := idx.lengths[mail.ID]
+	if !ok {
+		return
+	}
+
+	terms, _ := mailTerms(mail)
+	for word := range terms {
+		posting := idx.postings[word]
+		delete(posting, mail.ID)
+		if len(posting) == 0 {
+			delete(idx.postings, word)
+		}
+	}
+	delete(idx.lengths, mail.ID)
+	idx.total -= length
+}
+
+// search returns the BM25 score of every mail containing all words
+func (idx *searchIndex) search(words []string) map[string]float64 {
+	if len(words) == 0 {
+		return map[string]float64{}
+	}
+
+	// Intersect starting from the rarest word
+	sorted := make([]string, len(words))
+	copy(sorted, words)
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(idx.postings[sorted[i]]) < len(idx.postings[sorted[j]])
+	})
+
+	scores := make(map[string]float64)
+	for id := range idx.postings[sorted[0]] {
+		scores[id] = 0
+	}
+	for _, word := range sorted[1:] {
+		posting := idx.postings[word]
+		for id := range scores {
+			if _, ok := posting[id]; !ok {
+				delete(scores, id)
+			}
+		}
+	}
+
+	docs := float64(len(idx.lengths))
+	avgLength := float64(idx.total) / math.Max(docs, 1)
+	for _, word := range words {
+		posting := idx.postings[word]
+		df := float64(len(posting))
+		idf := math.Log(1 + (docs-df+0.5)/(df+0.5))
+		for id := range scores {
+			tf := float64(posting[id])
+			norm := 1 - bm25B + bm25B*float64(idx.lengths[id])/math.Max(avgLength, 1)
+			scores[id] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+	return scores
+}
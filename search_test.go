@@ -0,0 +1,45 @@
+package inboxer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"season", "2", "rewards", "are", "here"}, tokenize("Season 2 rewards: are HERE!"))
+	assert.Equal(t, []string{"héros", "日本"}, tokenize("Héros, 日本"))
+	assert.Empty(t, tokenize(" -- !! "))
+
+	// Query words are distinct and keep their order
+	assert.Equal(t, []string{"gold", "coins"}, searchWords("Gold coins gold"))
+}
+
+func TestSearchIndex(t *testing.T) {
+	idx := newSearchIndex()
+	titleMatch := &Mail{ID: "1", Title: "Gold reward", Content: "Claim it today"}
+	contentMatch := &Mail{ID: "2", Title: "Weekly news", Content: "Some gold for everyone"}
+	noMatch := &Mail{ID: "3", Title: "Maintenance", Content: "Servers are down"}
+	idx.add(titleMatch)
+	idx.add(contentMatch)
+	idx.add(noMatch)
+
+	// Title matches outrank content matches
+	scores := idx.search([]string{"gold"})
+	assert.Len(t, scores, 2)
+	assert.Greater(t, scores["1"], scores["2"])
+
+	// Every word must match
+	scores = idx.search([]string{"gold", "everyone"})
+	assert.Len(t, scores, 1)
+	assert.Contains(t, scores, "2")
+	assert.Empty(t, idx.search([]string{"gold", "missing"}))
+	assert.Empty(t, idx.search(nil))
+
+	// Removed mails are no longer found
+	idx.remove(titleMatch)
+	scores = idx.search([]string{"gold"})
+	assert.Len(t, scores, 1)
+	assert.Contains(t, scores, "2")
+	assert.Equal(t, 8+5, idx.total)
+}
@@ -0,0 +1,128 @@
+package inboxer
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// SortOrder is the order of the mails returned by QueryMails.
+// Every order puts pinned mails first and ends with a unique key, so pages never overlap or skip mails.
+type SortOrder string
+
+const (
+	SortNewest          SortOrder = "newest"           // Newest first, the default without a text query
+	SortOldest          SortOrder = "oldest"           // Oldest first
+	SortUnreadFirst     SortOrder = "unread_first"     // Unread before read, then newest first
+	SortPriority        SortOrder = "priority"         // Highest priority first, then newest first
+	SortExpiringSoonest SortOrder = "expiring_soonest" // Soonest expiration first and mails that never expire last, then newest first
+)
+
+// validate checks that the sort order is known, the empty order is the default
+func (o SortOrder) validate() error {
+	switch o {
+	case "", SortNewest, SortOldest, SortUnreadFirst, SortPriority, SortExpiringSoonest:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown sort order %q", ErrInvalidQuery, o)
+}
+
+// optionalSort returns the sort order passed as an optional argument, SortNewest if none was given
+func optionalSort(orders []SortOrder) (SortOrder, error) {
+	if len(orders) == 0 || orders[0] == "" {
+		return SortNewest, nil
+	}
+	if len(orders) > 1 {
+		return "", fmt.Errorf("%w: more than one sort order", ErrInvalidQuery)
+	}
+	return orders[0], orders[0].validate()
+}
+
+// filterSort returns the sort order of a filter
+func filterSort(filter *MailFilter) SortOrder {
+	if filter == nil {
+		return ""
+	}
+	return filter.Sort
+}
+
+// compareMails compares two mails in a sort order, returning a negative number if a comes first
+func compareMails(order SortOrder, a, b *Mail) int {
+	if c := comparePinned(a, b); c != 0 {
+		return c
+	}
+
+	switch order {
+	case SortOldest:
+		if c := a.CreateTime.Compare(b.CreateTime); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	case SortUnreadFirst:
+		if a.ReadStatus != b.ReadStatus {
+			if a.ReadStatus {
+				return 1
+			}
+			return -1
+		}
+	case SortPriority:
+		if c := cmp.Compare(b.Priority, a.Priority); c != 0 {
+			return c
+		}
+	case SortExpiringSoonest:
+		if a.ExpireTime.IsZero() != b.ExpireTime.IsZero() {
+			if a.ExpireTime.IsZero() {
+				return 1
+			}
+			return -1
+		}
+		if c := a.ExpireTime.Compare(b.ExpireTime); c != 0 {
+			return c
+		}
+	}
+
+	// Newest first
+	if c := b.CreateTime.Compare(a.CreateTime); c != 0 {
+		return c
+	}
+	return strings.Compare(b.ID, a.ID)
+}
+
+// comparePinned compares whether two mails are pinned, returning a negative number if only a is
+func comparePinned(a, b *Mail) int {
+	switch {
+	case a.Pinned == b.Pinned:
+		return 0
+	case a.Pinned:
+		return -1
+	}
+	return 1
+}
+
+// newestFirstSQL is the SQL ordering of SortNewest, which ends every other order
+const newestFirstSQL = "create_time DESC, id DESC"
+
+// pinnedFirstSQL starts every SQL ordering
+const pinnedFirstSQL = "pinned DESC, "
+
+// sortOrderBy returns the SQL ordering of a sort order.
+// It is a single expression, because GORM drops an expression order when more columns are added.
+func sortOrderBy(order SortOrder) clause.OrderBy {
+	switch order {
+	case SortOldest:
+		return clause.OrderBy{Expression: clause.Expr{SQL: pinnedFirstSQL + "create_time, id"}}
+	case SortUnreadFirst:
+		return clause.OrderBy{Expression: clause.Expr{SQL: pinnedFirstSQL + "read_status, " + newestFirstSQL}}
+	case SortPriority:
+		return clause.OrderBy{Expression: clause.Expr{SQL: pinnedFirstSQL + "priority DESC, " + newestFirstSQL}}
+	case SortExpiringSoonest:
+		return clause.OrderBy{Expression: clause.Expr{
+			SQL:  pinnedFirstSQL + "CASE WHEN expire_time = ? THEN 1 ELSE 0 END, expire_time, " + newestFirstSQL,
+			Vars: []any{time.Time{}},
+		}}
+	}
+	return clause.OrderBy{Expression: clause.Expr{SQL: pinnedFirstSQL + newestFirstSQL}}
+}
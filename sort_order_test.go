@@ -0,0 +1,49 @@
+package inboxer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortOrderValidate(t *testing.T) {
+	for _, order := range []SortOrder{"", SortNewest, SortOldest, SortUnreadFirst, SortPriority, SortExpiringSoonest} {
+		assert.NoError(t, order.validate())
+	}
+	assert.ErrorIs(t, SortOrder("random").validate(), ErrInvalidQuery)
+}
+
+func TestCompareMails(t *testing.T) {
+	now := time.Now()
+	older := &Mail{ID: "1", CreateTime: now.Add(-time.Hour)}
+	newer := &Mail{ID: "2", CreateTime: now}
+	sameTime := &Mail{ID: "3", CreateTime: now}
+
+	assert.Negative(t, compareMails(SortNewest, newer, older))
+	assert.Negative(t, compareMails("", newer, older))
+	assert.Negative(t, compareMails(SortOldest, older, newer))
+
+	// Equal times fall back to the ID
+	assert.Negative(t, compareMails(SortNewest, sameTime, newer))
+	assert.Negative(t, compareMails(SortOldest, newer, sameTime))
+
+	read := &Mail{ID: "4", CreateTime: now, ReadStatus: true}
+	assert.Negative(t, compareMails(SortUnreadFirst, older, read))
+
+	important := &Mail{ID: "5", CreateTime: now.Add(-2 * time.Hour), Priority: 10}
+	assert.Negative(t, compareMails(SortPriority, important, newer))
+
+	// Mails that never expire come last
+	expiring := &Mail{ID: "6", CreateTime: now.Add(-3 * time.Hour), ExpireTime: now.Add(time.Hour)}
+	expiringLater := &Mail{ID: "7", CreateTime: now, ExpireTime: now.Add(2 * time.Hour)}
+	assert.Negative(t, compareMails(SortExpiringSoonest, expiring, expiringLater))
+	assert.Negative(t, compareMails(SortExpiringSoonest, expiringLater, newer))
+
+	// Pinned mails come first in every order
+	pinned := &Mail{ID: "8", CreateTime: now.Add(-4 * time.Hour), Pinned: true}
+	for _, order := range []SortOrder{SortNewest, SortOldest, SortUnreadFirst, SortPriority, SortExpiringSoonest} {
+		assert.Negative(t, compareMails(order, pinned, newer), order)
+		assert.Negative(t, compareMails(order, pinned, important), order)
+	}
+}
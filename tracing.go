@@ -0,0 +1,285 @@
+package inboxer
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name used for all inboxer spans
+const tracerName = "github.com/weedbox/inboxer"
+
+// Span attribute keys used by inboxer
+const (
+	AttrMailID          = attribute.Key("inboxer.mail_id")
+	AttrRecipientID     = attribute.Key("inboxer.recipient_id")
+	AttrSenderID        = attribute.Key("inboxer.sender_id")
+	AttrMailCount       = attribute.Key("inboxer.mail_count")
+	AttrBatch           = attribute.Key("inboxer.batch")
+	AttrPage            = attribute.Key("inboxer.page")
+	AttrPageSize        = attribute.Key("inboxer.page_size")
+	AttrFilterSender    = attribute.Key("inboxer.filter.sender_id")
+	AttrFilterRecipient = attribute.Key("inboxer.filter.recipient_id")
+	AttrFilterRead      = attribute.Key("inboxer.filter.read_status")
+	AttrFilterTags      = attribute.Key("inboxer.filter.tags")
+	AttrFilterExpired   = attribute.Key("inboxer.filter.expired_only")
+	AttrFilterQuery     = attribute.Key("inboxer.filter.query")
+	AttrFilterFolder    = attribute.Key("inboxer.filter.folder")
+	AttrGroupBy         = attribute.Key("inboxer.group_by")
+	AttrFolder          = attribute.Key("inboxer.folder")
+)
+
+// newTracer returns the inboxer tracer from the given provider, or from the global provider if nil
+func newTracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// endSpan records the outcome of an operation and ends its span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// filterAttributes converts the set fields of a filter into span attributes
+func filterAttributes(filter *MailFilter) []attribute.KeyValue {
+	if filter == nil {
+		return nil
+	}
+
+	attrs := []attribute.KeyValue{}
+	if filter.SenderID != "" {
+		attrs = append(attrs, AttrFilterSender.String(filter.SenderID))
+	}
+	if filter.RecipientID != "" {
+		attrs = append(attrs, AttrFilterRecipient.String(filter.RecipientID))
+	}
+	if filter.ReadStatus != nil {
+		attrs = append(attrs, AttrFilterRead.Bool(*filter.ReadStatus))
+	}
+	if len(filter.Tags) > 0 {
+		attrs = append(attrs, AttrFilterTags.StringSlice(filter.Tags))
+	}
+	if filter.ExpiredOnly {
+		attrs = append(attrs, AttrFilterExpired.Bool(true))
+	}
+	if filter.Folder != "" {
+		attrs = append(attrs, AttrFilterFolder.String(filter.Folder))
+	}
+	if filter.Expr != nil {
+		attrs = append(attrs, AttrFilterQuery.String(filter.Expr.String()))
+	}
+
+	return attrs
+}
+
+// TracingMailStore wraps a MailStore and creates a span for every call
+type TracingMailStore struct {
+	next   MailStore
+	tracer trace.Tracer
+}
+
+// NewTracingMailStore creates a MailStore that traces calls to next using the given tracer provider
+func NewTracingMailStore(next MailStore, tp trace.TracerProvider) *TracingMailStore {
+	return &TracingMailStore{
+		next:   next,
+		tracer: newTracer(tp),
+	}
+}
+
+// start starts a span for a store method
+func (s *TracingMailStore) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "MailStore."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// CreateMail creates a new mail and returns the mail ID
+func (s *TracingMailStore) CreateMail(ctx context.Context, mail *Mail) (id string, err error) {
+	ctx, span := s.start(ctx, "CreateMail")
+	defer func() { endSpan(span, err) }()
+
+	if mail != nil {
+		span.SetAttributes(AttrRecipientID.String(mail.RecipientID))
+	}
+	id, err = s.next.CreateMail(ctx, mail)
+	span.SetAttributes(AttrMailID.String(id))
+	return id, err
+}
+
+// GetMail retrieves a mail by ID
+func (s *TracingMailStore) GetMail(ctx context.Context, mailID string) (mail *Mail, err error) {
+	ctx, span := s.start(ctx, "GetMail", AttrMailID.String(mailID))
+	defer func() { endSpan(span, err) }()
+	return s.next.GetMail(ctx, mailID)
+}
+
+// UpdateMail updates an existing mail
+func (s *TracingMailStore) UpdateMail(ctx context.Context, mail *Mail) (err error) {
+	ctx, span := s.start(ctx, "UpdateMail")
+	defer func() { endSpan(span, err) }()
+
+	if mail != nil {
+		span.SetAttributes(AttrMailID.String(mail.ID))
+	}
+	return s.next.UpdateMail(ctx, mail)
+}
+
+// DeleteMail deletes a mail by ID
+func (s *TracingMailStore) DeleteMail(ctx context.Context, mailID string) (err error) {
+	ctx, span := s.start(ctx, "DeleteMail", AttrMailID.String(mailID))
+	defer func() { endSpan(span, err) }()
+	return s.next.DeleteMail(ctx, mailID)
+}
+
+// CreateBatchMails creates multiple mails in batch
+func (s *TracingMailStore) CreateBatchMails(ctx context.Context, mails []*Mail) (ids []string, err error) {
+	ctx, span := s.start(ctx, "CreateBatchMails", AttrMailCount.Int(len(mails)))
+	defer func() { endSpan(span, err) }()
+	return s.next.CreateBatchMails(ctx, mails)
+}
+
+// DeleteMailsByRecipient deletes all mails for a specific recipient
+func (s *TracingMailStore) DeleteMailsByRecipient(ctx context.Context, recipientID string) (err error) {
+	ctx, span := s.start(ctx, "DeleteMailsByRecipient", AttrRecipientID.String(recipientID))
+	defer func() { endSpan(span, err) }()
+	return s.next.DeleteMailsByRecipient(ctx, recipientID)
+}
+
+// DeleteExpiredMails deletes all expired mails
+func (s *TracingMailStore) DeleteExpiredMails(ctx context.Context, beforeTime time.Time) (count int, err error) {
+	ctx, span := s.start(ctx, "DeleteExpiredMails")
+	defer func() { endSpan(span, err) }()
+
+	count, err = s.next.DeleteExpiredMails(ctx, beforeTime)
+	span.SetAttributes(AttrMailCount.Int(count))
+	return count, err
+}
+
+// DeleteExpiredMailsBatched deletes expired mails in batches, recording an event for every batch
+func (s *TracingMailStore) DeleteExpiredMailsBatched(ctx context.Context, beforeTime time.Time, opts ExpiredDeleteOptions) (count int, err error) {
+	ctx, span := s.start(ctx, "DeleteExpiredMailsBatched")
+	defer func() { endSpan(span, err) }()
+
+	onProgress := opts.OnProgress
+	opts.OnProgress = func(progress ExpiredDeleteProgress) {
+		span.AddEvent("batch deleted", trace.WithAttributes(
+			AttrBatch.Int(progress.Batch),
+			AttrMailCount.Int(progress.Deleted),
+		))
+		if onProgress != nil {
+			onProgress(progress)
+		}
+	}
+
+	count, err = s.next.DeleteExpiredMailsBatched(ctx, beforeTime, opts)
+	span.SetAttributes(AttrMailCount.Int(count))
+	return count, err
+}
+
+// UpdateByFilter applies a patch to all mails matching the filter
+func (s *TracingMailStore) UpdateByFilter(ctx context.Context, filter *MailFilter, patch *MailPatch) (count int, err error) {
+	ctx, span := s.start(ctx, "UpdateByFilter", filterAttributes(filter)...)
+	defer func() { endSpan(span, err) }()
+
+	count, err = s.next.UpdateByFilter(ctx, filter, patch)
+	span.SetAttributes(AttrMailCount.Int(count))
+	return count, err
+}
+
+// DeleteByFilter deletes all mails matching the filter
+func (s *TracingMailStore) DeleteByFilter(ctx context.Context, filter *MailFilter) (count int, err error) {
+	ctx, span := s.start(ctx, "DeleteByFilter", filterAttributes(filter)...)
+	defer func() { endSpan(span, err) }()
+
+	count, err = s.next.DeleteByFilter(ctx, filter)
+	span.SetAttributes(AttrMailCount.Int(count))
+	return count, err
+}
+
+// GetMailsByRecipient retrieves mails for a specific recipient with pagination
+func (s *TracingMailStore) GetMailsByRecipient(ctx context.Context, recipientID string, page, size int, order ...SortOrder) (mails []*Mail, total int, err error) {
+	ctx, span := s.start(ctx, "GetMailsByRecipient",
+		AttrRecipientID.String(recipientID),
+		AttrPage.Int(page),
+		AttrPageSize.Int(size),
+	)
+	defer func() { endSpan(span, err) }()
+
+	mails, total, err = s.next.GetMailsByRecipient(ctx, recipientID, page, size, order...)
+	span.SetAttributes(AttrMailCount.Int(len(mails)))
+	return mails, total, err
+}
+
+// QueryMails queries mails by filter conditions with pagination
+func (s *TracingMailStore) QueryMails(ctx context.Context, filter *MailFilter, page, size int) (mails []*Mail, total int, err error) {
+	attrs := append(filterAttributes(filter), AttrPage.Int(page), AttrPageSize.Int(size))
+	ctx, span := s.start(ctx, "QueryMails", attrs...)
+	defer func() { endSpan(span, err) }()
+
+	mails, total, err = s.next.QueryMails(ctx, filter, page, size)
+	span.SetAttributes(AttrMailCount.Int(len(mails)))
+	return mails, total, err
+}
+
+// ListExpiringBetween lists mails expiring in [from, to)
+func (s *TracingMailStore) ListExpiringBetween(ctx context.Context, recipientID string, from, to time.Time) (mails []*Mail, err error) {
+	ctx, span := s.start(ctx, "ListExpiringBetween", AttrRecipientID.String(recipientID))
+	defer func() { endSpan(span, err) }()
+
+	mails, err = s.next.ListExpiringBetween(ctx, recipientID, from, to)
+	span.SetAttributes(AttrMailCount.Int(len(mails)))
+	return mails, err
+}
+
+// ScanMails calls fn with pages of mails matching the filter in ID order
+func (s *TracingMailStore) ScanMails(ctx context.Context, filter *MailFilter, opts ScanOptions, fn func(mails []*Mail) error) (err error) {
+	ctx, span := s.start(ctx, "ScanMails", filterAttributes(filter)...)
+	defer func() { endSpan(span, err) }()
+
+	count := 0
+	err = s.next.ScanMails(ctx, filter, opts, func(mails []*Mail) error {
+		count += len(mails)
+		return fn(mails)
+	})
+	span.SetAttributes(AttrMailCount.Int(count))
+	return err
+}
+
+// CountUnreadMails counts the number of unread mails for a specific recipient
+func (s *TracingMailStore) CountUnreadMails(ctx context.Context, recipientID string) (count int, err error) {
+	ctx, span := s.start(ctx, "CountUnreadMails", AttrRecipientID.String(recipientID))
+	defer func() { endSpan(span, err) }()
+	return s.next.CountUnreadMails(ctx, recipientID)
+}
+
+// CountMailsWithAttachments counts the number of mails with attachments for a specific recipient
+func (s *TracingMailStore) CountMailsWithAttachments(ctx context.Context, recipientID string) (count int, err error) {
+	ctx, span := s.start(ctx, "CountMailsWithAttachments", AttrRecipientID.String(recipientID))
+	defer func() { endSpan(span, err) }()
+	return s.next.CountMailsWithAttachments(ctx, recipientID)
+}
+
+// CountBy counts the mails matching the filter per group
+func (s *TracingMailStore) CountBy(ctx context.Context, filter *MailFilter, groupBy GroupBy) (groups []GroupCount, err error) {
+	ctx, span := s.start(ctx, "CountBy", append(filterAttributes(filter), AttrGroupBy.String(string(groupBy)))...)
+	defer func() { endSpan(span, err) }()
+	return s.next.CountBy(ctx, filter, groupBy)
+}
+
+// ExportMailLogs exports mail logs based on filter
+func (s *TracingMailStore) ExportMailLogs(ctx context.Context, filter *MailFilter) (logs string, err error) {
+	ctx, span := s.start(ctx, "ExportMailLogs", filterAttributes(filter)...)
+	defer func() { endSpan(span, err) }()
+	return s.next.ExportMailLogs(ctx, filter)
+}
@@ -0,0 +1,150 @@
+package inboxer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// setupTracing creates a tracer provider that records spans in memory
+func setupTracing(t *testing.T) (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() {
+		_ = tp.Shutdown(context.Background())
+	})
+	return tp, exporter
+}
+
+// findSpan returns the first recorded span with the given name
+func findSpan(spans tracetest.SpanStubs, name string) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name == name {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+// spanAttribute returns the value of an attribute of a span
+func spanAttribute(span *tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestManagerTracing(t *testing.T) {
+	tp, exporter := setupTracing(t)
+	manager := NewDefaultMailManager(NewMemoryMailStore(), WithTracerProvider(tp))
+
+	// Spans are children of the caller's span
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	id, err := manager.SendMail(ctx, &Mail{SenderID: "system", RecipientID: "user1"})
+	require.NoError(t, err)
+	_, err = manager.SendBatchMail(ctx, &Mail{SenderID: "system"}, []string{"user2", "user3"})
+	require.NoError(t, err)
+	read := false
+	_, _, err = manager.QueryMails(ctx, &MailFilter{RecipientID: "user1", ReadStatus: &read, Expr: Not(Match(MailFilter{Tags: []string{"event"}}))}, 1, 10)
+	require.NoError(t, err)
+	parent.End()
+
+	spans := exporter.GetSpans()
+
+	send := findSpan(spans, "MailManager.SendMail")
+	require.NotNil(t, send)
+	assert.Equal(t, parent.SpanContext().SpanID(), send.Parent.SpanID())
+	assert.Equal(t, "user1", spanAttribute(send, AttrRecipientID).AsString())
+
+	// The store call is a child of the manager span
+	create := findSpan(spans, "MailStore.CreateMail")
+	require.NotNil(t, create)
+	assert.Equal(t, send.SpanContext.SpanID(), create.Parent.SpanID())
+	assert.Equal(t, id, spanAttribute(create, AttrMailID).AsString())
+
+	batch := findSpan(spans, "MailManager.SendBatchMail")
+	require.NotNil(t, batch)
+	assert.Equal(t, int64(2), spanAttribute(batch, AttrMailCount).AsInt64())
+	assert.NotNil(t, findSpan(spans, "MailStore.CreateBatchMails"))
+
+	query := findSpan(spans, "MailManager.QueryMails")
+	require.NotNil(t, query)
+	assert.Equal(t, "user1", spanAttribute(query, AttrFilterRecipient).AsString())
+	assert.False(t, spanAttribute(query, AttrFilterRead).AsBool())
+	assert.Equal(t, "NOT tag:event", spanAttribute(query, AttrFilterQuery).AsString())
+	storeQuery := findSpan(spans, "MailStore.QueryMails")
+	require.NotNil(t, storeQuery)
+	assert.Equal(t, int64(1), spanAttribute(storeQuery, AttrMailCount).AsInt64())
+}
+
+func TestManagerTracingErrors(t *testing.T) {
+	tp, exporter := setupTracing(t)
+	manager := NewDefaultMailManager(NewMemoryMailStore(), WithTracerProvider(tp))
+	ctx := context.Background()
+
+	_, err := manager.GetMailByID(ctx, "missing")
+	assert.Error(t, err)
+
+	spans := exporter.GetSpans()
+	get := findSpan(spans, "MailManager.GetMailByID")
+	require.NotNil(t, get)
+	assert.Equal(t, codes.Error, get.Status.Code)
+	assert.Equal(t, "missing", spanAttribute(get, AttrMailID).AsString())
+
+	storeGet := findSpan(spans, "MailStore.GetMail")
+	require.NotNil(t, storeGet)
+	assert.Equal(t, codes.Error, storeGet.Status.Code)
+	assert.NotEmpty(t, storeGet.Events)
+}
+
+func TestManagerTracingCleanup(t *testing.T) {
+	tp, exporter := setupTracing(t)
+	manager := NewDefaultMailManager(NewMemoryMailStore(), WithTracerProvider(tp))
+	ctx := context.Background()
+
+	_, err := manager.SendMail(ctx, &Mail{RecipientID: "user1", ExpireTime: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+
+	// The background cleanup run gets its own root span
+	manager.runCleanup(ctx)
+
+	spans := exporter.GetSpans()
+	cleanup := findSpan(spans, "MailManager.cleanup")
+	require.NotNil(t, cleanup)
+	assert.False(t, cleanup.Parent.IsValid())
+	assert.Equal(t, int64(1), spanAttribute(cleanup, AttrMailCount).AsInt64())
+
+	deleteExpired := findSpan(spans, "MailManager.DeleteExpiredMails")
+	require.NotNil(t, deleteExpired)
+	assert.Equal(t, cleanup.SpanContext.SpanID(), deleteExpired.Parent.SpanID())
+}
+
+func TestTracingMailStore(t *testing.T) {
+	tp, exporter := setupTracing(t)
+	store := NewTracingMailStore(NewMemoryMailStore(), tp)
+	ctx := context.Background()
+
+	_, err := store.CreateBatchMails(ctx, []*Mail{{RecipientID: "user1"}, {RecipientID: "user1"}})
+	require.NoError(t, err)
+	read := true
+	count, err := store.UpdateByFilter(ctx, &MailFilter{RecipientID: "user1"}, &MailPatch{ReadStatus: &read})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 2)
+
+	update := findSpan(spans, "MailStore.UpdateByFilter")
+	require.NotNil(t, update)
+	assert.Equal(t, "user1", spanAttribute(update, AttrFilterRecipient).AsString())
+	assert.Equal(t, int64(2), spanAttribute(update, AttrMailCount).AsInt64())
+}